@@ -0,0 +1,190 @@
+package apierror
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Code is a stable, machine-readable error identifier clients can branch on.
+type Code string
+
+const (
+	CodeBadRequest         Code = "bad_request"
+	CodeValidation         Code = "validation_failed"
+	CodeUnauthenticated    Code = "unauthenticated"
+	CodeTokenMissing       Code = "token_missing"
+	CodeTokenExpired       Code = "token_expired"
+	CodeTokenInvalid       Code = "token_invalid"
+	CodeTokenRevoked       Code = "token_revoked"
+	CodeResetTokenInvalid  Code = "reset_token_invalid"
+	CodeVerifyTokenInvalid Code = "verification_token_invalid"
+	CodeEmailUnverified    Code = "email_unverified"
+	CodeEmailVerified      Code = "email_already_verified"
+	CodeOTPInvalid         Code = "otp_invalid"
+	CodeChallengeInvalid   Code = "challenge_invalid"
+	CodeTwoFactorInvalid   Code = "two_factor_code_invalid"
+	CodeTwoFactorRequired  Code = "two_factor_required"
+	CodeTwoFactorEnabled   Code = "two_factor_already_enabled"
+	CodeTwoFactorDisabled  Code = "two_factor_not_enabled"
+	CodeInvalidCredentials Code = "invalid_credentials"
+	CodeOIDCStateInvalid   Code = "oidc_state_invalid"
+	CodeOIDCLoginFailed    Code = "oidc_login_failed"
+	CodeOIDCEmail          Code = "oidc_email_unverified"
+	CodeOIDCProvider       Code = "oidc_provider_error"
+	CodeOIDCConflict       Code = "oidc_account_conflict"
+	CodeEmailTaken         Code = "email_taken"
+	CodePhoneTaken         Code = "phone_taken"
+	CodeRouteNotFound      Code = "route_not_found"
+	CodeUserNotFound       Code = "user_not_found"
+	CodeProductNotFound    Code = "product_not_found"
+	CodeAddressNotFound    Code = "address_not_found"
+	CodeOrderNotFound      Code = "order_not_found"
+	CodeOrderNotPending    Code = "order_not_pending"
+	CodeSessionNotFound    Code = "session_not_found"
+	CodeProviderNotFound   Code = "oidc_provider_not_found"
+	CodeCartItemNotFound   Code = "cart_item_not_found"
+	CodeCartEmpty          Code = "cart_empty"
+	CodeCartChanged        Code = "cart_changed"
+	CodeOutOfStock         Code = "out_of_stock"
+	CodeShippingOption     Code = "invalid_shipping_option"
+	CodeShippingUnavail    Code = "shipping_unavailable"
+	CodePaymentGateway     Code = "payment_gateway_error"
+	CodePaymentFailed      Code = "payment_verification_failed"
+	CodeInternal           Code = "internal_error"
+	CodeClientClosed       Code = "client_closed_request"
+	CodeTimeout            Code = "timeout"
+	CodeRateLimited        Code = "rate_limited"
+	CodeRequestTooLarge    Code = "request_too_large"
+)
+
+// FieldError describes a problem with one request field.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Error is an API error with the HTTP status it renders as. The wrapped cause
+// is for server-side logging only and is never sent to clients.
+type Error struct {
+	Status  int
+	Code    Code
+	Message string
+	Details []FieldError
+	cause   error
+}
+
+// Body is the JSON error envelope. "error" stays a human-readable string so
+// existing clients keep working.
+type Body struct {
+	Error     string       `json:"error"`
+	Code      Code         `json:"code"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+func New(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is matches any error with the same code, so copies made by Wrap and
+// WithMessage still satisfy errors.Is against the sentinels below.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Wrap returns a copy of e with err recorded as the underlying cause.
+func (e *Error) Wrap(err error) *Error {
+	cp := *e
+	cp.cause = err
+	return &cp
+}
+
+// WithMessage returns a copy of e with a different message.
+func (e *Error) WithMessage(message string) *Error {
+	cp := *e
+	cp.Message = message
+	return &cp
+}
+
+// WithDetails returns a copy of e carrying field-level details.
+func (e *Error) WithDetails(details ...FieldError) *Error {
+	cp := *e
+	cp.Details = append(append([]FieldError{}, e.Details...), details...)
+	return &cp
+}
+
+func (e *Error) Body() Body {
+	return Body{Error: e.Message, Code: e.Code, Details: e.Details}
+}
+
+// From converts any error into an *Error, treating unknown errors as internal.
+func From(err error) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return Internal("Internal server error").Wrap(err)
+}
+
+func BadRequest(code Code, message string) *Error {
+	return New(http.StatusBadRequest, code, message)
+}
+
+func Validation(message string, details ...FieldError) *Error {
+	return &Error{Status: http.StatusBadRequest, Code: CodeValidation, Message: message, Details: details}
+}
+
+func Unauthorized(code Code, message string) *Error {
+	return New(http.StatusUnauthorized, code, message)
+}
+
+func Forbidden(code Code, message string) *Error {
+	return New(http.StatusForbidden, code, message)
+}
+
+func NotFound(code Code, message string) *Error {
+	return New(http.StatusNotFound, code, message)
+}
+
+func Conflict(code Code, message string) *Error {
+	return New(http.StatusConflict, code, message)
+}
+
+func Internal(message string) *Error {
+	return New(http.StatusInternalServerError, CodeInternal, message)
+}
+
+var (
+	ErrUnauthenticated   = Unauthorized(CodeUnauthenticated, "User not authenticated")
+	ErrUserNotFound      = NotFound(CodeUserNotFound, "User not found")
+	ErrProductNotFound   = NotFound(CodeProductNotFound, "Product not found")
+	ErrAddressNotFound   = NotFound(CodeAddressNotFound, "Address not found")
+	ErrOrderNotFound     = NotFound(CodeOrderNotFound, "Order not found")
+	ErrSessionNotFound   = NotFound(CodeSessionNotFound, "Session not found")
+	ErrCartItemNotFound  = NotFound(CodeCartItemNotFound, "Cart item not found")
+	ErrCartEmpty         = BadRequest(CodeCartEmpty, "Cart is empty")
+	ErrOutOfStock        = Conflict(CodeOutOfStock, "Insufficient stock")
+	ErrRouteNotFound     = NotFound(CodeRouteNotFound, "Route not found")
+	ErrClientClosed      = New(StatusClientClosedRequest, CodeClientClosed, "Client closed the request")
+	ErrTimeout           = New(http.StatusGatewayTimeout, CodeTimeout, "The request timed out")
+	ErrRateLimited       = New(http.StatusTooManyRequests, CodeRateLimited, "Too many attempts, please try again later")
+	ErrRequestTooLarge   = New(http.StatusRequestEntityTooLarge, CodeRequestTooLarge, "Request body is too large")
+	ErrEmailUnverified   = Forbidden(CodeEmailUnverified, "Verify your email address to continue")
+	ErrTwoFactorRequired = Forbidden(CodeTwoFactorRequired, "Admins must enable two-factor authentication")
+)
+
+// StatusClientClosedRequest is the non-standard status (from nginx) recorded
+// when the client disconnects before the response is ready.
+const StatusClientClosedRequest = 499
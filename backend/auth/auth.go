@@ -0,0 +1,77 @@
+// Package auth describes who a request is made by. middleware.Authenticate
+// stores a Principal for every authenticated request; handlers read it with
+// Get, and code that only has a context.Context with FromContext.
+package auth
+
+import (
+	"context"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How a session was established, carried in its tokens.
+const (
+	MethodPassword = "password"
+	// MethodOTP is a code texted to the user's phone.
+	MethodOTP = "otp"
+	// MethodTwoFactor is a password or texted code followed by a TOTP or
+	// recovery code.
+	MethodTwoFactor = "two_factor"
+	// MethodOIDC is a login through an OpenID Connect provider.
+	MethodOIDC = "oidc"
+)
+
+// Principal is the authenticated user behind a request.
+type Principal struct {
+	UserID string
+	Email  string
+	// Roles holds the user's roles, such as models.RoleCustomer.
+	Roles []string
+	// TokenID is the jti of the access token presented, and SessionID the
+	// session it belongs to.
+	TokenID   string
+	SessionID string
+	// AuthMethod is how the session was established, one of the Method
+	// constants.
+	AuthMethod    string
+	EmailVerified bool
+}
+
+// HasRole reports whether the principal has role.
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// ginKey is where Set keeps the principal among the gin context keys.
+const ginKey = "auth.principal"
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// FromContext returns the principal stored in ctx, if any.
+func FromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok && p != nil
+}
+
+// Set records p as the principal of the request, both on c and on its
+// request context, so contexts derived from the request carry it too.
+func Set(c *gin.Context, p *Principal) {
+	c.Set(ginKey, p)
+	c.Request = c.Request.WithContext(WithPrincipal(c.Request.Context(), p))
+}
+
+// Get returns the principal of an authenticated request.
+func Get(c *gin.Context) (*Principal, bool) {
+	value, ok := c.Get(ginKey)
+	if !ok {
+		return nil, false
+	}
+	p, ok := value.(*Principal)
+	return p, ok && p != nil
+}
@@ -0,0 +1,40 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPrincipalInContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if _, ok := Get(c); ok {
+		t.Fatal("principal found before one was set")
+	}
+	if _, ok := FromContext(c.Request.Context()); ok {
+		t.Fatal("principal found in the request context before one was set")
+	}
+
+	want := &Principal{UserID: "user-1", Roles: []string{"customer"}, AuthMethod: MethodPassword}
+	Set(c, want)
+
+	// Handlers and anything given the request context see the same principal
+	if got, ok := Get(c); !ok || got != want {
+		t.Fatalf("Get = %v, %v", got, ok)
+	}
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+	if got, ok := FromContext(ctx); !ok || got != want {
+		t.Fatalf("FromContext = %v, %v", got, ok)
+	}
+
+	if !want.HasRole("customer") || want.HasRole("admin") {
+		t.Fatalf("HasRole wrong for roles %v", want.Roles)
+	}
+}
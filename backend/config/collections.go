@@ -13,4 +13,3 @@ func InitCollections() {
 		ProductCollection = DB.Collection("products")
 	}
 }
-
@@ -0,0 +1,675 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+
+	"ecomm-backend/logging"
+	"ecomm-backend/notify"
+	"ecomm-backend/oidc"
+	"ecomm-backend/payments"
+	"ecomm-backend/ratelimit"
+	"ecomm-backend/shipping"
+	"ecomm-backend/signing"
+	"ecomm-backend/tax"
+	"ecomm-backend/tracing"
+)
+
+const (
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
+	EnvTest        = "test"
+)
+
+// DefaultJWTSecret is only acceptable outside production.
+const DefaultJWTSecret = "your-secret-key-here"
+
+// minProductionSecretLen is the shortest JWT secret allowed in production.
+const minProductionSecretLen = 32
+
+// Config is the whole application configuration. It is loaded once at
+// startup and handed to the components that need it.
+type Config struct {
+	Env       string          `yaml:"env"`
+	Port      string          `yaml:"port"`
+	Server    ServerConfig    `yaml:"server"`
+	Log       logging.Config  `yaml:"log"`
+	Tracing   tracing.Config  `yaml:"tracing"`
+	Database  DatabaseConfig  `yaml:"database"`
+	Auth      AuthConfig      `yaml:"auth"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	Notify    notify.Config   `yaml:"notify"`
+	SMS       notify.Config   `yaml:"sms"`
+	Payments  payments.Config `yaml:"payments"`
+	Tax       tax.Config      `yaml:"tax"`
+	Shipping  shipping.Config `yaml:"shipping"`
+}
+
+// ServerConfig sets the HTTP server timeouts. RequestTimeout bounds the work
+// a handler does for one request, and ShutdownTimeout is how long in-flight
+// requests get to finish after SIGINT or SIGTERM. TrustedProxies lists the
+// addresses or CIDRs of the proxies whose X-Forwarded-For and X-Real-IP
+// headers name the client; by default none are trusted and the client is
+// the connecting address.
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	RequestTimeout    time.Duration `yaml:"request_timeout"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+	TrustedProxies    []string      `yaml:"trusted_proxies"`
+}
+
+// DatabaseConfig tunes the MongoDB connection. Zero pool sizes keep the
+// driver defaults; an empty Name uses the database in the URI.
+// OperationTimeout bounds each repository call; zero leaves only the
+// request's deadline.
+type DatabaseConfig struct {
+	URI                    string        `yaml:"uri"`
+	Name                   string        `yaml:"name"`
+	MaxPoolSize            int           `yaml:"max_pool_size"`
+	MinPoolSize            int           `yaml:"min_pool_size"`
+	MaxConnIdleTime        time.Duration `yaml:"max_conn_idle_time"`
+	ConnectTimeout         time.Duration `yaml:"connect_timeout"`
+	ServerSelectionTimeout time.Duration `yaml:"server_selection_timeout"`
+	OperationTimeout       time.Duration `yaml:"operation_timeout"`
+	// ReadPreference is primary, primaryPreferred, secondary,
+	// secondaryPreferred or nearest.
+	ReadPreference string `yaml:"read_preference"`
+	// WriteConcern is "majority" or a node count such as "1".
+	WriteConcern string `yaml:"write_concern"`
+}
+
+// AuthConfig holds the token, password and recovery settings. Tokens are
+// signed with the keys described by Signing and name TokenIssuer as their
+// issuer and TokenAudience as their audience; JWTSecret keys the hashes of
+// login codes and recovery codes and seals OpenID Connect login state.
+// Reset links are PasswordResetURL with the
+// token appended as the "token" query parameter, and stay valid for
+// PasswordResetTTL.
+type AuthConfig struct {
+	Signing           signing.Config          `yaml:"signing"`
+	TokenIssuer       string                  `yaml:"token_issuer"`
+	TokenAudience     string                  `yaml:"token_audience"`
+	JWTSecret         string                  `yaml:"jwt_secret"`
+	BcryptCost        int                     `yaml:"bcrypt_cost"`
+	Lockout           LockoutConfig           `yaml:"lockout"`
+	PasswordResetURL  string                  `yaml:"password_reset_url"`
+	PasswordResetTTL  time.Duration           `yaml:"password_reset_ttl"`
+	EmailVerification EmailVerificationConfig `yaml:"email_verification"`
+	OTP               OTPConfig               `yaml:"otp"`
+	TwoFactor         TwoFactorConfig         `yaml:"two_factor"`
+	OIDC              OIDCConfig              `yaml:"oidc"`
+}
+
+// OIDCConfig lists the OpenID Connect providers users can log in with.
+// StateTTL is how long a user has to finish logging in at the provider.
+type OIDCConfig struct {
+	Providers []oidc.Config `yaml:"providers"`
+	StateTTL  time.Duration `yaml:"state_ttl"`
+}
+
+// Validate reports invalid or duplicate providers and a non-positive state
+// TTL.
+func (o OIDCConfig) Validate() error {
+	var errs []error
+	seen := map[string]bool{}
+	for _, provider := range o.Providers {
+		if err := provider.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+		if seen[provider.Name] {
+			errs = append(errs, fmt.Errorf("oidc provider %s is configured twice", provider.Name))
+		}
+		seen[provider.Name] = true
+	}
+	if o.StateTTL <= 0 {
+		errs = append(errs, errors.New("oidc state ttl must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// TwoFactorConfig controls TOTP two-factor authentication. Issuer names the
+// account in authenticator apps, and ChallengeTTL is how long a user has to
+// enter their code after the password step of login.
+type TwoFactorConfig struct {
+	Issuer       string        `yaml:"issuer"`
+	ChallengeTTL time.Duration `yaml:"challenge_ttl"`
+}
+
+// OTPConfig controls the one-time codes sent by SMS for phone login. A code
+// has Length digits, lasts for TTL and allows MaxAttempts guesses. A new
+// code is sent at most once per ResendInterval.
+type OTPConfig struct {
+	Length         int           `yaml:"length"`
+	TTL            time.Duration `yaml:"ttl"`
+	MaxAttempts    int           `yaml:"max_attempts"`
+	ResendInterval time.Duration `yaml:"resend_interval"`
+}
+
+// Validate reports a code length outside 4 to 10 digits, a non-positive TTL
+// or attempt limit, or a negative resend interval.
+func (o OTPConfig) Validate() error {
+	var errs []error
+	if o.Length < 4 || o.Length > 10 {
+		errs = append(errs, fmt.Errorf("otp length must be between 4 and 10; got %d", o.Length))
+	}
+	if o.TTL <= 0 {
+		errs = append(errs, errors.New("otp ttl must be positive"))
+	}
+	if o.MaxAttempts < 1 {
+		errs = append(errs, errors.New("otp max attempts must be at least 1"))
+	}
+	if o.ResendInterval < 0 {
+		errs = append(errs, errors.New("otp resend interval cannot be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// EmailVerificationConfig controls the links that verify a user's email
+// address. The token is appended to URL, which can point straight at
+// GET /api/auth/verify-email or at a frontend page that calls it. Links may
+// be resent once per ResendInterval. RequiredFor lists the actions
+// unverified users are refused.
+type EmailVerificationConfig struct {
+	URL            string        `yaml:"url"`
+	TTL            time.Duration `yaml:"ttl"`
+	ResendInterval time.Duration `yaml:"resend_interval"`
+	RequiredFor    []string      `yaml:"required_for"`
+}
+
+// Actions that can require a verified email.
+const (
+	ActionCheckout = "checkout" // checkout and payments
+	ActionCart     = "cart"     // changing the cart
+	ActionAddress  = "address"  // changing saved addresses
+)
+
+var verifiableActions = []string{ActionCheckout, ActionCart, ActionAddress}
+
+// Validate reports a relative URL, a non-positive TTL, a negative resend
+// interval or an unknown action.
+func (v EmailVerificationConfig) Validate() error {
+	var errs []error
+	if u, err := url.Parse(v.URL); err != nil || !u.IsAbs() {
+		errs = append(errs, fmt.Errorf("email verification url must be an absolute URL; got %q", v.URL))
+	}
+	if v.TTL <= 0 {
+		errs = append(errs, errors.New("email verification ttl must be positive"))
+	}
+	if v.ResendInterval < 0 {
+		errs = append(errs, errors.New("email verification resend interval cannot be negative"))
+	}
+	for _, action := range v.RequiredFor {
+		if !slices.Contains(verifiableActions, action) {
+			errs = append(errs, fmt.Errorf("email verification cannot be required for %q; use %s",
+				action, strings.Join(verifiableActions, ", ")))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Requires reports whether action needs a verified email.
+func (v EmailVerificationConfig) Requires(action string) bool {
+	return slices.Contains(v.RequiredFor, action)
+}
+
+// LockoutConfig locks an account after Threshold consecutive failed logins,
+// for Duration at first and twice as long after each further failure, up to
+// MaxDuration. A zero Threshold disables lockout.
+type LockoutConfig struct {
+	Threshold   int           `yaml:"threshold"`
+	Duration    time.Duration `yaml:"duration"`
+	MaxDuration time.Duration `yaml:"max_duration"`
+}
+
+// LockFor returns how long to lock an account after failures consecutive
+// failed logins, or zero while it is under the threshold.
+func (l LockoutConfig) LockFor(failures int) time.Duration {
+	if l.Threshold <= 0 || failures < l.Threshold {
+		return 0
+	}
+	d := l.Duration
+	for i := l.Threshold; i < failures && d < l.MaxDuration; i++ {
+		d *= 2
+	}
+	if l.MaxDuration > 0 && d > l.MaxDuration {
+		d = l.MaxDuration
+	}
+	return d
+}
+
+// RateLimitConfig limits the public auth endpoints. IP applies to each client
+// address on each route and Account to each email or phone number tried,
+// whether or not an account exists for it.
+type RateLimitConfig struct {
+	IP      ratelimit.Limit `yaml:"ip"`
+	Account ratelimit.Limit `yaml:"account"`
+}
+
+// Default returns the development configuration.
+func Default() *Config {
+	return &Config{
+		Env:  EnvDevelopment,
+		Port: "8080",
+		Server: ServerConfig{
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			RequestTimeout:    10 * time.Second,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		Log: logging.Config{Level: "info", Format: "json"},
+		Tracing: tracing.Config{
+			Exporter:    tracing.ExporterNone,
+			ServiceName: "ecomm-backend",
+			SampleRatio: 1,
+		},
+		Database: DatabaseConfig{
+			URI:                    "mongodb://localhost:27017/ecomm",
+			MaxPoolSize:            100,
+			ConnectTimeout:         10 * time.Second,
+			ServerSelectionTimeout: 10 * time.Second,
+			OperationTimeout:       5 * time.Second,
+			ReadPreference:         "primary",
+			WriteConcern:           "majority",
+		},
+		Auth: AuthConfig{
+			Signing: signing.Config{
+				Algorithm:   signing.AlgorithmRS256,
+				RotateEvery: 30 * 24 * time.Hour,
+				RetainFor:   8 * 24 * time.Hour,
+			},
+			TokenIssuer:   "http://localhost:8080",
+			TokenAudience: "ecomm-api",
+			JWTSecret:     DefaultJWTSecret,
+			BcryptCost:    14,
+			Lockout: LockoutConfig{
+				Threshold:   5,
+				Duration:    time.Minute,
+				MaxDuration: time.Hour,
+			},
+			PasswordResetURL: "http://localhost:3000/reset-password",
+			PasswordResetTTL: time.Hour,
+			EmailVerification: EmailVerificationConfig{
+				URL:            "http://localhost:8080/api/auth/verify-email",
+				TTL:            24 * time.Hour,
+				ResendInterval: time.Minute,
+				RequiredFor:    []string{ActionCheckout},
+			},
+			OTP: OTPConfig{
+				Length:         6,
+				TTL:            5 * time.Minute,
+				MaxAttempts:    5,
+				ResendInterval: 30 * time.Second,
+			},
+			TwoFactor: TwoFactorConfig{
+				Issuer:       "ecomm",
+				ChallengeTTL: 5 * time.Minute,
+			},
+			OIDC: OIDCConfig{StateTTL: 10 * time.Minute},
+		},
+		RateLimit: RateLimitConfig{
+			IP:      ratelimit.Limit{Burst: 20, Per: time.Minute},
+			Account: ratelimit.Limit{Burst: 10, Per: time.Minute},
+		},
+		Notify:   notify.Config{Sink: notify.SinkLog},
+		SMS:      notify.Config{Sink: notify.SinkLog},
+		Tax:      tax.Config{OriginState: "MH"},
+		Shipping: shipping.Config{OriginPin: shipping.DefaultOriginPin},
+	}
+}
+
+// Load builds the configuration from, in increasing precedence, the defaults,
+// the YAML file named by CONFIG_FILE and environment variables (including
+// those in a .env file). The result is validated before it is returned.
+func Load() (*Config, error) {
+	// .env is optional and never overrides the real environment
+	if err := godotenv.Load(); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read .env: %w", err)
+	}
+
+	cfg := Default()
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (c *Config) loadEnv() error {
+	stringVars := map[string]*string{
+		"APP_ENV":                     &c.Env,
+		"PORT":                        &c.Port,
+		"LOG_LEVEL":                   &c.Log.Level,
+		"LOG_FORMAT":                  &c.Log.Format,
+		"TRACING_EXPORTER":            &c.Tracing.Exporter,
+		"OTEL_EXPORTER_OTLP_ENDPOINT": &c.Tracing.Endpoint,
+		"OTEL_SERVICE_NAME":           &c.Tracing.ServiceName,
+		"MONGODB_URI":                 &c.Database.URI,
+		"MONGODB_DATABASE":            &c.Database.Name,
+		"MONGODB_READ_PREF":           &c.Database.ReadPreference,
+		"MONGODB_WRITE_CONCERN":       &c.Database.WriteConcern,
+		"SECRET_LOVE":                 &c.Auth.JWTSecret,
+		"JWT_ALGORITHM":               &c.Auth.Signing.Algorithm,
+		"JWT_KEYS_DIR":                &c.Auth.Signing.KeysDir,
+		"JWT_ISSUER":                  &c.Auth.TokenIssuer,
+		"JWT_AUDIENCE":                &c.Auth.TokenAudience,
+		"PASSWORD_RESET_URL":          &c.Auth.PasswordResetURL,
+		"EMAIL_VERIFICATION_URL":      &c.Auth.EmailVerification.URL,
+		"NOTIFY_SINK":                 &c.Notify.Sink,
+		"NOTIFY_FILE":                 &c.Notify.File,
+		"NOTIFY_WEBHOOK_URL":          &c.Notify.URL,
+		"NOTIFY_WEBHOOK_TOKEN":        &c.Notify.WebhookToken,
+		"SMS_SINK":                    &c.SMS.Sink,
+		"SMS_FILE":                    &c.SMS.File,
+		"SMS_WEBHOOK_URL":             &c.SMS.URL,
+		"SMS_WEBHOOK_TOKEN":           &c.SMS.WebhookToken,
+		"TOTP_ISSUER":                 &c.Auth.TwoFactor.Issuer,
+		"RAZORPAY_KEY":                &c.Payments.RazorpayKey,
+		"RAZORPAY_SECRET":             &c.Payments.RazorpaySecret,
+		"GST_ORIGIN_STATE":            &c.Tax.OriginState,
+		"TAX_RULES_FILE":              &c.Tax.RulesFile,
+		"SHIPPING_ORIGIN_PIN":         &c.Shipping.OriginPin,
+		"SHIPPING_RATES_FILE":         &c.Shipping.RatesFile,
+	}
+	for name, field := range stringVars {
+		if value, ok := os.LookupEnv(name); ok && value != "" {
+			*field = value
+		}
+	}
+
+	intVars := map[string]*int{
+		"BCRYPT_COST":              &c.Auth.BcryptCost,
+		"MONGODB_MAX_POOL_SIZE":    &c.Database.MaxPoolSize,
+		"MONGODB_MIN_POOL_SIZE":    &c.Database.MinPoolSize,
+		"LOCKOUT_THRESHOLD":        &c.Auth.Lockout.Threshold,
+		"RATE_LIMIT_IP_BURST":      &c.RateLimit.IP.Burst,
+		"RATE_LIMIT_ACCOUNT_BURST": &c.RateLimit.Account.Burst,
+		"OTP_LENGTH":               &c.Auth.OTP.Length,
+		"OTP_MAX_ATTEMPTS":         &c.Auth.OTP.MaxAttempts,
+	}
+	for name, field := range intVars {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a number: %w", name, err)
+		}
+		*field = n
+	}
+
+	durationVars := map[string]*time.Duration{
+		"REQUEST_TIMEOUT":                  &c.Server.RequestTimeout,
+		"SHUTDOWN_TIMEOUT":                 &c.Server.ShutdownTimeout,
+		"MONGODB_OPERATION_TIMEOUT":        &c.Database.OperationTimeout,
+		"LOCKOUT_DURATION":                 &c.Auth.Lockout.Duration,
+		"PASSWORD_RESET_TTL":               &c.Auth.PasswordResetTTL,
+		"EMAIL_VERIFICATION_TTL":           &c.Auth.EmailVerification.TTL,
+		"EMAIL_VERIFICATION_RESEND":        &c.Auth.EmailVerification.ResendInterval,
+		"OTP_TTL":                          &c.Auth.OTP.TTL,
+		"OTP_RESEND":                       &c.Auth.OTP.ResendInterval,
+		"TWO_FACTOR_CHALLENGE_TTL":         &c.Auth.TwoFactor.ChallengeTTL,
+		"OIDC_STATE_TTL":                   &c.Auth.OIDC.StateTTL,
+		"JWT_ROTATE_EVERY":                 &c.Auth.Signing.RotateEvery,
+		"JWT_RETAIN_FOR":                   &c.Auth.Signing.RetainFor,
+		"LOCKOUT_MAX_DURATION":             &c.Auth.Lockout.MaxDuration,
+		"RATE_LIMIT_IP_PER":                &c.RateLimit.IP.Per,
+		"RATE_LIMIT_ACCOUNT_PER":           &c.RateLimit.Account.Per,
+		"MONGODB_MAX_CONN_IDLE_TIME":       &c.Database.MaxConnIdleTime,
+		"MONGODB_CONNECT_TIMEOUT":          &c.Database.ConnectTimeout,
+		"MONGODB_SERVER_SELECTION_TIMEOUT": &c.Database.ServerSelectionTimeout,
+	}
+	for name, field := range durationVars {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s must be a duration such as 10s: %w", name, err)
+		}
+		*field = d
+	}
+
+	floatVars := map[string]*float64{
+		"TRACING_SAMPLE_RATIO": &c.Tracing.SampleRatio,
+	}
+	for name, field := range floatVars {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s must be a number: %w", name, err)
+		}
+		*field = f
+	}
+
+	// Comma-separated; "none" clears the list
+	listVars := map[string]*[]string{
+		"EMAIL_VERIFICATION_REQUIRED_FOR": &c.Auth.EmailVerification.RequiredFor,
+		"TRUSTED_PROXIES":                 &c.Server.TrustedProxies,
+	}
+	for name, field := range listVars {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			continue
+		}
+		*field = nil
+		if strings.EqualFold(value, "none") {
+			continue
+		}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field = append(*field, strings.ToLower(item))
+			}
+		}
+	}
+	c.loadOIDCEnv()
+	return nil
+}
+
+// loadOIDCEnv sets up the providers named in OIDC_PROVIDERS from
+// OIDC_<NAME>_TYPE, _ISSUER, _CLIENT_ID, _CLIENT_SECRET, _REDIRECT_URL and
+// _SCOPES (space-separated), on top of any the config file describes.
+func (c *Config) loadOIDCEnv() {
+	names, ok := os.LookupEnv("OIDC_PROVIDERS")
+	if !ok || names == "" {
+		return
+	}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		i := slices.IndexFunc(c.Auth.OIDC.Providers, func(p oidc.Config) bool { return p.Name == name })
+		if i < 0 {
+			c.Auth.OIDC.Providers = append(c.Auth.OIDC.Providers, oidc.Config{Name: name})
+			i = len(c.Auth.OIDC.Providers) - 1
+		}
+		provider := &c.Auth.OIDC.Providers[i]
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		fields := map[string]*string{
+			"TYPE":          &provider.Type,
+			"ISSUER":        &provider.Issuer,
+			"CLIENT_ID":     &provider.ClientID,
+			"CLIENT_SECRET": &provider.ClientSecret,
+			"REDIRECT_URL":  &provider.RedirectURL,
+		}
+		for suffix, field := range fields {
+			if value, ok := os.LookupEnv(prefix + suffix); ok && value != "" {
+				*field = value
+			}
+		}
+		if value, ok := os.LookupEnv(prefix + "SCOPES"); ok && value != "" {
+			provider.Scopes = strings.Fields(value)
+		}
+	}
+}
+
+// IsProduction reports whether the app runs in production mode.
+func (c *Config) IsProduction() bool {
+	return c.Env == EnvProduction
+}
+
+// Validate reports every problem with the configuration at once. Production
+// refuses the development JWT secret, the mock payment gateway and the log
+// and file notification sinks, which would leave reset links and login codes
+// readable by anyone with the logs.
+func (c *Config) Validate() error {
+	var errs []error
+
+	switch c.Env {
+	case EnvDevelopment, EnvProduction, EnvTest:
+	default:
+		errs = append(errs, fmt.Errorf("env must be one of development, production, test; got %q", c.Env))
+	}
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be between 1 and 65535; got %q", c.Port))
+	}
+	if c.Server.ReadHeaderTimeout < 0 || c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 ||
+		c.Server.IdleTimeout < 0 || c.Server.RequestTimeout < 0 || c.Server.ShutdownTimeout < 0 {
+		errs = append(errs, errors.New("server timeouts cannot be negative"))
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			errs = append(errs, fmt.Errorf("trusted proxy must be an IP address or CIDR; got %q", proxy))
+		}
+	}
+	if err := c.Log.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Tracing.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Database.URI == "" {
+		errs = append(errs, errors.New("database uri is required"))
+	}
+	if c.Database.MinPoolSize < 0 || c.Database.MaxPoolSize < 0 {
+		errs = append(errs, errors.New("database pool sizes cannot be negative"))
+	}
+	if c.Database.MaxPoolSize > 0 && c.Database.MinPoolSize > c.Database.MaxPoolSize {
+		errs = append(errs, errors.New("database min pool size cannot exceed max pool size"))
+	}
+	if c.Database.ConnectTimeout < 0 || c.Database.ServerSelectionTimeout < 0 || c.Database.MaxConnIdleTime < 0 ||
+		c.Database.OperationTimeout < 0 {
+		errs = append(errs, errors.New("database timeouts cannot be negative"))
+	}
+	if _, err := c.Database.ClientOptions(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Auth.Signing.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Auth.TokenIssuer == "" || c.Auth.TokenAudience == "" {
+		errs = append(errs, errors.New("token issuer and audience are required"))
+	}
+	if c.Auth.JWTSecret == "" {
+		errs = append(errs, errors.New("jwt secret is required"))
+	}
+	if c.Auth.BcryptCost < bcrypt.MinCost || c.Auth.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	if c.Auth.Lockout.Threshold < 0 || c.Auth.Lockout.Duration < 0 || c.Auth.Lockout.MaxDuration < 0 {
+		errs = append(errs, errors.New("lockout settings cannot be negative"))
+	}
+	if c.Auth.Lockout.Threshold > 0 && c.Auth.Lockout.Duration == 0 {
+		errs = append(errs, errors.New("lockout needs a duration"))
+	}
+	if c.Auth.PasswordResetTTL <= 0 {
+		errs = append(errs, errors.New("password reset ttl must be positive"))
+	}
+	if u, err := url.Parse(c.Auth.PasswordResetURL); err != nil || !u.IsAbs() {
+		errs = append(errs, fmt.Errorf("password reset url must be an absolute URL; got %q", c.Auth.PasswordResetURL))
+	}
+	if err := c.Auth.EmailVerification.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Auth.OTP.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Auth.TwoFactor.Issuer == "" {
+		errs = append(errs, errors.New("two-factor issuer is required"))
+	}
+	if c.Auth.TwoFactor.ChallengeTTL <= 0 {
+		errs = append(errs, errors.New("two-factor challenge ttl must be positive"))
+	}
+	if err := c.Auth.OIDC.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.Notify.Validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if err := c.SMS.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("sms: %w", err))
+	}
+	if err := c.RateLimit.IP.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("ip %w", err))
+	}
+	if err := c.RateLimit.Account.Validate(); err != nil {
+		errs = append(errs, fmt.Errorf("account %w", err))
+	}
+	if c.Tax.OriginState != "" && tax.NormalizeState(c.Tax.OriginState) == "" {
+		errs = append(errs, fmt.Errorf("unknown GST origin state %q", c.Tax.OriginState))
+	}
+
+	if c.IsProduction() {
+		if c.Auth.JWTSecret == DefaultJWTSecret || len(c.Auth.JWTSecret) < minProductionSecretLen {
+			errs = append(errs, fmt.Errorf("production requires a jwt secret of at least %d characters that is not the default", minProductionSecretLen))
+		}
+		// Every instance has to sign with, and accept, the same keys
+		if c.Auth.Signing.KeysDir == "" {
+			errs = append(errs, errors.New("production requires a jwt keys dir"))
+		}
+		if !c.Payments.Live() {
+			errs = append(errs, errors.New("production requires razorpay key and secret"))
+		}
+		if c.Notify.Local() {
+			errs = append(errs, errors.New("production requires the webhook notify sink"))
+		}
+		if c.SMS.Local() {
+			errs = append(errs, errors.New("production requires the webhook sms sink"))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"ecomm-backend/notify"
+	"ecomm-backend/oidc"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := "port: \"9000\"\nauth:\n  bcrypt_cost: 10\ntax:\n  origin_state: KA\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("PORT", "9100")
+	for _, name := range []string{"APP_ENV", "MONGODB_URI", "BCRYPT_COST", "GST_ORIGIN_STATE"} {
+		t.Setenv(name, "")
+	}
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	// The environment beats the file, which beats the defaults
+	if cfg.Port != "9100" {
+		t.Errorf("port = %q, want 9100 from the environment", cfg.Port)
+	}
+	if cfg.Auth.BcryptCost != 10 || cfg.Tax.OriginState != "KA" {
+		t.Errorf("file values not applied: %+v", cfg)
+	}
+	if cfg.Database.URI == "" || cfg.Env != EnvDevelopment {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+}
+
+func TestVerificationRequiredForEnv(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"checkout, Cart", []string{ActionCheckout, ActionCart}},
+		{"none", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("EMAIL_VERIFICATION_REQUIRED_FOR", tt.value)
+			cfg := Default()
+			if err := cfg.loadEnv(); err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.Auth.EmailVerification.RequiredFor; !slices.Equal(got, tt.want) {
+				t.Fatalf("required for = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOIDCProvidersFromEnv(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.OIDC.Providers = []oidc.Config{{Name: "google", Issuer: "https://accounts.google.com", ClientID: "from-file"}}
+	t.Setenv("OIDC_PROVIDERS", "google, dex, github")
+	t.Setenv("OIDC_GOOGLE_CLIENT_SECRET", "google-secret")
+	t.Setenv("OIDC_DEX_ISSUER", "https://dex.example.com")
+	t.Setenv("OIDC_DEX_CLIENT_ID", "shop")
+	t.Setenv("OIDC_DEX_SCOPES", "openid email")
+	t.Setenv("OIDC_GITHUB_TYPE", "github")
+	if err := cfg.loadEnv(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Providers from the file are added to, not replaced
+	providers := cfg.Auth.OIDC.Providers
+	if len(providers) != 3 {
+		t.Fatalf("got %d providers, want 3: %+v", len(providers), providers)
+	}
+	if google := providers[0]; google.ClientID != "from-file" || google.ClientSecret != "google-secret" {
+		t.Errorf("google = %+v", google)
+	}
+	if dex := providers[1]; dex.Name != "dex" || dex.Issuer != "https://dex.example.com" || dex.ClientID != "shop" ||
+		!slices.Equal(dex.Scopes, []string{"openid", "email"}) {
+		t.Errorf("dex = %+v", dex)
+	}
+	if github := providers[2]; github.Name != "github" || github.Type != oidc.TypeGitHub {
+		t.Errorf("github = %+v", github)
+	}
+}
+
+func TestLoadRejectsBadValues(t *testing.T) {
+	t.Setenv("BCRYPT_COST", "lots")
+	if _, err := Load(); err == nil {
+		t.Fatal("expected an error for a non-numeric BCRYPT_COST")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	productionSecret := strings.Repeat("s", 32)
+	webhooks := func(c *Config) {
+		c.Notify = notify.Config{Sink: notify.SinkWebhook, URL: "https://relay.example/email"}
+		c.SMS = notify.Config{Sink: notify.SinkWebhook, URL: "https://relay.example/sms"}
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"unknown env", func(c *Config) { c.Env = "staging" }, "env must be one of"},
+		{"bad port", func(c *Config) { c.Port = "http" }, "port must be"},
+		{"trusted proxy", func(c *Config) { c.Server.TrustedProxies = []string{"10.0.0.0/8", "proxy.internal"} }, `trusted proxy must be an IP address or CIDR; got "proxy.internal"`},
+		{"bcrypt cost", func(c *Config) { c.Auth.BcryptCost = 99 }, "bcrypt cost"},
+		{"unknown state", func(c *Config) { c.Tax.OriginState = "Atlantis" }, "origin state"},
+		{"tracing exporter", func(c *Config) { c.Tracing.Exporter = "zipkin" }, "tracing exporter"},
+		{"sample ratio", func(c *Config) { c.Tracing.SampleRatio = 1.5 }, "sample ratio"},
+		{"rate limit period", func(c *Config) { c.RateLimit.IP.Per = 0 }, "ip rate limit of 20 needs a period"},
+		{"lockout duration", func(c *Config) { c.Auth.Lockout.Duration = 0 }, "lockout needs a duration"},
+		{"reset ttl", func(c *Config) { c.Auth.PasswordResetTTL = 0 }, "password reset ttl must be positive"},
+		{"reset url", func(c *Config) { c.Auth.PasswordResetURL = "/reset" }, "password reset url must be an absolute URL"},
+		{"notify sink", func(c *Config) { c.Notify.Sink = "pigeon" }, "notify sink must be log, file or webhook"},
+		{"notify webhook url", func(c *Config) { c.Notify.Sink = "webhook" }, "notify webhook sink needs an absolute URL"},
+		{"otp length", func(c *Config) { c.Auth.OTP.Length = 3 }, "otp length must be between 4 and 10"},
+		{"otp attempts", func(c *Config) { c.Auth.OTP.MaxAttempts = 0 }, "otp max attempts must be at least 1"},
+		{"signing algorithm", func(c *Config) { c.Auth.Signing.Algorithm = "HS256" }, "signing algorithm must be RS256 or EdDSA"},
+		{"signing rotation", func(c *Config) { c.Auth.Signing.RotateEvery = 0 }, "rotate_every and retain_for must be positive"},
+		{"token audience", func(c *Config) { c.Auth.TokenAudience = "" }, "token issuer and audience are required"},
+		{"two-factor challenge ttl", func(c *Config) { c.Auth.TwoFactor.ChallengeTTL = 0 }, "two-factor challenge ttl must be positive"},
+		{"sms sink", func(c *Config) { c.SMS.Sink = "file" }, "sms: notify file sink needs a file path"},
+		{"oidc state ttl", func(c *Config) { c.Auth.OIDC.StateTTL = 0 }, "oidc state ttl must be positive"},
+		{"oidc provider twice", func(c *Config) {
+			google := oidc.Config{Name: "google", Issuer: "https://accounts.google.com", ClientID: "id", RedirectURL: "https://shop.example/cb"}
+			c.Auth.OIDC.Providers = []oidc.Config{google, google}
+		}, "oidc provider google is configured twice"},
+		{"verification ttl", func(c *Config) { c.Auth.EmailVerification.TTL = 0 }, "email verification ttl must be positive"},
+		{"verification action", func(c *Config) { c.Auth.EmailVerification.RequiredFor = []string{"wishlist"} }, `email verification cannot be required for "wishlist"`},
+		{"production default secret", func(c *Config) {
+			c.Env = EnvProduction
+			c.Payments.RazorpayKey, c.Payments.RazorpaySecret = "key", "secret"
+			c.Auth.Signing.KeysDir = "/var/lib/ecomm/keys"
+			webhooks(c)
+		}, "jwt secret"},
+		{"production mock payments", func(c *Config) {
+			c.Env = EnvProduction
+			c.Auth.JWTSecret = productionSecret
+			c.Auth.Signing.KeysDir = "/var/lib/ecomm/keys"
+			webhooks(c)
+		}, "razorpay"},
+		{"production keys dir", func(c *Config) {
+			c.Env = EnvProduction
+			c.Auth.JWTSecret = productionSecret
+			c.Payments.RazorpayKey, c.Payments.RazorpaySecret = "key", "secret"
+			webhooks(c)
+		}, "jwt keys dir"},
+		{"production log notify sink", func(c *Config) {
+			c.Env = EnvProduction
+			c.Auth.JWTSecret = productionSecret
+			c.Payments.RazorpayKey, c.Payments.RazorpaySecret = "key", "secret"
+			c.Auth.Signing.KeysDir = "/var/lib/ecomm/keys"
+			webhooks(c)
+			c.Notify = notify.Config{Sink: notify.SinkLog}
+		}, "production requires the webhook notify sink"},
+		{"production file sms sink", func(c *Config) {
+			c.Env = EnvProduction
+			c.Auth.JWTSecret = productionSecret
+			c.Payments.RazorpayKey, c.Payments.RazorpaySecret = "key", "secret"
+			c.Auth.Signing.KeysDir = "/var/lib/ecomm/keys"
+			webhooks(c)
+			c.SMS = notify.Config{Sink: notify.SinkFile, File: "/var/log/sms.log"}
+		}, "production requires the webhook sms sink"},
+		{"production", func(c *Config) {
+			c.Env = EnvProduction
+			c.Auth.JWTSecret = productionSecret
+			c.Payments.RazorpayKey, c.Payments.RazorpaySecret = "key", "secret"
+			c.Auth.Signing.KeysDir = "/var/lib/ecomm/keys"
+			webhooks(c)
+		}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Fatalf("error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDatabaseName(t *testing.T) {
+	tests := []struct {
+		cfg  DatabaseConfig
+		want string
+	}{
+		{DatabaseConfig{URI: "mongodb://localhost:27017/shop"}, "shop"},
+		{DatabaseConfig{URI: "mongodb://a:1,b:2/shop?replicaSet=rs0"}, "shop"},
+		{DatabaseConfig{URI: "mongodb://localhost:27017/?replicaSet=rs0"}, DefaultDatabaseName},
+		{DatabaseConfig{URI: "mongodb://localhost:27017"}, DefaultDatabaseName},
+		{DatabaseConfig{URI: "mongodb://localhost:27017/shop", Name: "shop_test"}, "shop_test"},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.DatabaseName(); got != tt.want {
+			t.Errorf("DatabaseName(%+v) = %q, want %q", tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestClientOptions(t *testing.T) {
+	cfg := Default().Database
+	cfg.MinPoolSize = 5
+	cfg.ReadPreference = "secondaryPreferred"
+	cfg.WriteConcern = "2"
+
+	opts, err := cfg.ClientOptions()
+	if err != nil {
+		t.Fatalf("ClientOptions: %v", err)
+	}
+	if *opts.MaxPoolSize != 100 || *opts.MinPoolSize != 5 {
+		t.Errorf("pool = %d..%d, want 5..100", *opts.MinPoolSize, *opts.MaxPoolSize)
+	}
+	if opts.ReadPreference.Mode().String() != "secondaryPreferred" {
+		t.Errorf("read preference = %v", opts.ReadPreference.Mode())
+	}
+	if opts.WriteConcern.W != 2 {
+		t.Errorf("write concern = %v, want 2", opts.WriteConcern.W)
+	}
+
+	for _, bad := range []DatabaseConfig{
+		{URI: "mongodb://localhost", ReadPreference: "anywhere"},
+		{URI: "mongodb://localhost", WriteConcern: "all"},
+		{URI: "http://localhost"},
+	} {
+		if _, err := bad.ClientOptions(); err == nil {
+			t.Errorf("ClientOptions(%+v) succeeded, want an error", bad)
+		}
+	}
+}
+
+func TestLockFor(t *testing.T) {
+	l := LockoutConfig{Threshold: 3, Duration: time.Minute, MaxDuration: 5 * time.Minute}
+	want := map[int]time.Duration{
+		2:  0,
+		3:  time.Minute,
+		4:  2 * time.Minute,
+		5:  4 * time.Minute,
+		6:  5 * time.Minute,
+		50: 5 * time.Minute,
+	}
+	for failures, d := range want {
+		if got := l.LockFor(failures); got != d {
+			t.Errorf("LockFor(%d) = %v, want %v", failures, got, d)
+		}
+	}
+	if (LockoutConfig{}).LockFor(100) != 0 {
+		t.Error("zero threshold should never lock")
+	}
+}
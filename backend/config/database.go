@@ -2,26 +2,49 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"log/slog"
+	"strconv"
+	"strings"
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
+// DefaultDatabaseName is used when neither the config nor the URI names a database.
+const DefaultDatabaseName = "ecomm"
+
 var DB *mongo.Database
 
-func ConnectDB() error {
-	mongoURI := os.Getenv("MONGODB_URI")
-	if mongoURI == "" {
-		mongoURI = "mongodb://localhost:27017/ecomm"
+// SupportsTransactions reports whether the server is a replica set member or
+// mongos, which multi-document transactions require.
+var SupportsTransactions bool
+
+// ConnectDB opens the client described by cfg. Command monitors see every
+// command the driver sends; metrics and tracing hook in this way.
+func ConnectDB(cfg DatabaseConfig, monitors ...*event.CommandMonitor) error {
+	opts, err := cfg.ClientOptions()
+	if err != nil {
+		return err
+	}
+	if len(monitors) > 0 {
+		opts.SetMonitor(chainMonitors(monitors))
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	timeout := cfg.ConnectTimeout + cfg.ServerSelectionTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	client, err := mongo.Connect(ctx, options.Client().ApplyURI(mongoURI))
+	client, err := mongo.Connect(ctx, opts)
 	if err != nil {
 		return fmt.Errorf("failed to connect to MongoDB: %w", err)
 	}
@@ -29,12 +52,153 @@ func ConnectDB() error {
 	// Ping the database
 	err = client.Ping(ctx, nil)
 	if err != nil {
+		client.Disconnect(context.Background())
 		return fmt.Errorf("failed to ping MongoDB: %w", err)
 	}
 
-	DB = client.Database("ecomm")
+	var hello bson.M
+	if err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err == nil {
+		_, isReplicaSet := hello["setName"]
+		SupportsTransactions = isReplicaSet || hello["msg"] == "isdbgrid"
+	}
+
+	DB = client.Database(cfg.DatabaseName())
 	InitCollections()
-	fmt.Println("Successfully Connected to the mongodb")
+	slog.Info("connected to MongoDB", "database", DB.Name(), "transactions", SupportsTransactions)
 	return nil
 }
 
+// chainMonitors fans each command event out to every monitor in turn.
+func chainMonitors(monitors []*event.CommandMonitor) *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, e *event.CommandStartedEvent) {
+			for _, m := range monitors {
+				if m.Started != nil {
+					m.Started(ctx, e)
+				}
+			}
+		},
+		Succeeded: func(ctx context.Context, e *event.CommandSucceededEvent) {
+			for _, m := range monitors {
+				if m.Succeeded != nil {
+					m.Succeeded(ctx, e)
+				}
+			}
+		},
+		Failed: func(ctx context.Context, e *event.CommandFailedEvent) {
+			for _, m := range monitors {
+				if m.Failed != nil {
+					m.Failed(ctx, e)
+				}
+			}
+		},
+	}
+}
+
+// Disconnect closes the client opened by ConnectDB and clears the package
+// handles. It is safe to call when not connected.
+func Disconnect(ctx context.Context) error {
+	if DB == nil {
+		return nil
+	}
+	client := DB.Client()
+	DB = nil
+	SupportsTransactions = false
+	InitCollections()
+	return client.Disconnect(ctx)
+}
+
+// Ping checks that the database connection is usable.
+func Ping(ctx context.Context) error {
+	if DB == nil {
+		return errors.New("not connected")
+	}
+	return DB.Client().Ping(ctx, nil)
+}
+
+// DatabaseName returns the configured database name, falling back to the one
+// in the URI path and then DefaultDatabaseName.
+func (cfg DatabaseConfig) DatabaseName() string {
+	if cfg.Name != "" {
+		return cfg.Name
+	}
+	if name := uriDatabase(cfg.URI); name != "" {
+		return name
+	}
+	return DefaultDatabaseName
+}
+
+// ClientOptions builds the driver options for cfg. Zero values keep the
+// driver's defaults.
+func (cfg DatabaseConfig) ClientOptions() (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(cfg.URI)
+
+	if cfg.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(uint64(cfg.MaxPoolSize))
+	}
+	if cfg.MinPoolSize > 0 {
+		opts.SetMinPoolSize(uint64(cfg.MinPoolSize))
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(cfg.MaxConnIdleTime)
+	}
+	if cfg.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(cfg.ConnectTimeout)
+	}
+	if cfg.ServerSelectionTimeout > 0 {
+		opts.SetServerSelectionTimeout(cfg.ServerSelectionTimeout)
+	}
+
+	if cfg.ReadPreference != "" {
+		mode, err := readpref.ModeFromString(cfg.ReadPreference)
+		if err != nil {
+			return nil, fmt.Errorf("invalid read preference %q", cfg.ReadPreference)
+		}
+		rp, err := readpref.New(mode)
+		if err != nil {
+			return nil, fmt.Errorf("invalid read preference %q: %w", cfg.ReadPreference, err)
+		}
+		opts.SetReadPreference(rp)
+	}
+
+	if cfg.WriteConcern != "" {
+		wc, err := parseWriteConcern(cfg.WriteConcern)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetWriteConcern(wc)
+	}
+
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid database options: %w", err)
+	}
+	return opts, nil
+}
+
+// parseWriteConcern accepts "majority" or a node count such as "1".
+func parseWriteConcern(value string) (*writeconcern.WriteConcern, error) {
+	if value == "majority" {
+		return writeconcern.Majority(), nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return nil, errors.New(`write concern must be "majority" or a node count`)
+	}
+	return &writeconcern.WriteConcern{W: n}, nil
+}
+
+// uriDatabase extracts the database from mongodb://host/<database>?options.
+func uriDatabase(uri string) string {
+	if i := strings.Index(uri, "://"); i >= 0 {
+		uri = uri[i+3:]
+	}
+	i := strings.Index(uri, "/")
+	if i < 0 {
+		return ""
+	}
+	name := uri[i+1:]
+	if j := strings.Index(name, "?"); j >= 0 {
+		name = name[:j]
+	}
+	return name
+}
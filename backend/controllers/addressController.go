@@ -1,36 +1,34 @@
 package controllers
 
 import (
-	"context"
+	"errors"
 	"net/http"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
-	"ecomm-backend/config"
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
 	"ecomm-backend/models"
+	"ecomm-backend/repository"
+	"ecomm-backend/validation"
 )
 
 // GET /api/address
-func GetAddresses(c *gin.Context) {
-	userData, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+func (h *Handler) GetAddresses(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
 		return
 	}
+	userID := principal.UserID
 
-	userMap := userData.(map[string]interface{})
-	userID := userMap["uid"].(string)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	var user models.User
-	err := config.UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	user, err := h.Users.FindByUserID(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
 		return
 	}
 
@@ -38,29 +36,29 @@ func GetAddresses(c *gin.Context) {
 }
 
 // POST /api/address
-func AddAddress(c *gin.Context) {
-	userData, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+func (h *Handler) AddAddress(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
 		return
 	}
-
-	userMap := userData.(map[string]interface{})
-	userID := userMap["uid"].(string)
+	userID := principal.UserID
 
 	var req struct {
-		HouseName  string `json:"house_name" binding:"required"`
-		StreetName string `json:"street_name" binding:"required"`
-		CityName   string `json:"city_name" binding:"required"`
-		PinCode    string `json:"pin_code" binding:"required"`
+		HouseName  string `json:"house_name" binding:"required,max=100"`
+		StreetName string `json:"street_name" binding:"required,max=100"`
+		CityName   string `json:"city_name" binding:"required,max=50"`
+		PinCode    string `json:"pin_code" binding:"required,pincode"`
+		State      string `json:"state" binding:"omitempty,max=50"`
+		Country    string `json:"country" binding:"omitempty,max=50"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "All address fields are required"})
+		c.Error(validation.Error(err))
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
 	newAddress := models.Address{
@@ -69,16 +67,17 @@ func AddAddress(c *gin.Context) {
 		StreetName: req.StreetName,
 		CityName:   req.CityName,
 		PinCode:    req.PinCode,
+		State:      req.State,
+		Country:    req.Country,
 	}
 
-	update := bson.M{
-		"$push": bson.M{"address": newAddress},
-		"$set":  bson.M{"updatedAt": time.Now()},
+	err := h.Users.AddAddress(ctx, userID, newAddress)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.Error(apierror.ErrUserNotFound)
+		return
 	}
-
-	_, err := config.UserCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add address"})
+		c.Error(apierror.Internal("Failed to add address").Wrap(err))
 		return
 	}
 
@@ -86,36 +85,35 @@ func AddAddress(c *gin.Context) {
 }
 
 // PUT /api/address/:id
-func UpdateAddress(c *gin.Context) {
-	userData, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+func (h *Handler) UpdateAddress(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
 		return
 	}
-
-	userMap := userData.(map[string]interface{})
-	userID := userMap["uid"].(string)
+	userID := principal.UserID
 	addressID := c.Param("id")
 
 	var req struct {
-		HouseName  string `json:"house_name"`
-		StreetName string `json:"street_name"`
-		CityName   string `json:"city_name"`
-		PinCode    string `json:"pin_code"`
+		HouseName  string `json:"house_name" binding:"omitempty,max=100"`
+		StreetName string `json:"street_name" binding:"omitempty,max=100"`
+		CityName   string `json:"city_name" binding:"omitempty,max=50"`
+		PinCode    string `json:"pin_code" binding:"omitempty,pincode"`
+		State      string `json:"state" binding:"omitempty,max=50"`
+		Country    string `json:"country" binding:"omitempty,max=50"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		c.Error(validation.Error(err))
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	var user models.User
-	err := config.UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	user, err := h.Users.FindByUserID(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
 		return
 	}
 
@@ -129,7 +127,7 @@ func UpdateAddress(c *gin.Context) {
 	}
 
 	if addressIndex == -1 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Address not found"})
+		c.Error(apierror.ErrAddressNotFound)
 		return
 	}
 
@@ -146,17 +144,16 @@ func UpdateAddress(c *gin.Context) {
 	if req.PinCode != "" {
 		user.Address[addressIndex].PinCode = req.PinCode
 	}
-
-	update := bson.M{
-		"$set": bson.M{
-			"address":   user.Address,
-			"updatedAt": time.Now(),
-		},
+	if req.State != "" {
+		user.Address[addressIndex].State = req.State
+	}
+	if req.Country != "" {
+		user.Address[addressIndex].Country = req.Country
 	}
 
-	_, err = config.UserCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
+	err = h.Users.SetAddresses(ctx, userID, user.Address)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update address"})
+		c.Error(apierror.Internal("Failed to update address").Wrap(err))
 		return
 	}
 
@@ -164,24 +161,21 @@ func UpdateAddress(c *gin.Context) {
 }
 
 // DELETE /api/address/:id
-func DeleteAddress(c *gin.Context) {
-	userData, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+func (h *Handler) DeleteAddress(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
 		return
 	}
-
-	userMap := userData.(map[string]interface{})
-	userID := userMap["uid"].(string)
+	userID := principal.UserID
 	addressID := c.Param("id")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	var user models.User
-	err := config.UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	user, err := h.Users.FindByUserID(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
 		return
 	}
 
@@ -193,18 +187,29 @@ func DeleteAddress(c *gin.Context) {
 		}
 	}
 
-	update := bson.M{
-		"$set": bson.M{
-			"address":   filteredAddresses,
-			"updatedAt": time.Now(),
-		},
-	}
-
-	_, err = config.UserCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
+	err = h.Users.SetAddresses(ctx, userID, filteredAddresses)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete address"})
+		c.Error(apierror.Internal("Failed to delete address").Wrap(err))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Address deleted successfully"})
 }
+
+// selectAddress returns the user's saved address with the given ID, or their
+// first saved address when addressID is empty. It returns nil if none matches.
+func selectAddress(user *models.User, addressID string) *models.Address {
+	if addressID == "" {
+		if len(user.Address) == 0 {
+			return nil
+		}
+		return &user.Address[0]
+	}
+
+	for i := range user.Address {
+		if user.Address[i].ID.Hex() == addressID {
+			return &user.Address[i]
+		}
+	}
+	return nil
+}
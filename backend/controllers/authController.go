@@ -2,169 +2,298 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 
-	"ecomm-backend/config"
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
 	"ecomm-backend/models"
+	"ecomm-backend/repository"
 	"ecomm-backend/utils"
+	"ecomm-backend/validation"
 )
 
-
 // POST /api/auth/register
-func SignUp(c *gin.Context) {
+func (h *Handler) SignUp(c *gin.Context) {
 	var req struct {
-		FirstName string `json:"first_name" binding:"required"`
-		LastName  string `json:"last_name" binding:"required"`
-		Email     string `json:"email" binding:"required"`
-		Password  string `json:"password" binding:"required"`
-		Phone     string `json:"phone" binding:"required"`
+		FirstName string `json:"first_name" binding:"required,min=2,max=30"`
+		LastName  string `json:"last_name" binding:"required,min=2,max=30"`
+		Email     string `json:"email" binding:"required,email"`
+		Password  string `json:"password" binding:"required,min=6,max=72"`
+		Phone     string `json:"phone" binding:"required,phone"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "All fields are required"})
+		c.Error(validation.Error(err))
 		return
 	}
 
-	// Validation
-	if len(req.FirstName) < 2 || len(req.FirstName) > 30 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "First name must be between 2 and 30 characters"})
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	// Hash before looking anything up, so a signup for an existing account
+	// takes as long as a new one
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), h.Config.Auth.BcryptCost)
+	if err != nil {
+		c.Error(apierror.Internal("Failed to hash password").Wrap(err))
 		return
 	}
 
-	if len(req.LastName) < 2 || len(req.LastName) > 30 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Last name must be between 2 and 30 characters"})
+	// A taken email or phone gets the same response as a new account, so
+	// signup can't be used to find out who has one
+	emailLower := strings.ToLower(req.Email)
+	taken, err := h.accountExists(ctx, emailLower, req.Phone)
+	if err != nil {
+		c.Error(apierror.Internal("Failed to create user").Wrap(err))
 		return
 	}
-
-	if len(req.Password) < 6 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Password must be at least 6 characters"})
+	if taken {
+		slog.InfoContext(ctx, "signup for an existing email or phone ignored")
+		c.JSON(http.StatusCreated, signUpResponse)
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// Create user; tokens are issued with a session when they log in
+	userID := primitive.NewObjectID().Hex()
 
-	// Check if user exists
-	emailLower := strings.ToLower(req.Email)
-	var existingUser models.User
-	err := config.UserCollection.FindOne(ctx, bson.M{"email": emailLower}).Decode(&existingUser)
-	if err == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User already exists"})
+	// Accounts start unverified until the emailed link is opened
+	verifyToken, verification, err := h.newEmailVerification()
+	if err != nil {
+		c.Error(apierror.Internal("Failed to create user").Wrap(err))
 		return
 	}
 
-	// Check phone
-	err = config.UserCollection.FindOne(ctx, bson.M{"phone": req.Phone}).Decode(&existingUser)
-	if err == nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Phone is already in use"})
-		return
+	user := models.User{
+		FirstName:         req.FirstName,
+		LastName:          req.LastName,
+		Email:             emailLower,
+		Password:          string(hashedPassword),
+		Phone:             req.Phone,
+		EmailVerification: &verification,
+		UserID:            userID,
+		UserCart:          []models.ProductUser{},
+		Address:           []models.Address{},
+		Orders:            []models.Order{},
+		CreatedAt:         time.Now(),
+		UpdatedAt:         time.Now(),
 	}
 
-	// Hash password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), 14)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+	err = h.Users.Create(ctx, &user)
+	if errors.Is(err, repository.ErrDuplicate) {
+		// Lost a race with another signup for the same email or phone
+		c.JSON(http.StatusCreated, signUpResponse)
 		return
 	}
-
-	// Create user
-	userID := primitive.NewObjectID().Hex()
-	token, refreshToken, err := utils.TokenGenerator(emailLower, req.FirstName, req.LastName, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		c.Error(apierror.Internal("Failed to create user").Wrap(err))
 		return
 	}
+	h.Metrics.Signup()
 
-	user := models.User{
-		FirstName:    req.FirstName,
-		LastName:     req.LastName,
-		Email:        emailLower,
-		Password:     string(hashedPassword),
-		Phone:        req.Phone,
-		Token:        token,
-		RefreshToken: refreshToken,
-		UserID:       userID,
-		UserCart:     []models.ProductUser{},
-		Address:      []models.Address{},
-		Orders:       []models.Order{},
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
-	}
-
-	_, err = config.UserCollection.InsertOne(ctx, user)
-	if err != nil {
-		if mongo.IsDuplicateKeyError(err) {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Email or phone is already in use"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create user"})
-		return
+	// The account exists either way; the user can ask for another link
+	if err := h.sendVerificationEmail(ctx, emailLower, verifyToken); err != nil {
+		slog.ErrorContext(ctx, "failed to send verification email", "user_id", userID, "error", err)
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "Successfully Signed Up!!"})
+	c.JSON(http.StatusCreated, signUpResponse)
+}
+
+var signUpResponse = gin.H{"message": "Successfully Signed Up!!"}
+
+// accountExists reports whether an account already uses email or phone.
+func (h *Handler) accountExists(ctx context.Context, email, phone string) (bool, error) {
+	if _, err := h.Users.FindByEmail(ctx, email); !errors.Is(err, repository.ErrNotFound) {
+		return err == nil, err
+	}
+	if _, err := h.Users.FindByPhone(ctx, phone); !errors.Is(err, repository.ErrNotFound) {
+		return err == nil, err
+	}
+	return false, nil
 }
 
 // POST /api/auth/login
-func Login(c *gin.Context) {
+func (h *Handler) Login(c *gin.Context) {
 	var req struct {
-		Email    string `json:"email" binding:"required"`
+		Email    string `json:"email" binding:"required,email"`
 		Password string `json:"password" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Email and password are required"})
+		c.Error(validation.Error(err))
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	var user models.User
-	err := config.UserCollection.FindOne(ctx, bson.M{"email": strings.ToLower(req.Email)}).Decode(&user)
+	user, err := h.Users.FindByEmail(ctx, strings.ToLower(req.Email))
+	if errors.Is(err, repository.ErrNotFound) {
+		// Spend as long as a real check so timing doesn't reveal the account
+		// is missing
+		bcrypt.CompareHashAndPassword(h.dummyPasswordHash(), []byte(req.Password))
+		h.Metrics.Login("failure")
+		c.Error(errInvalidCredentials)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "login or password incorrect"})
+		c.Error(apierror.Internal("Failed to log in").Wrap(err))
 		return
 	}
 
-	// Verify password
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Login Or Password is Incorrect"})
+	// The password is checked even on a locked account, and a locked account
+	// answers like an unknown one, so a lock shows up neither in the timing
+	// nor in the response
+	passwordOK := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)) == nil
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		h.Metrics.Login("locked")
+		c.Error(errInvalidCredentials)
 		return
 	}
+	if !passwordOK {
+		h.recordFailedLogin(ctx, user.UserID)
+		h.Metrics.Login("failure")
+		c.Error(errInvalidCredentials)
+		return
+	}
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := h.Users.ResetFailedLogins(ctx, user.UserID); err != nil {
+			c.Error(apierror.Internal("Failed to log in").Wrap(err))
+			return
+		}
+	}
+
+	h.signIn(ctx, c, user, auth.MethodPassword)
+}
+
+// signIn finishes a login whose first factor, checked with method, checked
+// out. Users with two-factor authentication, and admins, who must have it,
+// get a challenge to answer; everyone else gets a session.
+func (h *Handler) signIn(ctx context.Context, c *gin.Context, user *models.User, method string) {
+	if user.Role == models.RoleAdmin || twoFactorEnabled(user) {
+		h.challenge(ctx, c, user)
+		return
+	}
+	h.completeLogin(ctx, c, user, method)
+}
+
+// completeLogin starts a session for an authenticated user and responds
+// with the user, as every way of logging in does.
+func (h *Handler) completeLogin(ctx context.Context, c *gin.Context, user *models.User, method string) {
+	if err := h.startSession(ctx, c, user, method); err != nil {
+		c.Error(err)
+		return
+	}
+	c.JSON(http.StatusOK, user)
+}
+
+// startSession records a new session for user, who logged in with method
+// from the client of c, and issues its token pair, leaving the tokens on
+// user and clearing its password for the response.
+func (h *Handler) startSession(ctx context.Context, c *gin.Context, user *models.User, method string) error {
+	refreshID, err := utils.NewTokenID()
+	if err != nil {
+		return apierror.Internal("Failed to generate token").Wrap(err)
+	}
+	now := time.Now()
+	session := models.Session{
+		ID:         primitive.NewObjectID().Hex(),
+		UserAgent:  c.Request.UserAgent(),
+		IP:         c.ClientIP(),
+		AuthMethod: method,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(utils.RefreshTokenTTL),
+		RefreshID:  refreshID,
+	}
 
 	// Generate new tokens
-	token, refreshToken, err := utils.TokenGenerator(user.Email, user.FirstName, user.LastName, user.UserID)
+	token, refreshToken, err := h.Tokens.Generate(user.Email, user.FirstName, user.LastName, user.UserID, user.TokenVersion, method, session.ID, refreshID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
-		return
+		return apierror.Internal("Failed to generate token").Wrap(err)
+	}
+
+	if err := h.Users.AddSession(ctx, user.UserID, session); err != nil {
+		return apierror.Internal("Failed to start session").Wrap(err)
 	}
 
 	// Update tokens in database
-	err = utils.UpdateAllTokens(token, refreshToken, user.UserID, config.UserCollection)
+	err = h.Users.UpdateTokens(ctx, user.UserID, token, refreshToken)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tokens"})
-		return
+		return apierror.Internal("Failed to update tokens").Wrap(err)
 	}
 
+	h.Metrics.Login("success")
+
 	// Return user data (without password)
 	user.Password = ""
 	user.Token = token
 	user.RefreshToken = refreshToken
+	return nil
+}
 
-	c.JSON(http.StatusOK, user)
+var errInvalidCredentials = apierror.Unauthorized(apierror.CodeInvalidCredentials, "Login or password is incorrect")
+
+// recordFailedLogin counts a wrong password and locks the account once the
+// configured threshold is reached. Failures here are logged rather than
+// returned, so the response stays the same as for any wrong password.
+func (h *Handler) recordFailedLogin(ctx context.Context, userID string) {
+	failures, err := h.Users.RecordFailedLogin(ctx, userID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to record failed login", "user_id", userID, "error", err)
+		return
+	}
+	lock := h.Config.Auth.Lockout.LockFor(failures)
+	if lock == 0 {
+		return
+	}
+	if err := h.Users.LockUntil(ctx, userID, time.Now().Add(lock)); err != nil {
+		slog.ErrorContext(ctx, "failed to lock account", "user_id", userID, "error", err)
+		return
+	}
+	slog.WarnContext(ctx, "account locked after failed logins", "user_id", userID, "failures", failures, "lock", lock.String())
+}
+
+// dummyPasswordHash is compared against when no account matches a login.
+func (h *Handler) dummyPasswordHash() []byte {
+	h.dummyHashOnce.Do(func() {
+		h.dummyHash, _ = bcrypt.GenerateFromPassword([]byte(primitive.NewObjectID().Hex()), h.Config.Auth.BcryptCost)
+	})
+	return h.dummyHash
 }
 
-// POST /api/auth/logout
-func Logout(c *gin.Context) {
+// POST /api/auth/logout - End the session the request was made with
+func (h *Handler) Logout(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	err := h.Users.RevokeSession(ctx, principal.UserID, principal.SessionID)
+	if err != nil && !errors.Is(err, repository.ErrNotFound) {
+		c.Error(apierror.Internal("Failed to log out").Wrap(err))
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
+// GET /.well-known/jwks.json
+//
+// JWKS publishes the public keys tokens are signed with, including retired
+// keys whose tokens are still accepted, so other services can verify them.
+// Clients should refetch when they see an unknown kid.
+func (h *Handler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.Keys.JWKS())
+}
@@ -1,36 +1,37 @@
 package controllers
 
 import (
-	"context"
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 
-	"ecomm-backend/config"
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
 	"ecomm-backend/models"
+	"ecomm-backend/repository"
+	"ecomm-backend/shipping"
+	"ecomm-backend/tax"
+	"ecomm-backend/validation"
 )
 
 // POST /api/cart - Add item to cart
-func AddToCart(c *gin.Context) {
-	userData, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+func (h *Handler) AddToCart(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
 		return
 	}
-
-	userMap := userData.(map[string]interface{})
-	userID := userMap["uid"].(string)
+	userID := principal.UserID
 
 	var req struct {
 		ProductID string `json:"productId" binding:"required"`
-		Qty       int    `json:"qty"`
+		Qty       int    `json:"qty" binding:"omitempty,min=1,max=100"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "productId is required"})
+		c.Error(validation.Error(err))
 		return
 	}
 
@@ -39,28 +40,20 @@ func AddToCart(c *gin.Context) {
 		quantity = 1
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
 	// Find product
-	var product models.Product
-	objectID, err := primitive.ObjectIDFromHex(req.ProductID)
-	if err == nil {
-		err = config.ProductCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&product)
-	}
-	if err != nil || product.ID.IsZero() {
-		err = config.ProductCollection.FindOne(ctx, bson.M{"product_id": req.ProductID}).Decode(&product)
-	}
-	if err != nil || product.ID.IsZero() {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
+	product, err := h.Products.FindByID(ctx, req.ProductID)
+	if err != nil {
+		c.Error(lookupError(err, apierror.ErrProductNotFound))
 		return
 	}
 
 	// Find user
-	var user models.User
-	err = config.UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	user, err := h.Users.FindByUserID(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
 		return
 	}
 
@@ -86,39 +79,38 @@ func AddToCart(c *gin.Context) {
 			Price:       product.Price,
 			Rating:      product.Rating,
 			Image:       product.Image,
+			TaxClass:    product.TaxClass,
+			Weight:      product.Weight,
 			Quantity:    quantity,
 		})
 	}
 
-	update := bson.M{"$set": bson.M{"usercart": user.UserCart, "updatedAt": time.Now()}}
-	_, err = config.UserCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
+	err = h.Users.SetCart(ctx, userID, user.UserCart)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add product to cart"})
+		c.Error(apierror.Internal("Failed to add product to cart").Wrap(err))
 		return
 	}
+	h.Metrics.CartAddition()
 
 	c.JSON(http.StatusOK, gin.H{"message": "Successfully added to cart"})
 }
 
 // DELETE /api/cart/:id - Remove item from cart
-func RemoveFromCart(c *gin.Context) {
-	userData, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+func (h *Handler) RemoveFromCart(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
 		return
 	}
-
-	userMap := userData.(map[string]interface{})
-	userID := userMap["uid"].(string)
+	userID := principal.UserID
 	productID := c.Param("id")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	var user models.User
-	err := config.UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	user, err := h.Users.FindByUserID(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
 		return
 	}
 
@@ -132,100 +124,157 @@ func RemoveFromCart(c *gin.Context) {
 		}
 	}
 
-	update := bson.M{"$set": bson.M{"usercart": filteredCart, "updatedAt": time.Now()}}
-	_, err = config.UserCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
+	err = h.Users.SetCart(ctx, userID, filteredCart)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove product from cart"})
+		c.Error(apierror.Internal("Failed to remove product from cart").Wrap(err))
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Successfully removed from cart"})
 }
 
-// GET /api/cart - Get cart with total
-func GetCart(c *gin.Context) {
-	userData, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+// GET /api/cart?address=<id>&shipping=<option> - Get cart with tax, shipping and total
+func (h *Handler) GetCart(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
 		return
 	}
+	userID := principal.UserID
 
-	userMap := userData.(map[string]interface{})
-	userID := userMap["uid"].(string)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	var user models.User
-	err := config.UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	user, err := h.Users.FindByUserID(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
+		return
+	}
+
+	// Tax depends on where the order ships; default to the first saved address
+	addressID := c.Query("address")
+	address := selectAddress(user, addressID)
+	if addressID != "" && address == nil {
+		c.Error(apierror.ErrAddressNotFound)
 		return
 	}
 
 	// Calculate total
-	total := 0.0
+	subtotal := 0.0
 	for _, item := range user.UserCart {
-		total += item.Price * float64(item.Quantity)
+		subtotal += item.Price * float64(item.Quantity)
+	}
+	taxBreakdown := tax.Calculate(user.UserCart, address)
+
+	// Shipping is only quoted once there is something to ship
+	var shippingOption *models.ShippingOption
+	shippingCost := 0.0
+	if len(user.UserCart) > 0 {
+		shippingOption, err = shipping.Select(c.Query("shipping"), user.UserCart, address, time.Now())
+		if err != nil {
+			c.Error(shippingError(err))
+			return
+		}
+		shippingCost = shippingOption.Cost
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"items": user.UserCart,
-		"total": total,
+		"items":    user.UserCart,
+		"subtotal": tax.Round(subtotal),
+		"tax":      taxBreakdown,
+		"shipping": shippingOption,
+		"total":    tax.Round(subtotal + taxBreakdown.TotalTax + shippingCost),
 	})
 }
 
-// PUT /api/cart/items/:id - Update cart item quantity
-func UpdateCartItem(c *gin.Context) {
-	userData, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+// GET /api/cart/shipping-options?address=<id> - Delivery options for the cart
+func (h *Handler) GetShippingOptions(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
+		return
+	}
+	userID := principal.UserID
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	user, err := h.Users.FindByUserID(ctx, userID)
+	if err != nil {
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
 		return
 	}
 
-	userMap := userData.(map[string]interface{})
-	userID := userMap["uid"].(string)
+	if len(user.UserCart) == 0 {
+		c.Error(apierror.ErrCartEmpty)
+		return
+	}
+
+	addressID := c.Query("address")
+	address := selectAddress(user, addressID)
+	if address == nil {
+		if addressID != "" {
+			c.Error(apierror.ErrAddressNotFound)
+		} else {
+			c.Error(apierror.BadRequest(apierror.CodeAddressNotFound, "Add a delivery address to see shipping options"))
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"address_id": address.ID.Hex(),
+		"zone":       shipping.ZoneFor(address),
+		"options":    shipping.Options(user.UserCart, address, time.Now()),
+	})
+}
+
+// PUT /api/cart/items/:id - Update cart item quantity
+func (h *Handler) UpdateCartItem(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
+		return
+	}
+	userID := principal.UserID
 	itemID := c.Param("id")
 
 	var req struct {
-		Quantity int `json:"quantity" binding:"required"`
+		Quantity int `json:"quantity" binding:"required,min=1,max=100"`
 	}
 
-	if err := c.ShouldBindJSON(&req); err != nil || req.Quantity < 1 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Quantity must be at least 1"})
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(validation.Error(err))
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	var user models.User
-	err := config.UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	user, err := h.Users.FindByUserID(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
 		return
 	}
 
 	// Find and update item
 	itemIndex := -1
 	for i, item := range user.UserCart {
-		if item.ID.Hex() == itemID {
+		if item.ID.Hex() == itemID || item.ProductID == itemID {
 			itemIndex = i
 			break
 		}
 	}
 
 	if itemIndex == -1 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Cart item not found"})
+		c.Error(apierror.ErrCartItemNotFound)
 		return
 	}
 
 	user.UserCart[itemIndex].Quantity = req.Quantity
 
-	update := bson.M{"$set": bson.M{"usercart": user.UserCart, "updatedAt": time.Now()}}
-	_, err = config.UserCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
+	err = h.Users.SetCart(ctx, userID, user.UserCart)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update cart item"})
+		c.Error(apierror.Internal("Failed to update cart item").Wrap(err))
 		return
 	}
 
@@ -233,23 +282,24 @@ func UpdateCartItem(c *gin.Context) {
 }
 
 // DELETE /api/cart - Clear entire cart
-func ClearCart(c *gin.Context) {
-	userData, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+func (h *Handler) ClearCart(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
 		return
 	}
+	userID := principal.UserID
 
-	userMap := userData.(map[string]interface{})
-	userID := userMap["uid"].(string)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	update := bson.M{"$set": bson.M{"usercart": []models.ProductUser{}, "updatedAt": time.Now()}}
-	_, err := config.UserCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
+	err := h.Users.SetCart(ctx, userID, []models.ProductUser{})
+	if errors.Is(err, repository.ErrNotFound) {
+		c.Error(apierror.ErrUserNotFound)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear cart"})
+		c.Error(apierror.Internal("Failed to clear cart").Wrap(err))
 		return
 	}
 
@@ -2,101 +2,313 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
-	"ecomm-backend/config"
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
 	"ecomm-backend/models"
+	"ecomm-backend/payments"
+	"ecomm-backend/repository"
+	"ecomm-backend/shipping"
+	"ecomm-backend/tax"
+	"ecomm-backend/txn"
+	"ecomm-backend/validation"
 )
 
-// POST /api/checkout - Mock checkout with receipt
-func Checkout(c *gin.Context) {
-	userData, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+var (
+	errPaymentGateway = apierror.New(http.StatusBadGateway, apierror.CodePaymentGateway, "Failed to create payment order")
+	errCartChanged    = apierror.Conflict(apierror.CodeCartChanged, "Cart changed during checkout, please try again")
+)
+
+// POST /api/checkout - Place an order for a saved address and payment method
+func (h *Handler) Checkout(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
 		return
 	}
-
-	userMap := userData.(map[string]interface{})
-	userID := userMap["uid"].(string)
+	userID := principal.UserID
 
 	var req struct {
-		CartItems []models.ProductUser `json:"cartItems"`
+		AddressID     string `json:"address_id" binding:"required"`
+		PaymentMethod string `json:"payment_method" binding:"required,oneof=cod digital"`
+		Shipping      string `json:"shipping_option"`
 	}
 
-	c.ShouldBindJSON(&req)
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(validation.Error(err))
+		return
+	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	var user models.User
-	err := config.UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	user, err := h.Users.FindByUserID(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
+		return
+	}
+
+	if len(user.UserCart) == 0 {
+		c.Error(apierror.ErrCartEmpty)
 		return
 	}
 
-	// Use cartItems from body if provided, otherwise use user's cart
-	itemsToCheckout := req.CartItems
-	if len(itemsToCheckout) == 0 {
-		itemsToCheckout = user.UserCart
+	address := selectAddress(user, req.AddressID)
+	if address == nil {
+		c.Error(apierror.ErrAddressNotFound)
+		return
 	}
 
-	if len(itemsToCheckout) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cart is empty"})
+	// The cart keeps what products cost when they were added; the order is
+	// priced at what they cost now
+	itemsToCheckout, err := h.priceCart(ctx, user.UserCart)
+	if errors.Is(err, apierror.ErrOutOfStock) {
+		h.Metrics.CheckoutFailed("out_of_stock")
+		c.Error(err)
+		return
+	}
+	if err != nil {
+		h.Metrics.CheckoutFailed("internal")
+		c.Error(apierror.Internal("Failed to process checkout").Wrap(err))
 		return
 	}
 
 	// Calculate total
-	total := 0.0
+	subtotal := 0.0
 	for _, item := range itemsToCheckout {
 		qty := item.Quantity
 		if qty == 0 {
 			qty = 1
 		}
-		total += item.Price * float64(qty)
+		subtotal += item.Price * float64(qty)
 	}
+	subtotal = tax.Round(subtotal)
+	taxBreakdown := tax.Calculate(itemsToCheckout, address)
+
+	shippingOption, err := shipping.Select(req.Shipping, itemsToCheckout, address, time.Now())
+	if err != nil {
+		c.Error(shippingError(err))
+		return
+	}
+
+	total := tax.Round(subtotal + taxBreakdown.TotalTax + shippingOption.Cost)
+
+	// Snapshot the address so later edits don't change where this order ships
+	deliveryAddress := *address
 
 	// Create order
 	order := models.Order{
-		ID:        primitive.NewObjectID(),
-		OrderList: itemsToCheckout,
-		OrderedOn: time.Now(),
-		TotalPrice: total,
+		ID:              primitive.NewObjectID(),
+		OrderList:       itemsToCheckout,
+		OrderedOn:       time.Now(),
+		Subtotal:        subtotal,
+		Tax:             taxBreakdown,
+		Shipping:        shippingOption,
+		TotalPrice:      total,
+		DeliveryAddress: &deliveryAddress,
 		PaymentMethod: models.Payment{
-			Digital: false,
-			COD:     true,
+			Digital: req.PaymentMethod == models.PaymentMethodDigital,
+			COD:     req.PaymentMethod == models.PaymentMethodCOD,
 		},
-		Status: "completed",
+		Status: models.OrderStatusConfirmed,
 	}
 
-	// Add order to user's orders and clear cart
-	update := bson.M{
-		"$push": bson.M{"orders": order},
-		"$set": bson.M{
-			"usercart":  []models.ProductUser{},
-			"updatedAt": time.Now(),
-		},
+	// Digital orders wait for the gateway to confirm payment
+	if order.PaymentMethod.Digital {
+		order.Status = models.OrderStatusPendingPayment
 	}
 
-	_, err = config.UserCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
+	// Stock, order and cart commit or roll back together. The gateway order
+	// comes last, as it can't be cancelled if a later step fails
+	steps := []txn.Step{
+		h.reserveStockStep(order.OrderList),
+		h.placeOrderStep(userID, order, user.UserCart),
+	}
+	var paymentOrder payments.Order
+	if order.PaymentMethod.Digital {
+		steps = append(steps, h.paymentStep(userID, order, &paymentOrder))
+	}
+
+	err = txn.Run(ctx, steps...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process checkout"})
+		if errors.Is(err, apierror.ErrOutOfStock) {
+			h.Metrics.CheckoutFailed("out_of_stock")
+			c.Error(err)
+			return
+		}
+		if errors.Is(err, errCartChanged) {
+			h.Metrics.CheckoutFailed("cart_changed")
+			c.Error(err)
+			return
+		}
+		if errors.Is(err, errPaymentGateway) {
+			h.Metrics.PaymentFailed("create_order")
+			h.Metrics.CheckoutFailed("payment_gateway")
+			c.Error(err)
+			return
+		}
+		h.Metrics.CheckoutFailed("internal")
+		c.Error(apierror.Internal("Failed to process checkout").Wrap(err))
 		return
 	}
+	h.Metrics.Checkout(req.PaymentMethod, total)
 
-	// Return mock receipt
 	receipt := gin.H{
+		"subtotal":  subtotal,
+		"tax":       taxBreakdown,
+		"shipping":  shippingOption,
 		"total":     total,
 		"timestamp": time.Now().Format(time.RFC3339),
 		"order_id":  order.ID.Hex(),
 		"items":     len(itemsToCheckout),
+		"status":    order.Status,
+	}
+	if order.PaymentMethod.Digital {
+		receipt["payment"] = paymentOrder
 	}
 
 	c.JSON(http.StatusOK, receipt)
 }
 
+// priceCart returns the cart with each line's name, price, tax class and
+// weight taken from the product as it is now.
+func (h *Handler) priceCart(ctx context.Context, cart []models.ProductUser) ([]models.ProductUser, error) {
+	items := make([]models.ProductUser, 0, len(cart))
+	for _, item := range cart {
+		product, err := h.Products.FindByID(ctx, item.ProductID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil, apierror.ErrOutOfStock.WithMessage(fmt.Sprintf("%s is no longer available", item.ProductName))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		item.ProductName = product.ProductName
+		item.Price = product.Price
+		item.Rating = product.Rating
+		item.Image = product.Image
+		item.TaxClass = product.TaxClass
+		item.Weight = product.Weight
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// reserveStockStep decrements stock for each item. Products without a stock
+// level are not tracked and never run out.
+func (h *Handler) reserveStockStep(items []models.ProductUser) txn.Step {
+	type reservation struct {
+		id  primitive.ObjectID
+		qty int
+	}
+	var reserved []reservation
+
+	return txn.Step{
+		Name: "reserve_stock",
+		Run: func(ctx context.Context) error {
+			reserved = nil
+			for _, item := range items {
+				qty := item.Quantity
+				if qty == 0 {
+					qty = 1
+				}
+
+				product, err := h.Products.FindByID(ctx, item.ProductID)
+				if errors.Is(err, repository.ErrNotFound) {
+					return apierror.ErrOutOfStock.WithMessage(fmt.Sprintf("%s is no longer available", item.ProductName))
+				}
+				if err != nil {
+					return err
+				}
+				if product.Stock == nil {
+					continue
+				}
+
+				ok, err := h.Products.ReserveStock(ctx, product.ID, qty)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return apierror.ErrOutOfStock.WithMessage(fmt.Sprintf("Insufficient stock for %s", product.ProductName))
+				}
+				reserved = append(reserved, reservation{id: product.ID, qty: qty})
+			}
+			return nil
+		},
+		Compensate: func(ctx context.Context) error {
+			for _, r := range reserved {
+				if err := h.Products.ReleaseStock(ctx, r.id, r.qty); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// placeOrderStep adds the order to the user and clears their cart, provided
+// the cart is still the one that was priced. If it has changed, another
+// checkout has already ordered it or the user edited it meanwhile, and the
+// step fails with errCartChanged.
+func (h *Handler) placeOrderStep(userID string, order models.Order, cart []models.ProductUser) txn.Step {
+	placed := false
+
+	return txn.Step{
+		Name: "place_order",
+		Run: func(ctx context.Context) error {
+			err := h.Users.PlaceOrder(ctx, userID, cart, order)
+			if errors.Is(err, repository.ErrNotFound) {
+				return errCartChanged
+			}
+			placed = err == nil
+			return err
+		},
+		Compensate: func(ctx context.Context) error {
+			// Nothing was written, and the cart now belongs to whoever
+			// changed it
+			if !placed {
+				return nil
+			}
+			if err := h.Users.RemoveOrder(ctx, userID, order.ID); err != nil {
+				return err
+			}
+			return h.Users.SetCart(ctx, userID, cart)
+		},
+	}
+}
+
+// paymentStep creates the gateway order for a placed order and records it
+// on the order. A transaction may run it more than once; the gateway order
+// is only created the first time.
+func (h *Handler) paymentStep(userID string, order models.Order, created *payments.Order) txn.Step {
+	return txn.Step{
+		Name: "create_payment",
+		Run: func(ctx context.Context) error {
+			if created.ID == "" {
+				paymentOrder, err := h.Payments.CreateOrder(ctx, order.TotalPrice, "INR", order.ID.Hex())
+				if err != nil {
+					return errPaymentGateway.Wrap(err)
+				}
+				*created = *paymentOrder
+			}
+			return h.Users.SetOrderGatewayID(ctx, userID, order.ID, created.ID)
+		},
+	}
+}
+
+func shippingError(err error) *apierror.Error {
+	switch {
+	case errors.Is(err, shipping.ErrUnknownOption):
+		return apierror.BadRequest(apierror.CodeShippingOption, "Unknown shipping option")
+	case errors.Is(err, shipping.ErrOptionUnavailable):
+		return apierror.BadRequest(apierror.CodeShippingUnavail, "Shipping option is not available for this address")
+	}
+	return apierror.Internal("Failed to calculate shipping").Wrap(err)
+}
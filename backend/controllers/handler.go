@@ -0,0 +1,92 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/config"
+	"ecomm-backend/metrics"
+	"ecomm-backend/notify"
+	"ecomm-backend/oidc"
+	"ecomm-backend/payments"
+	"ecomm-backend/ratelimit"
+	"ecomm-backend/repository"
+	"ecomm-backend/signing"
+	"ecomm-backend/utils"
+)
+
+// Handler serves the API routes. Storage is injected so handlers can run
+// against MongoDB in production and in-memory repositories in tests.
+type Handler struct {
+	Config   *config.Config
+	Users    repository.UserRepository
+	Products repository.ProductRepository
+	// Keys are the token signing keys; Tokens signs and checks with them.
+	Keys     *signing.KeyRing
+	Tokens   *utils.TokenManager
+	Payments payments.Gateway
+	Metrics  *metrics.Metrics
+	// Notifier delivers account messages such as password reset links.
+	Notifier notify.Notifier
+	// SMS delivers text messages such as login codes.
+	SMS notify.Notifier
+	// RateLimits holds the auth rate limit buckets. It defaults to an
+	// in-memory store; set a shared one when running several instances.
+	RateLimits ratelimit.Store
+	// OIDC holds the OpenID Connect providers users can log in with, by
+	// name.
+	OIDC map[string]*oidc.Provider
+
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+}
+
+func NewHandler(cfg *config.Config, users repository.UserRepository, products repository.ProductRepository, keys *signing.KeyRing, m *metrics.Metrics) *Handler {
+	return &Handler{
+		Config:     cfg,
+		Users:      users,
+		Products:   products,
+		Keys:       keys,
+		Tokens:     utils.NewTokenManager(keys, cfg.Auth.TokenIssuer, cfg.Auth.TokenAudience),
+		Payments:   payments.New(cfg.Payments),
+		Metrics:    m,
+		Notifier:   notify.New(cfg.Notify),
+		SMS:        notify.New(cfg.SMS),
+		RateLimits: ratelimit.NewMemoryStore(),
+		OIDC:       oidc.NewProviders(cfg.Auth.OIDC.Providers),
+	}
+}
+
+// requestContext derives the context for a handler's storage and gateway
+// calls. It ends when the client disconnects or the configured request
+// timeout passes, whichever comes first.
+func (h *Handler) requestContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	if h.Config.Server.RequestTimeout <= 0 {
+		return context.WithCancel(c.Request.Context())
+	}
+	return context.WithTimeout(c.Request.Context(), h.Config.Server.RequestTimeout)
+}
+
+// tokenLink adds token to the query of base, a URL checked when the config
+// was validated.
+func tokenLink(base, token string) string {
+	u, _ := url.Parse(base)
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// lookupError maps a failed repository lookup to notFound, or to an internal
+// error carrying the cause when storage itself failed.
+func lookupError(err error, notFound *apierror.Error) *apierror.Error {
+	if errors.Is(err, repository.ErrNotFound) {
+		return notFound
+	}
+	return apierror.Internal("Failed to load data").Wrap(err)
+}
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
+	"ecomm-backend/models"
+	"ecomm-backend/oidc"
+	"ecomm-backend/repository"
+)
+
+// oidcStateCookie carries the sealed login state from the start of an
+// OpenID Connect login to its callback.
+const oidcStateCookie = "oidc_state"
+
+var (
+	errProviderNotFound = apierror.NotFound(apierror.CodeProviderNotFound, "Unknown login provider")
+	errOIDCStateInvalid = apierror.BadRequest(apierror.CodeOIDCStateInvalid, "The login has expired or was started in another browser; please try again")
+	errOIDCLoginFailed  = apierror.Unauthorized(apierror.CodeOIDCLoginFailed, "The provider did not confirm the login")
+	errOIDCEmail        = apierror.Forbidden(apierror.CodeOIDCEmail, "The provider has not verified your email address")
+	errOIDCProvider     = apierror.New(http.StatusBadGateway, apierror.CodeOIDCProvider, "The login provider could not be reached")
+	errOIDCConflict     = apierror.Conflict(apierror.CodeOIDCConflict, "The provider account conflicts with another account")
+)
+
+// GET /api/auth/oidc/:provider - Redirect to the provider to log in
+func (h *Handler) OIDCLogin(c *gin.Context) {
+	provider, ok := h.OIDC[c.Param("provider")]
+	if !ok {
+		c.Error(errProviderNotFound)
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	ttl := h.Config.Auth.OIDC.StateTTL
+	state, err := oidc.NewLoginState(provider.Name(), ttl)
+	if err != nil {
+		c.Error(apierror.Internal("Failed to start login").Wrap(err))
+		return
+	}
+	authURL, err := provider.AuthCodeURL(ctx, state)
+	if err != nil {
+		c.Error(errOIDCProvider.Wrap(err))
+		return
+	}
+	sealed, err := state.Seal(h.oidcStateKey())
+	if err != nil {
+		c.Error(apierror.Internal("Failed to start login").Wrap(err))
+		return
+	}
+
+	setOIDCStateCookie(c, provider, sealed, int(ttl.Seconds()))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// GET /api/auth/oidc/:provider/callback - Finish logging in with the
+// provider, which redirects here with a code and the state
+func (h *Handler) OIDCCallback(c *gin.Context) {
+	provider, ok := h.OIDC[c.Param("provider")]
+	if !ok {
+		c.Error(errProviderNotFound)
+		return
+	}
+
+	// A login state is good for one callback, whatever its outcome
+	sealed, _ := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, provider, "", -1)
+	state, err := oidc.OpenLoginState(h.oidcStateKey(), sealed, time.Now())
+	if err != nil || state.Provider != provider.Name() || !state.Matches(c.Query("state")) {
+		c.Error(errOIDCStateInvalid)
+		return
+	}
+
+	// The user declined, or the provider refused the request
+	if reason := c.Query("error"); reason != "" {
+		h.Metrics.Login("failure")
+		c.Error(errOIDCLoginFailed.Wrap(fmt.Errorf("provider returned %q", reason)))
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		c.Error(errOIDCLoginFailed.Wrap(errors.New("no authorization code")))
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	claims, err := provider.Exchange(ctx, code, state)
+	if errors.Is(err, oidc.ErrExchange) || errors.Is(err, oidc.ErrInvalidToken) {
+		h.Metrics.Login("failure")
+		c.Error(errOIDCLoginFailed.Wrap(err))
+		return
+	}
+	if err != nil {
+		c.Error(errOIDCProvider.Wrap(err))
+		return
+	}
+
+	user, err := h.oidcUser(ctx, provider.Name(), claims, true)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	h.signIn(ctx, c, user, auth.MethodOIDC)
+}
+
+// oidcUser returns the user a provider account logs in as: the user it is
+// linked to; else the user with its email address, once linked; else a new
+// user. Only an address the provider has verified is trusted to reach or
+// create an account. A conflicting write is most likely a concurrent login
+// for the same account, so with retry the lookup is tried once more; a
+// conflict that persists gets errOIDCConflict.
+func (h *Handler) oidcUser(ctx context.Context, provider string, claims *oidc.Claims, retry bool) (*models.User, error) {
+	user, err := h.Users.FindByIdentity(ctx, provider, claims.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, repository.ErrNotFound) {
+		return nil, apierror.Internal("Failed to log in").Wrap(err)
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return nil, errOIDCEmail
+	}
+	email := strings.ToLower(claims.Email)
+	identity := models.Identity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    email,
+		LinkedAt: time.Now(),
+	}
+
+	user, err = h.Users.FindByEmail(ctx, email)
+	if errors.Is(err, repository.ErrNotFound) {
+		return h.createOIDCUser(ctx, claims, identity, retry)
+	}
+	if err != nil {
+		return nil, apierror.Internal("Failed to log in").Wrap(err)
+	}
+
+	// Whoever registered an account that was never verified may not own the
+	// address, so the provider's word takes the account over from them
+	takeOver := !user.EmailVerified
+	err = h.Users.LinkIdentity(ctx, user.UserID, identity, takeOver)
+	if errors.Is(err, repository.ErrDuplicate) {
+		// Lost a race with another login linking the same account
+		if !retry {
+			return nil, errOIDCConflict.Wrap(err)
+		}
+		return h.oidcUser(ctx, provider, claims, false)
+	}
+	if err != nil {
+		return nil, apierror.Internal("Failed to link account").Wrap(err)
+	}
+	if takeOver {
+		slog.WarnContext(ctx, "unverified account taken over through an identity provider", "user_id", user.UserID, "provider", provider)
+	} else {
+		slog.InfoContext(ctx, "identity provider linked by email", "user_id", user.UserID, "provider", provider)
+	}
+
+	user, err = h.Users.FindByUserID(ctx, user.UserID)
+	if err != nil {
+		return nil, lookupError(err, apierror.ErrUserNotFound)
+	}
+	return user, nil
+}
+
+// createOIDCUser signs up the owner of a provider account. They have no
+// password or phone until they set them. retry is as for oidcUser.
+func (h *Handler) createOIDCUser(ctx context.Context, claims *oidc.Claims, identity models.Identity, retry bool) (*models.User, error) {
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(strings.TrimSpace(claims.Name), " ")
+	}
+
+	now := time.Now()
+	user := models.User{
+		FirstName:     firstName,
+		LastName:      lastName,
+		Email:         identity.Email,
+		EmailVerified: true,
+		Identities:    []models.Identity{identity},
+		UserID:        primitive.NewObjectID().Hex(),
+		UserCart:      []models.ProductUser{},
+		Address:       []models.Address{},
+		Orders:        []models.Order{},
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	err := h.Users.Create(ctx, &user)
+	if errors.Is(err, repository.ErrDuplicate) {
+		// Lost a race with a signup or login for the same email or account
+		if !retry {
+			return nil, errOIDCConflict.Wrap(err)
+		}
+		return h.oidcUser(ctx, identity.Provider, claims, false)
+	}
+	if err != nil {
+		return nil, apierror.Internal("Failed to create user").Wrap(err)
+	}
+	h.Metrics.Signup()
+	slog.InfoContext(ctx, "user signed up through an identity provider", "user_id", user.UserID, "provider", identity.Provider)
+	return &user, nil
+}
+
+// oidcStateKey seals login state; it is kept apart from other uses of the
+// secret by the label the seal adds.
+func (h *Handler) oidcStateKey() []byte {
+	return []byte(h.Config.Auth.JWTSecret)
+}
+
+// setOIDCStateCookie stores, or with maxAge -1 deletes, the login state.
+// It must come back on the provider's top-level redirect, so it is
+// SameSite=Lax, and Secure whenever the redirect is over https.
+func setOIDCStateCookie(c *gin.Context, provider *oidc.Provider, value string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	secure := strings.HasPrefix(provider.RedirectURL(), "https://")
+	c.SetCookie(oidcStateCookie, value, maxAge, "/api/auth/oidc/", "", secure, true)
+}
@@ -1,35 +1,30 @@
 package controllers
 
 import (
-	"context"
 	"net/http"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 
-	"ecomm-backend/config"
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
 	"ecomm-backend/models"
 )
 
 // GET /api/orders
-func GetOrders(c *gin.Context) {
-	userData, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+func (h *Handler) GetOrders(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
 		return
 	}
+	userID := principal.UserID
 
-	userMap := userData.(map[string]interface{})
-	userID := userMap["uid"].(string)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	var user models.User
-	err := config.UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	user, err := h.Users.FindByUserID(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
 		return
 	}
 
@@ -37,41 +32,89 @@ func GetOrders(c *gin.Context) {
 }
 
 // GET /api/orders/:id
-func GetOrderById(c *gin.Context) {
-	userData, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+func (h *Handler) GetOrderById(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
 		return
 	}
-
-	userMap := userData.(map[string]interface{})
-	userID := userMap["uid"].(string)
+	userID := principal.UserID
 	orderID := c.Param("id")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	var user models.User
-	err := config.UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	user, err := h.Users.FindByUserID(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
 		return
 	}
 
-	// Find order
-	var foundOrder *models.Order
-	for i := range user.Orders {
-		if user.Orders[i].ID.Hex() == orderID {
-			foundOrder = &user.Orders[i]
-			break
-		}
-	}
-
+	foundOrder := findOrder(user, orderID)
 	if foundOrder == nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		c.Error(apierror.ErrOrderNotFound)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"order": foundOrder})
 }
 
+// GET /api/orders/:id/invoice
+func (h *Handler) GetOrderInvoice(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
+		return
+	}
+	userID := principal.UserID
+	orderID := c.Param("id")
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	user, err := h.Users.FindByUserID(ctx, userID)
+	if err != nil {
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
+		return
+	}
+
+	order := findOrder(user, orderID)
+	if order == nil {
+		c.Error(apierror.ErrOrderNotFound)
+		return
+	}
+
+	// Orders placed before tax and shipping were introduced only carry a total
+	subtotal := order.Subtotal
+	if order.Tax == nil && order.Shipping == nil {
+		subtotal = order.TotalPrice
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invoice": gin.H{
+			"invoice_number": "INV-" + order.ID.Hex(),
+			"order_id":       order.ID.Hex(),
+			"issued_on":      order.OrderedOn,
+			"billed_to": gin.H{
+				"name":  user.FirstName + " " + user.LastName,
+				"email": user.Email,
+				"phone": user.Phone,
+			},
+			"delivery_address": order.DeliveryAddress,
+			"items":            order.OrderList,
+			"subtotal":         subtotal,
+			"tax":              order.Tax,
+			"shipping":         order.Shipping,
+			"total":            order.TotalPrice,
+		},
+	})
+}
+
+func findOrder(user *models.User, orderID string) *models.Order {
+	for i := range user.Orders {
+		if user.Orders[i].ID.Hex() == orderID {
+			return &user.Orders[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
+	"ecomm-backend/models"
+	"ecomm-backend/notify"
+	"ecomm-backend/repository"
+	"ecomm-backend/utils"
+	"ecomm-backend/validation"
+)
+
+var errOTPInvalid = apierror.Unauthorized(apierror.CodeOTPInvalid, "The code is invalid or has expired")
+
+// POST /api/auth/otp/request
+func (h *Handler) RequestOTP(c *gin.Context) {
+	var req struct {
+		Phone string `json:"phone" binding:"required,phone"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(validation.Error(err))
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	// The response is the same whether or not the account exists
+	accepted := gin.H{"message": "If an account exists for this phone number, a code has been sent"}
+
+	user, err := h.Users.FindByPhone(ctx, req.Phone)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+	if err != nil {
+		c.Error(apierror.Internal("Failed to send code").Wrap(err))
+		return
+	}
+
+	// Within the resend interval the code already sent stays the one to use
+	cfg := h.Config.Auth.OTP
+	if user.LoginOTP != nil && time.Since(user.LoginOTP.SentAt) < cfg.ResendInterval {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	code, err := utils.NewOTP(cfg.Length)
+	if err != nil {
+		c.Error(apierror.Internal("Failed to send code").Wrap(err))
+		return
+	}
+	now := time.Now()
+	err = h.Users.SetLoginOTP(ctx, user.UserID, models.LoginOTP{
+		CodeHash:  utils.HashOTP(h.Config.Auth.JWTSecret, user.UserID, code),
+		ExpiresAt: now.Add(cfg.TTL),
+		SentAt:    now,
+	})
+	if err != nil {
+		c.Error(apierror.Internal("Failed to send code").Wrap(err))
+		return
+	}
+
+	err = h.SMS.Send(ctx, notify.Message{
+		To:   user.Phone,
+		Body: fmt.Sprintf("%s is your login code. It expires in %s. Never share it with anyone.", code, cfg.TTL),
+	})
+	if err != nil {
+		c.Error(apierror.Internal("Failed to send code").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, accepted)
+}
+
+// POST /api/auth/otp/verify
+func (h *Handler) VerifyOTP(c *gin.Context) {
+	var req struct {
+		Phone string `json:"phone" binding:"required,phone"`
+		Code  string `json:"code" binding:"required,numeric,max=10"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(validation.Error(err))
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	user, err := h.Users.FindByPhone(ctx, req.Phone)
+	if err != nil {
+		h.Metrics.Login("failure")
+		c.Error(lookupError(err, errOTPInvalid))
+		return
+	}
+	// A locked account answers like a wrong code, as password login does
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		h.Metrics.Login("locked")
+		c.Error(errOTPInvalid)
+		return
+	}
+	if user.LoginOTP == nil || time.Now().After(user.LoginOTP.ExpiresAt) {
+		h.Metrics.Login("failure")
+		c.Error(errOTPInvalid)
+		return
+	}
+
+	// Count the guess before checking it, so concurrent guesses can't get
+	// past the limit
+	attempts, err := h.Users.RecordOTPAttempt(ctx, user.UserID)
+	if err != nil {
+		c.Error(lookupError(err, errOTPInvalid))
+		return
+	}
+	// Wrong codes also count towards the password lockout, which a new code
+	// doesn't reset
+	hash := utils.HashOTP(h.Config.Auth.JWTSecret, user.UserID, req.Code)
+	if attempts > h.Config.Auth.OTP.MaxAttempts || !hmac.Equal([]byte(hash), []byte(user.LoginOTP.CodeHash)) {
+		h.recordFailedLogin(ctx, user.UserID)
+		h.Metrics.Login("failure")
+		c.Error(errOTPInvalid)
+		return
+	}
+
+	// The code is single use, and receiving it proves the phone number
+	err = h.Users.ConsumeLoginOTP(ctx, user.UserID, hash)
+	if err != nil {
+		c.Error(lookupError(err, errOTPInvalid))
+		return
+	}
+	user.PhoneVerified = true
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := h.Users.ResetFailedLogins(ctx, user.UserID); err != nil {
+			c.Error(apierror.Internal("Failed to log in").Wrap(err))
+			return
+		}
+	}
+
+	h.signIn(ctx, c, user, auth.MethodOTP)
+}
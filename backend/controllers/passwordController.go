@@ -0,0 +1,130 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/models"
+	"ecomm-backend/notify"
+	"ecomm-backend/repository"
+	"ecomm-backend/utils"
+	"ecomm-backend/validation"
+)
+
+var errResetTokenInvalid = apierror.BadRequest(apierror.CodeResetTokenInvalid, "The reset link is invalid or has expired")
+
+// POST /api/auth/forgot-password
+func (h *Handler) ForgotPassword(c *gin.Context) {
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(validation.Error(err))
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	// The response is the same whether or not the account exists
+	accepted := gin.H{"message": "If an account exists for this email, a reset link has been sent"}
+
+	user, err := h.Users.FindByEmail(ctx, strings.ToLower(req.Email))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+	if err != nil {
+		c.Error(apierror.Internal("Failed to request password reset").Wrap(err))
+		return
+	}
+
+	token, hash, err := utils.NewSecretToken()
+	if err != nil {
+		c.Error(apierror.Internal("Failed to request password reset").Wrap(err))
+		return
+	}
+	ttl := h.Config.Auth.PasswordResetTTL
+	err = h.Users.SetPasswordReset(ctx, user.UserID, models.PasswordReset{
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		c.Error(apierror.Internal("Failed to request password reset").Wrap(err))
+		return
+	}
+
+	err = h.Notifier.Send(ctx, notify.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Use this link within %s to choose a new password:\n\n%s\n\nIf you didn't ask for this, ignore this message.",
+			ttl, tokenLink(h.Config.Auth.PasswordResetURL, token)),
+	})
+	if err != nil {
+		c.Error(apierror.Internal("Failed to send reset link").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, accepted)
+}
+
+// POST /api/auth/reset-password
+func (h *Handler) ResetPassword(c *gin.Context) {
+	var req struct {
+		Token    string `json:"token" binding:"required"`
+		Password string `json:"password" binding:"required,min=6,max=72"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(validation.Error(err))
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	hash := utils.HashSecretToken(req.Token)
+	user, err := h.Users.FindByPasswordReset(ctx, hash)
+	if err != nil {
+		c.Error(lookupError(err, errResetTokenInvalid))
+		return
+	}
+	if time.Now().After(user.PasswordReset.ExpiresAt) {
+		c.Error(errResetTokenInvalid)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), h.Config.Auth.BcryptCost)
+	if err != nil {
+		c.Error(apierror.Internal("Failed to hash password").Wrap(err))
+		return
+	}
+
+	// Consumes the token and signs the user out everywhere
+	err = h.Users.ResetPassword(ctx, user.UserID, hash, string(hashedPassword))
+	if err != nil {
+		c.Error(lookupError(err, errResetTokenInvalid))
+		return
+	}
+
+	err = h.Notifier.Send(ctx, notify.Message{
+		To:      user.Email,
+		Subject: "Your password was changed",
+		Body:    "Your password was just reset and you have been signed out on every device. If this wasn't you, reset it again and contact support.",
+	})
+	if err != nil {
+		// The reset itself succeeded, so don't fail the request over the notice
+		slog.ErrorContext(ctx, "failed to send password change notice", "user_id", user.UserID, "error", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Your password has been reset. Please log in again."})
+}
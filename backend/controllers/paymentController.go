@@ -1,70 +1,143 @@
 package controllers
 
 import (
-	"fmt"
+	"context"
 	"net/http"
-	"os"
-	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
+	"ecomm-backend/models"
+	"ecomm-backend/validation"
 )
 
+// errOrderNotPending answers payments for orders that aren't awaiting one.
+var errOrderNotPending = apierror.Conflict(apierror.CodeOrderNotPending, "Order is not awaiting payment")
+
 // POST /api/payment/create-order
-func CreatePaymentOrder(c *gin.Context) {
+func (h *Handler) CreatePaymentOrder(c *gin.Context) {
 	var req struct {
-		Amount  float64 `json:"amount"`
-		Items   []interface{} `json:"items"`
-		Address interface{} `json:"address"`
+		OrderID string `json:"order_id" binding:"required"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		c.Error(validation.Error(err))
+		return
+	}
+
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
 		return
 	}
+	userID := principal.UserID
 
-	// Generate mock order ID
-	orderID := fmt.Sprintf("order_%d_%s", time.Now().UnixNano(), fmt.Sprintf("%x", time.Now().UnixNano())[:9])
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
 
-	razorpayKey := os.Getenv("RAZORPAY_KEY")
-	if razorpayKey == "" {
-		razorpayKey = "rzp_test_key"
+	user, err := h.Users.FindByUserID(ctx, userID)
+	if err != nil {
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"order_id":    orderID,
-		"amount":      req.Amount * 100, // Convert to paise
-		"currency":    "INR",
-		"razorpay_key": razorpayKey,
-	})
+	// The amount is the total checkout stored, never one the client sends
+	order := findOrder(user, req.OrderID)
+	if order == nil {
+		c.Error(apierror.ErrOrderNotFound)
+		return
+	}
+	if order.Status != models.OrderStatusPendingPayment {
+		c.Error(errOrderNotPending)
+		return
+	}
+
+	paymentOrder, err := h.Payments.CreateOrder(ctx, order.TotalPrice, "INR", order.ID.Hex())
+	if err != nil {
+		h.Metrics.PaymentFailed("create_order")
+		c.Error(errPaymentGateway.Wrap(err))
+		return
+	}
+	if err := h.Users.SetOrderGatewayID(ctx, userID, order.ID, paymentOrder.ID); err != nil {
+		c.Error(apierror.Internal("Failed to update order").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, paymentOrder)
 }
 
 // POST /api/payment/verify
-func VerifyPayment(c *gin.Context) {
+func (h *Handler) VerifyPayment(c *gin.Context) {
 	var req struct {
-		RazorpayOrderID   string `json:"razorpay_order_id"`
-		RazorpayPaymentID string `json:"razorpay_payment_id"`
-		RazorpaySignature string `json:"razorpay_signature"`
+		RazorpayOrderID   string        `json:"razorpay_order_id" binding:"required"`
+		RazorpayPaymentID string        `json:"razorpay_payment_id" binding:"required"`
+		RazorpaySignature string        `json:"razorpay_signature" binding:"required"`
 		Items             []interface{} `json:"items"`
-		Address           interface{} `json:"address"`
-		Total             float64 `json:"total"`
+		Address           interface{}   `json:"address"`
+		Total             float64       `json:"total"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		c.Error(validation.Error(err))
+		return
+	}
+
+	err := h.Payments.VerifyPayment(req.RazorpayOrderID, req.RazorpayPaymentID, req.RazorpaySignature)
+	if err != nil {
+		h.Metrics.PaymentFailed("verify")
+		c.Error(apierror.BadRequest(apierror.CodePaymentFailed, "Payment verification failed").Wrap(err))
+		return
+	}
+
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
+		return
+	}
+	userID := principal.UserID
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	// Mark the checkout order awaiting this payment as paid
+	paid, err := h.Users.MarkOrderPaid(ctx, userID, req.RazorpayOrderID, req.RazorpayPaymentID)
+	if err != nil {
+		c.Error(apierror.Internal("Failed to update order").Wrap(err))
+		return
+	}
+
+	if !paid {
+		c.Error(h.unpaidOrderError(ctx, userID, req.RazorpayOrderID))
 		return
 	}
 
-	// Mock verification - always succeeds
 	c.JSON(http.StatusOK, gin.H{
-		"success":     true,
-		"message":     "Payment verified successfully",
-		"order_id":    req.RazorpayOrderID,
-		"payment_id":  req.RazorpayPaymentID,
+		"success":    true,
+		"message":    "Payment verified successfully",
+		"order_id":   req.RazorpayOrderID,
+		"payment_id": req.RazorpayPaymentID,
+		"status":     models.OrderStatusPaid,
 	})
 }
 
+// unpaidOrderError explains why no pending order matched a verified payment:
+// the user has no order for the gateway order, or it is no longer pending.
+func (h *Handler) unpaidOrderError(ctx context.Context, userID, gatewayOrderID string) *apierror.Error {
+	user, err := h.Users.FindByUserID(ctx, userID)
+	if err != nil {
+		return lookupError(err, apierror.ErrUserNotFound)
+	}
+	for _, order := range user.Orders {
+		if order.RazorpayOrderID == gatewayOrderID {
+			return errOrderNotPending
+		}
+	}
+	return apierror.ErrOrderNotFound
+}
+
 // GET /api/payment/:id
-func GetPaymentStatus(c *gin.Context) {
+func (h *Handler) GetPaymentStatus(c *gin.Context) {
 	paymentID := c.Param("id")
 
 	// Mock payment status
@@ -74,4 +147,3 @@ func GetPaymentStatus(c *gin.Context) {
 		"amount":     0,
 	})
 }
-
@@ -1,36 +1,26 @@
 package controllers
 
 import (
-	"context"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 
-	"ecomm-backend/config"
+	"ecomm-backend/apierror"
 	"ecomm-backend/models"
+	"ecomm-backend/validation"
 )
 
-
 // GET /api/products - Get all products
-func GetAllProducts(c *gin.Context) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (h *Handler) GetAllProducts(c *gin.Context) {
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	var products []models.Product
-	cursor, err := config.ProductCollection.Find(ctx, bson.M{})
+	products, err := h.Products.FindAll(ctx)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
-		return
-	}
-	defer cursor.Close(ctx)
-
-	if err = cursor.All(ctx, &products); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+		c.Error(apierror.Internal("Failed to fetch products").Wrap(err))
 		return
 	}
 
@@ -53,21 +43,16 @@ func GetAllProducts(c *gin.Context) {
 			mockProducts[i].UpdatedAt = time.Now()
 		}
 
-		_, err = config.ProductCollection.InsertMany(ctx, convertToInterfaceSlice(mockProducts))
+		err = h.Products.InsertMany(ctx, mockProducts)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to seed products"})
+			c.Error(apierror.Internal("Failed to seed products").Wrap(err))
 			return
 		}
 
 		// Fetch again
-		cursor, err = config.ProductCollection.Find(ctx, bson.M{})
+		products, err = h.Products.FindAll(ctx)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
-			return
-		}
-		defer cursor.Close(ctx)
-		if err = cursor.All(ctx, &products); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch products"})
+			c.Error(apierror.Internal("Failed to fetch products").Wrap(err))
 			return
 		}
 	}
@@ -76,27 +61,14 @@ func GetAllProducts(c *gin.Context) {
 }
 
 // GET /api/products/:id
-func GetProductById(c *gin.Context) {
+func (h *Handler) GetProductById(c *gin.Context) {
 	id := c.Param("id")
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	var product models.Product
-	objectID, err := primitive.ObjectIDFromHex(id)
-	if err == nil {
-		// Try MongoDB ObjectID
-		err = config.ProductCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&product)
-	} else {
-		// Try product_id field
-		err = config.ProductCollection.FindOne(ctx, bson.M{"product_id": id}).Decode(&product)
-	}
-
+	product, err := h.Products.FindByID(ctx, id)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Product not found"})
-			return
-		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch product"})
+		c.Error(lookupError(err, apierror.ErrProductNotFound))
 		return
 	}
 
@@ -104,30 +76,23 @@ func GetProductById(c *gin.Context) {
 }
 
 // GET /api/products/search?name=query
-func SearchProducts(c *gin.Context) {
-	query := c.Query("name")
-	if query == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"Error": "Invalid Search Index"})
+func (h *Handler) SearchProducts(c *gin.Context) {
+	var req struct {
+		Name string `form:"name" binding:"required,max=100"`
+	}
+
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.Error(validation.Error(err))
 		return
 	}
+	query := req.Name
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	var products []models.Product
-	filter := bson.M{
-		"product_name": bson.M{"$regex": query, "$options": "i"},
-	}
-
-	cursor, err := config.ProductCollection.Find(ctx, filter)
+	products, err := h.Products.SearchByName(ctx, query)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
-		return
-	}
-	defer cursor.Close(ctx)
-
-	if err = cursor.All(ctx, &products); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search products"})
+		c.Error(apierror.Internal("Failed to search products").Wrap(err))
 		return
 	}
 
@@ -137,12 +102,3 @@ func SearchProducts(c *gin.Context) {
 func floatPtr(f float64) *float64 {
 	return &f
 }
-
-func convertToInterfaceSlice(products []models.Product) []interface{} {
-	result := make([]interface{}, len(products))
-	for i, p := range products {
-		result[i] = p
-	}
-	return result
-}
-
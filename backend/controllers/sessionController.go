@@ -0,0 +1,174 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
+	"ecomm-backend/models"
+	"ecomm-backend/repository"
+	"ecomm-backend/utils"
+	"ecomm-backend/validation"
+)
+
+var (
+	errRefreshInvalid = apierror.Unauthorized(apierror.CodeTokenInvalid, "The refresh token is invalid or has expired")
+	errTokenRevoked   = apierror.Unauthorized(apierror.CodeTokenRevoked, "The token has been revoked")
+)
+
+// sessionView is a session as listed to its user. Current marks the session
+// the request was made with.
+type sessionView struct {
+	models.Session
+	Current bool `json:"current"`
+}
+
+// GET /api/user/sessions - Active sessions, most recently used first
+func (h *Handler) GetSessions(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
+		return
+	}
+	userID := principal.UserID
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	user, err := h.Users.FindByUserID(ctx, userID)
+	if err != nil {
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
+		return
+	}
+
+	now := time.Now()
+	sessions := []sessionView{}
+	for _, session := range user.Sessions {
+		if now.Before(session.ExpiresAt) {
+			sessions = append(sessions, sessionView{Session: session, Current: session.ID == principal.SessionID})
+		}
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// DELETE /api/user/sessions/:id - Log a session out
+func (h *Handler) RevokeSession(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
+		return
+	}
+	userID := principal.UserID
+	sessionID := c.Param("id")
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	err := h.Users.RevokeSession(ctx, userID, sessionID)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.Error(apierror.ErrSessionNotFound)
+		return
+	}
+	if err != nil {
+		c.Error(apierror.Internal("Failed to revoke session").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// POST /api/auth/refresh - Trade a refresh token for a new token pair in
+// the same session
+func (h *Handler) RefreshToken(c *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(validation.Error(err))
+		return
+	}
+
+	claims, err := h.Tokens.ValidateRefresh(req.RefreshToken)
+	if err != nil {
+		c.Error(errRefreshInvalid)
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	user, err := h.Users.FindByUserID(ctx, claims.Subject)
+	if err != nil {
+		c.Error(lookupError(err, errRefreshInvalid))
+		return
+	}
+
+	// The same checks as an access token: the session is still open and
+	// nothing has revoked the user's tokens since
+	now := time.Now()
+	session, ok := user.ActiveSession(claims.SessionID, now)
+	if user.TokenVersion != claims.Version || !ok {
+		c.Error(errTokenRevoked)
+		return
+	}
+	// Each refresh token works once. Only the session's latest is accepted,
+	// and an older one coming back means it was stolen, so the session ends
+	if session.RefreshID != "" && claims.ID != session.RefreshID {
+		h.revokeReusedSession(ctx, user.UserID, session.ID)
+		c.Error(errTokenRevoked)
+		return
+	}
+	if user.Role == models.RoleAdmin && !twoFactorEnabled(user) {
+		c.Error(apierror.ErrTwoFactorRequired)
+		return
+	}
+
+	refreshID, err := utils.NewTokenID()
+	if err != nil {
+		c.Error(apierror.Internal("Failed to generate token").Wrap(err))
+		return
+	}
+	token, refreshToken, err := h.Tokens.Generate(user.Email, user.FirstName, user.LastName, user.UserID, user.TokenVersion, claims.AuthMethod, session.ID, refreshID)
+	if err != nil {
+		c.Error(apierror.Internal("Failed to generate token").Wrap(err))
+		return
+	}
+	// A concurrent refresh with the same token got there first
+	err = h.Users.RotateRefresh(ctx, user.UserID, session.ID, session.RefreshID, refreshID, now)
+	if errors.Is(err, repository.ErrNotFound) {
+		h.revokeReusedSession(ctx, user.UserID, session.ID)
+		c.Error(errTokenRevoked)
+		return
+	}
+	if err != nil {
+		c.Error(apierror.Internal("Failed to update session").Wrap(err))
+		return
+	}
+	if err := h.Users.UpdateTokens(ctx, user.UserID, token, refreshToken); err != nil {
+		c.Error(apierror.Internal("Failed to update tokens").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"token": token, "refresh_token": refreshToken})
+}
+
+// revokeReusedSession ends a session whose refresh token was used twice.
+// The caller refuses the request either way.
+func (h *Handler) revokeReusedSession(ctx context.Context, userID, sessionID string) {
+	slog.WarnContext(ctx, "refresh token reused; revoking session", "session_id", sessionID)
+	if err := h.Users.RevokeSession(ctx, userID, sessionID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+		slog.ErrorContext(ctx, "failed to revoke session", "session_id", sessionID, "error", err)
+	}
+}
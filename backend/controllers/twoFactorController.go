@@ -0,0 +1,533 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
+	"ecomm-backend/models"
+	"ecomm-backend/notify"
+	"ecomm-backend/repository"
+	"ecomm-backend/totp"
+	"ecomm-backend/utils"
+	"ecomm-backend/validation"
+)
+
+var (
+	errChallengeInvalid  = apierror.Unauthorized(apierror.CodeChallengeInvalid, "The login challenge is invalid or has expired")
+	errSecondFactor      = apierror.Unauthorized(apierror.CodeTwoFactorInvalid, "The authentication code is incorrect")
+	errEnrollmentCode    = apierror.Unauthorized(apierror.CodeTwoFactorInvalid, "The enrollment code is incorrect")
+	errTwoFactorCode     = apierror.BadRequest(apierror.CodeTwoFactorInvalid, "The authentication code is incorrect")
+	errTwoFactorEnabled  = apierror.Conflict(apierror.CodeTwoFactorEnabled, "Two-factor authentication is already enabled")
+	errTwoFactorDisabled = apierror.Conflict(apierror.CodeTwoFactorDisabled, "Two-factor authentication is not enabled")
+)
+
+// recoveryCodeCount is how many recovery codes a user gets at a time.
+const recoveryCodeCount = 10
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func twoFactorEnabled(user *models.User) bool {
+	return user.TwoFactor != nil && user.TwoFactor.Enabled
+}
+
+// challenge answers the password step of login for a user who owes a second
+// factor with a short-lived challenge token. Admins without two-factor yet
+// use it to enroll, together with a code mailed to them, so that a password
+// alone isn't enough to bind a new authenticator.
+func (h *Handler) challenge(ctx context.Context, c *gin.Context, user *models.User) {
+	ttl := h.Config.Auth.TwoFactor.ChallengeTTL
+	token, err := h.Tokens.GenerateChallenge(user.UserID, user.TokenVersion, ttl)
+	if err != nil {
+		c.Error(apierror.Internal("Failed to generate token").Wrap(err))
+		return
+	}
+	enroll := !twoFactorEnabled(user)
+	if enroll {
+		err := h.Notifier.Send(ctx, notify.Message{
+			To:      user.Email,
+			Subject: "Set up two-factor authentication",
+			Body: fmt.Sprintf("%s is your code to set up two-factor authentication. It expires in %s.\n\nIf you didn't just log in, change your password now.",
+				h.enrollmentCode(token), ttl),
+		})
+		if err != nil {
+			c.Error(apierror.Internal("Failed to send enrollment code").Wrap(err))
+			return
+		}
+	}
+	h.Metrics.Login("challenge")
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"two_factor_required": true,
+		"enrollment_required": enroll,
+		"challenge_token":     token,
+		"expires_in":          int(ttl.Seconds()),
+	})
+}
+
+// enrollmentCode derives the 6-digit code mailed for a login challenge, so
+// it needs no storage and expires with the challenge.
+func (h *Handler) enrollmentCode(challengeToken string) string {
+	mac := hmac.New(sha256.New, []byte(h.Config.Auth.JWTSecret))
+	mac.Write([]byte("2fa-enrollment:" + challengeToken))
+	return fmt.Sprintf("%06d", binary.BigEndian.Uint32(mac.Sum(nil))%1000000)
+}
+
+// challengedUser loads the user a challenge token was issued to.
+func (h *Handler) challengedUser(ctx context.Context, token string) (*models.User, error) {
+	userID, version, err := h.Tokens.ValidateChallenge(token)
+	if err != nil {
+		return nil, errChallengeInvalid
+	}
+	user, err := h.Users.FindByUserID(ctx, userID)
+	if err != nil {
+		return nil, lookupError(err, errChallengeInvalid)
+	}
+	if user.TokenVersion != version {
+		return nil, errChallengeInvalid
+	}
+	return user, nil
+}
+
+// checkTOTP reports whether code is the user's current TOTP code, and uses
+// it up so it can't be replayed. It returns the time step the code was for.
+func (h *Handler) checkTOTP(ctx context.Context, user *models.User, code string) (int64, bool, error) {
+	step, ok := totp.Validate(user.TwoFactor.Secret, code, time.Now())
+	if !ok {
+		return 0, false, nil
+	}
+	err := h.Users.UseTOTPStep(ctx, user.UserID, step)
+	if errors.Is(err, repository.ErrNotFound) {
+		return 0, false, nil
+	}
+	return step, err == nil, err
+}
+
+// checkRecoveryCode reports whether code is one of the user's unused
+// recovery codes, and uses it up.
+func (h *Handler) checkRecoveryCode(ctx context.Context, user *models.User, code string) (bool, error) {
+	err := h.Users.UseRecoveryCode(ctx, user.UserID, h.hashRecoveryCode(user.UserID, code))
+	if errors.Is(err, repository.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *Handler) hashRecoveryCode(userID, code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return utils.HashOTP(h.Config.Auth.JWTSecret, userID, code)
+}
+
+// newRecoveryCodes returns fresh recovery codes to show the user once, and
+// their hashes to store.
+func (h *Handler) newRecoveryCodes(userID string) ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = h.hashRecoveryCode(userID, code)
+	}
+	return codes, hashes, nil
+}
+
+// startEnrollment gives the user a new pending TOTP secret.
+func (h *Handler) startEnrollment(ctx context.Context, user *models.User) (gin.H, error) {
+	if twoFactorEnabled(user) {
+		return nil, errTwoFactorEnabled
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return nil, apierror.Internal("Failed to start enrollment").Wrap(err)
+	}
+	if err := h.Users.SetTwoFactor(ctx, user.UserID, &models.TwoFactor{Secret: secret}); err != nil {
+		return nil, lookupError(err, apierror.ErrUserNotFound)
+	}
+	return gin.H{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(h.Config.Auth.TwoFactor.Issuer, user.Email, secret),
+	}, nil
+}
+
+// confirmEnrollment enables the pending secret once code shows the user's
+// authenticator has it, and returns their recovery codes.
+func (h *Handler) confirmEnrollment(ctx context.Context, user *models.User, code string, wrongCode *apierror.Error) ([]string, error) {
+	if user.TwoFactor == nil {
+		return nil, errTwoFactorDisabled.WithMessage("Start two-factor enrollment first")
+	}
+	if user.TwoFactor.Enabled {
+		return nil, errTwoFactorEnabled
+	}
+	step, ok := totp.Validate(user.TwoFactor.Secret, code, time.Now())
+	if !ok {
+		return nil, wrongCode
+	}
+
+	codes, hashes, err := h.newRecoveryCodes(user.UserID)
+	if err != nil {
+		return nil, apierror.Internal("Failed to enable two-factor authentication").Wrap(err)
+	}
+	err = h.Users.SetTwoFactor(ctx, user.UserID, &models.TwoFactor{
+		Secret:        user.TwoFactor.Secret,
+		Enabled:       true,
+		LastStep:      step,
+		RecoveryCodes: hashes,
+	})
+	if err != nil {
+		return nil, lookupError(err, apierror.ErrUserNotFound)
+	}
+	return codes, nil
+}
+
+// POST /api/auth/2fa/verify
+func (h *Handler) VerifyTwoFactor(c *gin.Context) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code" binding:"required_without=RecoveryCode,omitempty,numeric,len=6"`
+		RecoveryCode   string `json:"recovery_code" binding:"required_without=Code,omitempty,max=20"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(validation.Error(err))
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	user, err := h.challengedUser(ctx, req.ChallengeToken)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if !twoFactorEnabled(user) {
+		c.Error(errTwoFactorDisabled)
+		return
+	}
+	// A locked account answers like a wrong code, as login does
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		h.Metrics.Login("locked")
+		c.Error(errSecondFactor)
+		return
+	}
+
+	var ok bool
+	if req.Code != "" {
+		_, ok, err = h.checkTOTP(ctx, user, req.Code)
+	} else {
+		ok, err = h.checkRecoveryCode(ctx, user, req.RecoveryCode)
+	}
+	if err != nil {
+		c.Error(apierror.Internal("Failed to log in").Wrap(err))
+		return
+	}
+	// Wrong codes count towards the same lockout as wrong passwords
+	if !ok {
+		h.recordFailedLogin(ctx, user.UserID)
+		h.Metrics.Login("failure")
+		c.Error(errSecondFactor)
+		return
+	}
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := h.Users.ResetFailedLogins(ctx, user.UserID); err != nil {
+			c.Error(apierror.Internal("Failed to log in").Wrap(err))
+			return
+		}
+	}
+
+	h.completeLogin(ctx, c, user, auth.MethodTwoFactor)
+}
+
+// POST /api/auth/2fa/enroll - For admins who must enroll before logging in
+func (h *Handler) EnrollTwoFactorAtLogin(c *gin.Context) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		EnrollmentCode string `json:"enrollment_code" binding:"required,numeric,len=6"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(validation.Error(err))
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	user, err := h.challengedUser(ctx, req.ChallengeToken)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if twoFactorEnabled(user) {
+		c.Error(errTwoFactorEnabled)
+		return
+	}
+	// A locked account answers like a wrong code, as login does
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		h.Metrics.Login("locked")
+		c.Error(errEnrollmentCode)
+		return
+	}
+	// Wrong codes count towards the same lockout as wrong passwords
+	if !hmac.Equal([]byte(req.EnrollmentCode), []byte(h.enrollmentCode(req.ChallengeToken))) {
+		h.recordFailedLogin(ctx, user.UserID)
+		h.Metrics.Login("failure")
+		c.Error(errEnrollmentCode)
+		return
+	}
+	enrollment, err := h.startEnrollment(ctx, user)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// POST /api/auth/2fa/confirm - Finishes enrollment and logs in
+func (h *Handler) ConfirmTwoFactorAtLogin(c *gin.Context) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token" binding:"required"`
+		Code           string `json:"code" binding:"required,numeric,len=6"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(validation.Error(err))
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	user, err := h.challengedUser(ctx, req.ChallengeToken)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	// A locked account answers like a wrong code, as login does
+	if user.LockedUntil != nil && time.Now().Before(*user.LockedUntil) {
+		h.Metrics.Login("locked")
+		c.Error(errSecondFactor)
+		return
+	}
+	codes, err := h.confirmEnrollment(ctx, user, req.Code, errSecondFactor)
+	// Wrong codes count towards the same lockout as wrong passwords
+	if errors.Is(err, errSecondFactor) {
+		h.recordFailedLogin(ctx, user.UserID)
+		h.Metrics.Login("failure")
+		c.Error(err)
+		return
+	}
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if user.FailedLogins > 0 || user.LockedUntil != nil {
+		if err := h.Users.ResetFailedLogins(ctx, user.UserID); err != nil {
+			c.Error(apierror.Internal("Failed to log in").Wrap(err))
+			return
+		}
+	}
+	if err := h.startSession(ctx, c, user, auth.MethodTwoFactor); err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, struct {
+		*models.User
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{user, codes})
+}
+
+// GET /api/user/2fa
+func (h *Handler) GetTwoFactor(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	remaining := 0
+	if twoFactorEnabled(user) {
+		remaining = len(user.TwoFactor.RecoveryCodes)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":                  twoFactorEnabled(user),
+		"required":                 user.Role == models.RoleAdmin,
+		"recovery_codes_remaining": remaining,
+	})
+}
+
+// POST /api/user/2fa/enroll
+func (h *Handler) EnrollTwoFactor(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	enrollment, err := h.startEnrollment(ctx, user)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// POST /api/user/2fa/confirm
+func (h *Handler) ConfirmTwoFactor(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required,numeric,len=6"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(validation.Error(err))
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	codes, err := h.confirmEnrollment(ctx, user, req.Code, errTwoFactorCode)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// POST /api/user/2fa/recovery-codes - Replaces the recovery codes
+func (h *Handler) RegenerateRecoveryCodes(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required,numeric,len=6"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(validation.Error(err))
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	if !twoFactorEnabled(user) {
+		c.Error(errTwoFactorDisabled)
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	step, ok, err := h.checkTOTP(ctx, user, req.Code)
+	if err != nil || !ok {
+		c.Error(twoFactorCodeError(err))
+		return
+	}
+
+	codes, hashes, err := h.newRecoveryCodes(user.UserID)
+	if err != nil {
+		c.Error(apierror.Internal("Failed to create recovery codes").Wrap(err))
+		return
+	}
+	twoFactor := *user.TwoFactor
+	twoFactor.LastStep = step
+	twoFactor.RecoveryCodes = hashes
+	if err := h.Users.SetTwoFactor(ctx, user.UserID, &twoFactor); err != nil {
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DELETE /api/user/2fa
+func (h *Handler) DisableTwoFactor(c *gin.Context) {
+	var req struct {
+		Code string `json:"code" binding:"required,numeric,len=6"`
+	}
+
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(validation.Error(err))
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+	if user.Role == models.RoleAdmin {
+		c.Error(apierror.ErrTwoFactorRequired)
+		return
+	}
+	if !twoFactorEnabled(user) {
+		c.Error(errTwoFactorDisabled)
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	if _, ok, err := h.checkTOTP(ctx, user, req.Code); err != nil || !ok {
+		c.Error(twoFactorCodeError(err))
+		return
+	}
+	if err := h.Users.SetTwoFactor(ctx, user.UserID, nil); err != nil {
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Two-factor authentication disabled"})
+}
+
+// twoFactorCodeError maps a failed code check on the settings endpoints.
+func twoFactorCodeError(err error) error {
+	if err != nil {
+		return apierror.Internal("Failed to check code").Wrap(err)
+	}
+	return errTwoFactorCode
+}
+
+// currentUser loads the authenticated user, reporting any error on c.
+func (h *Handler) currentUser(c *gin.Context) (*models.User, bool) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
+		return nil, false
+	}
+	userID := principal.UserID
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	user, err := h.Users.FindByUserID(ctx, userID)
+	if err != nil {
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
+		return nil, false
+	}
+	return user, true
+}
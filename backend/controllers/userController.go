@@ -2,35 +2,40 @@ package controllers
 
 import (
 	"context"
+	"errors"
+	"log/slog"
 	"net/http"
 	"strings"
-	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 
-	"ecomm-backend/config"
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
 	"ecomm-backend/models"
+	"ecomm-backend/repository"
+	"ecomm-backend/validation"
+)
+
+var (
+	errEmailTaken = apierror.Conflict(apierror.CodeEmailTaken, "Email address is already in use")
+	errPhoneTaken = apierror.Conflict(apierror.CodePhoneTaken, "Phone number is already in use")
 )
 
 // GET /api/user/profile
-func GetProfile(c *gin.Context) {
-	userData, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+func (h *Handler) GetProfile(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
 		return
 	}
+	userID := principal.UserID
 
-	userMap := userData.(map[string]interface{})
-	userID := userMap["uid"].(string)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	var user models.User
-	err := config.UserCollection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&user)
+	user, err := h.Users.FindByUserID(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
 		return
 	}
 
@@ -41,56 +46,95 @@ func GetProfile(c *gin.Context) {
 }
 
 // PUT /api/user/profile
-func UpdateProfile(c *gin.Context) {
-	userData, exists := c.Get("user")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+func (h *Handler) UpdateProfile(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
 		return
 	}
-
-	userMap := userData.(map[string]interface{})
-	userID := userMap["uid"].(string)
+	userID := principal.UserID
 
 	var req struct {
-		FirstName string `json:"first_name"`
-		LastName  string `json:"last_name"`
-		Email     string `json:"email"`
-		Phone     string `json:"phone"`
+		FirstName string `json:"first_name" binding:"omitempty,min=2,max=30"`
+		LastName  string `json:"last_name" binding:"omitempty,min=2,max=30"`
+		Email     string `json:"email" binding:"omitempty,email"`
+		Phone     string `json:"phone" binding:"omitempty,phone"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		c.Error(validation.Error(err))
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := h.requestContext(c)
 	defer cancel()
 
-	update := bson.M{"$set": bson.M{"updatedAt": time.Now()}}
-	if req.FirstName != "" {
-		update["$set"].(bson.M)["first_name"] = req.FirstName
+	update := repository.ProfileUpdate{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Email:     strings.ToLower(req.Email),
+		Phone:     req.Phone,
 	}
-	if req.LastName != "" {
-		update["$set"].(bson.M)["last_name"] = req.LastName
-	}
-	if req.Email != "" {
-		update["$set"].(bson.M)["email"] = strings.ToLower(req.Email)
+
+	if update == (repository.ProfileUpdate{}) {
+		c.Error(apierror.BadRequest(apierror.CodeBadRequest, "No fields to update"))
+		return
 	}
-	if req.Phone != "" {
-		update["$set"].(bson.M)["phone"] = req.Phone
+
+	// A new email address or phone number has to be verified again
+	var verifyToken string
+	if update.Email != "" || update.Phone != "" {
+		user, err := h.Users.FindByUserID(ctx, userID)
+		if err != nil {
+			c.Error(lookupError(err, apierror.ErrUserNotFound))
+			return
+		}
+		update.PhoneChanged = update.Phone != "" && user.Phone != update.Phone
+		if update.Email != "" && user.Email != update.Email {
+			var verification models.EmailVerification
+			verifyToken, verification, err = h.newEmailVerification()
+			if err != nil {
+				c.Error(apierror.Internal("Failed to update profile").Wrap(err))
+				return
+			}
+			update.EmailVerification = &verification
+		}
 	}
 
-	if len(update["$set"].(bson.M)) == 1 { // Only updatedAt
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields to update"})
+	err := h.Users.UpdateProfile(ctx, userID, update)
+	if errors.Is(err, repository.ErrNotFound) {
+		c.Error(apierror.ErrUserNotFound)
+		return
+	}
+	if errors.Is(err, repository.ErrDuplicate) {
+		c.Error(h.profileConflict(ctx, userID, update))
 		return
 	}
-
-	_, err := config.UserCollection.UpdateOne(ctx, bson.M{"user_id": userID}, update)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		c.Error(apierror.Internal("Failed to update profile").Wrap(err))
 		return
 	}
 
+	if verifyToken != "" {
+		if err := h.sendVerificationEmail(ctx, update.Email, verifyToken); err != nil {
+			slog.ErrorContext(ctx, "failed to send verification email", "user_id", userID, "error", err)
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "Profile updated successfully"})
 }
 
+// profileConflict says which of the new email address or phone number
+// belongs to another user.
+func (h *Handler) profileConflict(ctx context.Context, userID string, update repository.ProfileUpdate) *apierror.Error {
+	if update.Email != "" {
+		other, err := h.Users.FindByEmail(ctx, update.Email)
+		if err == nil && other.UserID != userID {
+			return errEmailTaken
+		}
+	}
+	if update.Phone == "" {
+		return errEmailTaken
+	}
+	return errPhoneTaken
+}
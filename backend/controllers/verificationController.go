@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
+	"ecomm-backend/models"
+	"ecomm-backend/notify"
+	"ecomm-backend/ratelimit"
+	"ecomm-backend/utils"
+)
+
+var (
+	errVerifyTokenInvalid = apierror.BadRequest(apierror.CodeVerifyTokenInvalid, "The verification link is invalid or has expired")
+	errEmailVerified      = apierror.Conflict(apierror.CodeEmailVerified, "Email address is already verified")
+)
+
+// newEmailVerification returns a fresh verification to store and the token
+// to mail.
+func (h *Handler) newEmailVerification() (string, models.EmailVerification, error) {
+	token, hash, err := utils.NewSecretToken()
+	if err != nil {
+		return "", models.EmailVerification{}, err
+	}
+	now := time.Now()
+	return token, models.EmailVerification{
+		TokenHash: hash,
+		ExpiresAt: now.Add(h.Config.Auth.EmailVerification.TTL),
+		SentAt:    now,
+	}, nil
+}
+
+// sendVerificationEmail mails the verification link for token to email.
+func (h *Handler) sendVerificationEmail(ctx context.Context, email, token string) error {
+	cfg := h.Config.Auth.EmailVerification
+	return h.Notifier.Send(ctx, notify.Message{
+		To:      email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Open this link within %s to verify your email address:\n\n%s\n\nIf you didn't sign up, ignore this message.",
+			cfg.TTL, tokenLink(cfg.URL, token)),
+	})
+}
+
+// GET /api/auth/verify-email?token=<token>
+func (h *Handler) VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.Error(errVerifyTokenInvalid)
+		return
+	}
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	hash := utils.HashSecretToken(token)
+	user, err := h.Users.FindByEmailVerification(ctx, hash)
+	if err != nil {
+		c.Error(lookupError(err, errVerifyTokenInvalid))
+		return
+	}
+	if time.Now().After(user.EmailVerification.ExpiresAt) {
+		c.Error(errVerifyTokenInvalid)
+		return
+	}
+
+	err = h.Users.VerifyEmail(ctx, user.UserID, hash)
+	if err != nil {
+		c.Error(lookupError(err, errVerifyTokenInvalid))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Your email address has been verified"})
+}
+
+// POST /api/auth/verify-email/resend
+func (h *Handler) ResendVerificationEmail(c *gin.Context) {
+	principal, ok := auth.Get(c)
+	if !ok {
+		c.Error(apierror.ErrUnauthenticated)
+		return
+	}
+	userID := principal.UserID
+
+	ctx, cancel := h.requestContext(c)
+	defer cancel()
+
+	user, err := h.Users.FindByUserID(ctx, userID)
+	if err != nil {
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
+		return
+	}
+	if user.EmailVerified {
+		c.Error(errEmailVerified)
+		return
+	}
+
+	// One link per resend interval, so the endpoint can't be used to flood
+	// an inbox
+	if user.EmailVerification != nil {
+		next := user.EmailVerification.SentAt.Add(h.Config.Auth.EmailVerification.ResendInterval)
+		if wait := time.Until(next); wait > 0 {
+			c.Header("Retry-After", ratelimit.RetryAfter(wait))
+			c.Error(apierror.ErrRateLimited)
+			return
+		}
+	}
+
+	token, verification, err := h.newEmailVerification()
+	if err != nil {
+		c.Error(apierror.Internal("Failed to resend verification email").Wrap(err))
+		return
+	}
+	if err := h.Users.SetEmailVerification(ctx, userID, verification); err != nil {
+		c.Error(lookupError(err, apierror.ErrUserNotFound))
+		return
+	}
+	if err := h.sendVerificationEmail(ctx, user.Email, token); err != nil {
+		c.Error(apierror.Internal("Failed to send verification email").Wrap(err))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Verification email sent"})
+}
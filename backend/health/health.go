@@ -0,0 +1,139 @@
+package health
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Readiness states reported by /readyz.
+const (
+	StatusStarting    = "starting"
+	StatusReady       = "ready"
+	StatusDraining    = "draining"
+	StatusUnavailable = "unavailable"
+)
+
+// checkTimeout bounds each dependency check so a hung dependency can't hang
+// the probe.
+const checkTimeout = 2 * time.Second
+
+// Check reports whether a dependency is usable.
+type Check func(ctx context.Context) error
+
+// Task is a startup step, such as building indexes, that must finish before
+// the service takes traffic.
+type Task struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Checker tracks the service lifecycle and serves the liveness and readiness
+// probes. It starts out not ready until WarmUp finishes.
+type Checker struct {
+	mu     sync.RWMutex
+	status string
+	checks map[string]Check
+}
+
+func New() *Checker {
+	return &Checker{status: StatusStarting, checks: map[string]Check{}}
+}
+
+// AddCheck registers a dependency that must be healthy for the service to be ready.
+func (c *Checker) AddCheck(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// MarkReady ends the startup phase.
+func (c *Checker) MarkReady() {
+	c.setStatus(StatusReady)
+}
+
+// MarkDraining makes the service report not ready while it shuts down, so
+// load balancers stop sending new requests.
+func (c *Checker) MarkDraining() {
+	c.setStatus(StatusDraining)
+}
+
+func (c *Checker) setStatus(status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status = status
+}
+
+// WarmUp runs the tasks in order, retrying each with backoff until it
+// succeeds, then marks the service ready. It gives up when ctx is cancelled.
+func (c *Checker) WarmUp(ctx context.Context, tasks ...Task) error {
+	for _, task := range tasks {
+		backoff := time.Second
+		for {
+			err := task.Run(ctx)
+			if err == nil {
+				break
+			}
+			slog.WarnContext(ctx, "warm-up task failed, retrying", "task", task.Name, "retry_in", backoff.String(), "error", err)
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+		}
+	}
+
+	c.MarkReady()
+	return nil
+}
+
+// Livez answers as long as the process can serve HTTP.
+func (c *Checker) Livez(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// Readyz runs every check and answers 503 unless the service has warmed up,
+// isn't draining and all dependencies are healthy.
+func (c *Checker) Readyz(ctx *gin.Context) {
+	c.mu.RLock()
+	status := c.status
+	checks := make(map[string]Check, len(c.checks))
+	names := make([]string, 0, len(c.checks))
+	for name, check := range c.checks {
+		checks[name] = check
+		names = append(names, name)
+	}
+	c.mu.RUnlock()
+	sort.Strings(names)
+
+	results := gin.H{}
+	for _, name := range names {
+		checkCtx, cancel := context.WithTimeout(ctx.Request.Context(), checkTimeout)
+		err := checks[name](checkCtx)
+		cancel()
+
+		if err != nil {
+			results[name] = err.Error()
+			if status == StatusReady {
+				status = StatusUnavailable
+			}
+			continue
+		}
+		results[name] = "ok"
+	}
+
+	code := http.StatusOK
+	if status != StatusReady {
+		code = http.StatusServiceUnavailable
+	}
+	ctx.JSON(code, gin.H{"status": status, "checks": results})
+}
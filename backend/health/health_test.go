@@ -0,0 +1,94 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func probe(t *testing.T, c *Checker) (int, string, map[string]string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	router.GET("/readyz", c.Readyz)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	var body struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, body.Status, body.Checks
+}
+
+func TestReadiness(t *testing.T) {
+	var dbErr error
+	c := New()
+	c.AddCheck("mongo", func(ctx context.Context) error { return dbErr })
+
+	if code, status, _ := probe(t, c); code != http.StatusServiceUnavailable || status != StatusStarting {
+		t.Fatalf("before warm-up: %d %s, want 503 starting", code, status)
+	}
+
+	if err := c.WarmUp(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if code, status, checks := probe(t, c); code != http.StatusOK || status != StatusReady || checks["mongo"] != "ok" {
+		t.Fatalf("after warm-up: %d %s %v, want 200 ready", code, status, checks)
+	}
+
+	dbErr = errors.New("connection refused")
+	if code, status, checks := probe(t, c); code != http.StatusServiceUnavailable || status != StatusUnavailable || checks["mongo"] != "connection refused" {
+		t.Fatalf("with mongo down: %d %s %v, want 503 unavailable", code, status, checks)
+	}
+
+	dbErr = nil
+	c.MarkDraining()
+	if code, status, _ := probe(t, c); code != http.StatusServiceUnavailable || status != StatusDraining {
+		t.Fatalf("draining: %d %s, want 503 draining", code, status)
+	}
+}
+
+func TestWarmUpRetries(t *testing.T) {
+	c := New()
+	attempts := 0
+	err := c.WarmUp(context.Background(), Task{
+		Name: "indexes",
+		Run: func(ctx context.Context) error {
+			attempts++
+			if attempts < 2 {
+				return errors.New("not yet")
+			}
+			return nil
+		},
+	})
+	if err != nil || attempts != 2 {
+		t.Fatalf("WarmUp = %v after %d attempts, want success after 2", err, attempts)
+	}
+	if code, _, _ := probe(t, c); code != http.StatusOK {
+		t.Fatalf("ready probe = %d, want 200", code)
+	}
+}
+
+func TestWarmUpStopsOnCancel(t *testing.T) {
+	c := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := c.WarmUp(ctx, Task{Name: "never", Run: func(ctx context.Context) error { return errors.New("down") }})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("WarmUp = %v, want context.Canceled", err)
+	}
+	if code, status, _ := probe(t, c); code != http.StatusServiceUnavailable || status != StatusStarting {
+		t.Fatalf("probe = %d %s, want 503 starting", code, status)
+	}
+}
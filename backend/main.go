@@ -1,83 +1,186 @@
 package main
 
 import (
-	"fmt"
+	"context"
+	"errors"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
+	"go.opentelemetry.io/contrib/instrumentation/go.mongodb.org/mongo-driver/mongo/otelmongo"
 
+	"ecomm-backend/apierror"
 	"ecomm-backend/config"
+	"ecomm-backend/controllers"
+	"ecomm-backend/health"
+	"ecomm-backend/logging"
+	"ecomm-backend/metrics"
+	"ecomm-backend/middleware"
+	"ecomm-backend/repository"
 	"ecomm-backend/routes"
+	"ecomm-backend/shipping"
+	"ecomm-backend/signing"
+	"ecomm-backend/tax"
+	"ecomm-backend/tracing"
 )
 
 func main() {
-	// Load environment variables
-	if err := godotenv.Load(); err != nil {
-		// .env file is optional
-		fmt.Println("No .env file found, using environment variables")
+	// Load configuration from defaults, CONFIG_FILE, .env and the environment
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.IsProduction() {
+		gin.SetMode(gin.ReleaseMode)
+	}
+
+	// Structured logs; the standard log package writes through it too
+	logger, err := logging.New(cfg.Log, os.Stdout)
+	if err != nil {
+		log.Fatal(err)
+	}
+	slog.SetDefault(logger)
+
+	// Tracing is set up before anything that starts spans
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, os.Stdout)
+	if err != nil {
+		fatal("failed to set up tracing", err)
 	}
 
+	// Metrics are created first so the database client can report to them
+	m := metrics.New()
+
 	// Connect to MongoDB
-	if err := config.ConnectDB(); err != nil {
-		log.Fatal("Failed to connect to database:", err)
+	if err := config.ConnectDB(cfg.Database, m.CommandMonitor(), otelmongo.NewMonitor()); err != nil {
+		fatal("failed to connect to database", err)
+	}
+
+	// Load tax rules
+	if err := tax.Setup(cfg.Tax); err != nil {
+		fatal("failed to load tax rules", err)
+	}
+
+	// Load shipping rates
+	if err := shipping.Setup(cfg.Shipping); err != nil {
+		fatal("failed to load shipping rates", err)
+	}
+
+	// Token signing keys, shared by every instance through the keys dir
+	keys, err := signing.Open(cfg.Auth.Signing)
+	if err != nil {
+		fatal("failed to load signing keys", err)
 	}
 
 	// Setup Gin router
-	router := gin.Default()
+	router := gin.New()
+
+	// Only the configured proxies may name the client, which the per-IP
+	// rate limits key on
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		fatal("invalid trusted proxies", err)
+	}
+
+	// The trace span wraps everything; request IDs come next so every log
+	// line and error body carries one
+	router.Use(middleware.Tracing(cfg.Tracing.ServiceName))
+	router.Use(middleware.RequestID())
+	router.Use(middleware.Logger(logger))
+	router.Use(middleware.Metrics(m))
+	router.Use(middleware.Recovery())
 
 	// CORS middleware
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "token"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization", "token", middleware.RequestIDHeader},
+		ExposeHeaders:    []string{"Content-Length", middleware.RequestIDHeader},
 		AllowCredentials: true,
 	}))
 
-	// Logging middleware
-	router.Use(func(c *gin.Context) {
-		fmt.Printf("%s %s\n", c.Request.Method, c.Request.URL.Path)
-		c.Next()
-	})
+	// Error handling middleware
+	router.Use(middleware.ErrorHandler())
 
-	// Health check
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status":  "OK",
-			"message": "Server is running",
-		})
-	})
+	// Health checks; /health is kept for older monitors and means ready
+	checker := health.New()
+	checker.AddCheck("mongo", config.Ping)
+	router.GET("/livez", checker.Livez)
+	router.GET("/readyz", checker.Readyz)
+	router.GET("/health", checker.Readyz)
+	router.GET("/metrics", gin.WrapH(m.Handler()))
 
 	// API Routes
-	routes.SetupRoutes(router)
-
-	// Error handling middleware
-	router.Use(func(c *gin.Context) {
-		c.Next()
-		if len(c.Errors) > 0 {
-			c.JSON(500, gin.H{
-				"error": c.Errors.Last().Error(),
-			})
-		}
-	})
+	handler := controllers.NewHandler(cfg,
+		repository.NewMongoUserRepository(config.UserCollection, cfg.Database.OperationTimeout),
+		repository.NewMongoProductRepository(config.ProductCollection, cfg.Database.OperationTimeout),
+		keys,
+		m,
+	)
+	routes.SetupRoutes(router, handler)
 
 	// 404 handler
 	router.NoRoute(func(c *gin.Context) {
-		c.JSON(404, gin.H{"error": "Route not found"})
+		c.Error(apierror.ErrRouteNotFound)
 	})
 
-	// Start server
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
 	}
 
-	fmt.Printf("Server starting on port %s\n", port)
-	if err := router.Run(":" + port); err != nil {
-		log.Fatal("Failed to start server:", err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Start server; it answers /livez right away and /readyz once warm
+	go func() {
+		slog.Info("server starting", "port", cfg.Port, "env", cfg.Env)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("failed to start server", err)
+		}
+	}()
+
+	go checker.WarmUp(ctx, health.Task{
+		Name: "indexes",
+		Run: func(ctx context.Context) error {
+			return repository.EnsureIndexes(ctx, config.UserCollection, config.ProductCollection)
+		},
+	})
+
+	// Rotate signing keys on schedule and drop retired ones
+	go keys.Maintain(ctx, time.Hour)
+
+	<-ctx.Done()
+	stop()
+
+	// Stop advertising readiness, then let in-flight requests finish
+	slog.Info("shutting down")
+	checker.MarkDraining()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("server did not shut down cleanly", "error", err)
+	}
+	if err := config.Disconnect(shutdownCtx); err != nil {
+		slog.Error("failed to disconnect from database", "error", err)
 	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("failed to flush traces", "error", err)
+	}
+	slog.Info("server stopped")
 }
 
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
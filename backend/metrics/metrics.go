@@ -0,0 +1,157 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.mongodb.org/mongo-driver/event"
+)
+
+const namespace = "ecomm"
+
+// Metrics holds the Prometheus collectors for HTTP traffic, MongoDB and
+// business events. Each instance has its own registry, so tests can create
+// as many as they like.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	httpRequests  *prometheus.CounterVec
+	httpDuration  *prometheus.HistogramVec
+	httpInFlight  prometheus.Gauge
+	mongoDuration *prometheus.HistogramVec
+
+	signups          prometheus.Counter
+	logins           *prometheus.CounterVec
+	cartAdditions    prometheus.Counter
+	checkouts        *prometheus.CounterVec
+	checkoutFailures *prometheus.CounterVec
+	orderValue       *prometheus.HistogramVec
+	paymentFailures  *prometheus.CounterVec
+}
+
+func New() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "http_requests_total",
+			Help: "HTTP requests by method, route template and status code.",
+		}, []string{"method", "route", "status"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "http_request_duration_seconds",
+			Help:    "HTTP request latency by method, route template and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		httpInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "http_requests_in_flight",
+			Help: "HTTP requests currently being served.",
+		}),
+		mongoDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "mongo_command_duration_seconds",
+			Help:    "MongoDB command latency by command name and outcome.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+		}, []string{"command", "outcome"}),
+
+		signups: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "signups_total",
+			Help: "Accounts created.",
+		}),
+		logins: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "logins_total",
+			Help: "Login attempts by outcome.",
+		}, []string{"outcome"}),
+		cartAdditions: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "cart_additions_total",
+			Help: "Products added to carts.",
+		}),
+		checkouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "checkouts_total",
+			Help: "Orders placed by payment method.",
+		}, []string{"payment_method"}),
+		checkoutFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "checkout_failures_total",
+			Help: "Checkouts that failed after validation, by reason.",
+		}, []string{"reason"}),
+		orderValue: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "order_value_inr",
+			Help:    "Order totals in rupees by payment method.",
+			Buckets: []float64{100, 250, 500, 1000, 2500, 5000, 10000, 25000, 50000, 100000},
+		}, []string{"payment_method"}),
+		paymentFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "payment_failures_total",
+			Help: "Payment gateway failures by stage (create_order, verify).",
+		}, []string{"stage"}),
+	}
+
+	m.Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.httpRequests, m.httpDuration, m.httpInFlight, m.mongoDuration,
+		m.signups, m.logins, m.cartAdditions, m.checkouts, m.checkoutFailures,
+		m.orderValue, m.paymentFailures,
+	)
+	return m
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// RequestStarted and RequestFinished bracket one HTTP request. route is the
+// route template, never the raw path, to keep label cardinality bounded.
+func (m *Metrics) RequestStarted() {
+	m.httpInFlight.Inc()
+}
+
+func (m *Metrics) RequestFinished(method, route string, status int, elapsed time.Duration) {
+	m.httpInFlight.Dec()
+	code := strconv.Itoa(status)
+	m.httpRequests.WithLabelValues(method, route, code).Inc()
+	m.httpDuration.WithLabelValues(method, route, code).Observe(elapsed.Seconds())
+}
+
+// CommandMonitor records the latency of every MongoDB command.
+func (m *Metrics) CommandMonitor() *event.CommandMonitor {
+	return &event.CommandMonitor{
+		Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+			m.mongoDuration.WithLabelValues(e.CommandName, "ok").Observe(e.Duration.Seconds())
+		},
+		Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+			m.mongoDuration.WithLabelValues(e.CommandName, "error").Observe(e.Duration.Seconds())
+		},
+	}
+}
+
+func (m *Metrics) Signup() {
+	m.signups.Inc()
+}
+
+// Login counts a login attempt; outcome is "success", "failure", "locked"
+// or "challenge" (a second factor is still owed).
+func (m *Metrics) Login(outcome string) {
+	m.logins.WithLabelValues(outcome).Inc()
+}
+
+func (m *Metrics) CartAddition() {
+	m.cartAdditions.Inc()
+}
+
+// Checkout counts a placed order and its value.
+func (m *Metrics) Checkout(paymentMethod string, total float64) {
+	m.checkouts.WithLabelValues(paymentMethod).Inc()
+	m.orderValue.WithLabelValues(paymentMethod).Observe(total)
+}
+
+func (m *Metrics) CheckoutFailed(reason string) {
+	m.checkoutFailures.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) PaymentFailed(stage string) {
+	m.paymentFailures.WithLabelValues(stage).Inc()
+}
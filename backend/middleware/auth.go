@@ -1,14 +1,31 @@
 package middleware
 
 import (
-	"net/http"
+	"errors"
+	"log/slog"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
+	"ecomm-backend/models"
+	"ecomm-backend/repository"
 	"ecomm-backend/utils"
 )
 
-func Authenticate() gin.HandlerFunc {
+// sessionTouchInterval is how stale a session's last seen time may get
+// before a request updates it, to save a write on every request.
+const sessionTouchInterval = time.Minute
+
+// Authenticate accepts a valid access token whose user still exists, whose
+// session is still active, and whose version matches the user's, so that
+// bumping the version (as a password reset does) revokes every token issued
+// before. Admins are also refused until they have two-factor
+// authentication. The request's auth.Principal is set for the handlers that
+// follow.
+func Authenticate(tokens *utils.TokenManager, users repository.UserRepository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		token := c.GetHeader("token")
 		if token == "" {
@@ -22,25 +39,86 @@ func Authenticate() gin.HandlerFunc {
 		}
 
 		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "No Authorization Header Provided"})
+			c.Error(apierror.Unauthorized(apierror.CodeTokenMissing, "No Authorization Header Provided"))
 			c.Abort()
 			return
 		}
 
-		userData, err := utils.ValidateToken(token)
+		claims, err := tokens.Validate(token)
 		if err != nil {
-			if err.Error() == "token is expired" {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "token is expired"})
+			if errors.Is(err, utils.ErrTokenExpired) {
+				c.Error(apierror.Unauthorized(apierror.CodeTokenExpired, "token is expired"))
 			} else {
-				c.JSON(http.StatusUnauthorized, gin.H{"error": "The Token is invalid"})
+				c.Error(apierror.Unauthorized(apierror.CodeTokenInvalid, "The Token is invalid"))
 			}
 			c.Abort()
 			return
 		}
 
-		// Set user data in context
-		c.Set("user", userData)
+		user, err := users.FindByUserID(c.Request.Context(), claims.Subject)
+		if errors.Is(err, repository.ErrNotFound) {
+			c.Error(apierror.Unauthorized(apierror.CodeTokenInvalid, "The Token is invalid"))
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.Error(apierror.Internal("Failed to authenticate").Wrap(err))
+			c.Abort()
+			return
+		}
+		now := time.Now()
+		session, ok := user.ActiveSession(claims.SessionID, now)
+		if user.TokenVersion != claims.Version || !ok {
+			c.Error(apierror.Unauthorized(apierror.CodeTokenRevoked, "The token has been revoked"))
+			c.Abort()
+			return
+		}
+		// Admin sessions need a second factor, including ones that predate it
+		if user.Role == models.RoleAdmin && (user.TwoFactor == nil || !user.TwoFactor.Enabled) {
+			c.Error(apierror.ErrTwoFactorRequired)
+			c.Abort()
+			return
+		}
+
+		if now.Sub(session.LastSeenAt) >= sessionTouchInterval {
+			// A missed update only makes the session look idle
+			if err := users.TouchSession(c.Request.Context(), user.UserID, session.ID, now); err != nil {
+				slog.WarnContext(c.Request.Context(), "failed to update session", "session_id", session.ID, "error", err)
+			}
+		}
+
+		role := user.Role
+		if role == "" {
+			role = models.RoleCustomer
+		}
+		auth.Set(c, &auth.Principal{
+			UserID:        user.UserID,
+			Email:         user.Email,
+			Roles:         []string{role},
+			TokenID:       claims.ID,
+			SessionID:     session.ID,
+			AuthMethod:    claims.AuthMethod,
+			EmailVerified: user.EmailVerified,
+		})
 		c.Next()
 	}
 }
 
+// RequireVerifiedEmail refuses users who haven't verified their email
+// address with 403 email_unverified. It runs after Authenticate.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := auth.Get(c)
+		if !ok {
+			c.Error(apierror.ErrUnauthenticated)
+			c.Abort()
+			return
+		}
+		if !principal.EmailVerified {
+			c.Error(apierror.ErrEmailUnverified)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/logging"
+)
+
+// ErrorHandler renders the last error a handler attached with c.Error as the
+// standard error envelope. Handlers report errors with
+//
+//	c.Error(apierror.ErrUserNotFound)
+//	return
+//
+// and must not write a response themselves. The Logger middleware records the
+// underlying cause; the body only carries the message, code and request ID.
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		apiErr := resolveError(c)
+		body := apiErr.Body()
+		body.RequestID = logging.RequestID(c.Request.Context())
+		c.JSON(apiErr.Status, body)
+	}
+}
+
+// resolveError converts the request's last error to an API error. Work cut
+// short because the client went away is reported as 499, and work that ran
+// out of time (a context deadline, a MongoDB or network timeout) as 504,
+// whatever error the handler wrapped it in.
+func resolveError(c *gin.Context) *apierror.Error {
+	err := c.Errors.Last().Err
+	switch {
+	case errors.Is(c.Request.Context().Err(), context.Canceled):
+		return apierror.ErrClientClosed.Wrap(err)
+	case errors.Is(err, context.DeadlineExceeded) || mongo.IsTimeout(err):
+		return apierror.ErrTimeout.Wrap(err)
+	}
+	return apierror.From(err)
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+)
+
+func TestContextErrors(t *testing.T) {
+	var logs bytes.Buffer
+	router := newRouter(t, &logs)
+	router.GET("/slow", func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), time.Millisecond)
+		defer cancel()
+		<-ctx.Done()
+		c.Error(apierror.Internal("Failed to load data").Wrap(ctx.Err()))
+	})
+
+	tests := []struct {
+		name   string
+		cancel bool
+		status int
+		code   apierror.Code
+		level  string
+	}{
+		{"deadline", false, http.StatusGatewayTimeout, apierror.CodeTimeout, "ERROR"},
+		{"client gone", true, apierror.StatusClientClosedRequest, apierror.CodeClientClosed, "WARN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.cancel {
+				cancel()
+			}
+			defer cancel()
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil).WithContext(ctx))
+
+			var body apierror.Body
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.status || body.Code != tt.code {
+				t.Fatalf("response = %d %s, want %d %s", rec.Code, body.Code, tt.status, tt.code)
+			}
+
+			var entry map[string]interface{}
+			if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+				t.Fatal(err)
+			}
+			if entry["error_code"] != string(tt.code) || entry["level"] != tt.level {
+				t.Errorf("log = %v, want %s at %s", entry, tt.code, tt.level)
+			}
+		})
+	}
+}
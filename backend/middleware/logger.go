@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
+	"ecomm-backend/logging"
+)
+
+// Logger writes one structured record per request. Server errors are logged
+// at error level with the underlying cause, which never reaches the client.
+func Logger(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", c.FullPath()),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+			slog.String("client_ip", c.ClientIP()),
+		}
+		if userID := currentUserID(c); userID != "" {
+			attrs = append(attrs, slog.String("user_id", userID))
+		}
+
+		level := slog.LevelInfo
+		if len(c.Errors) > 0 {
+			apiErr := resolveError(c)
+			attrs = append(attrs, slog.String("error_code", string(apiErr.Code)), slog.String("error", apiErr.Error()))
+		}
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		logger.LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// Recovery turns a panic into a 500 error envelope and records the panic for
+// the request log.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if r := recover(); r != nil {
+				if r == http.ErrAbortHandler {
+					panic(r)
+				}
+				apiErr := apierror.Internal("Internal server error").Wrap(fmt.Errorf("panic: %v", r))
+				c.Error(apiErr)
+
+				body := apiErr.Body()
+				body.RequestID = logging.RequestID(c.Request.Context())
+				c.AbortWithStatusJSON(apiErr.Status, body)
+			}
+		}()
+		c.Next()
+	}
+}
+
+// currentUserID returns the authenticated user's ID, if any.
+func currentUserID(c *gin.Context) string {
+	principal, ok := auth.Get(c)
+	if !ok {
+		return ""
+	}
+	return principal.UserID
+}
@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
+	"ecomm-backend/logging"
+)
+
+func newRouter(t *testing.T, logs *bytes.Buffer) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	logger, err := logging.New(logging.Config{Level: "info", Format: "json"}, logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := gin.New()
+	router.Use(RequestID(), Logger(logger), Recovery(), ErrorHandler())
+	router.GET("/orders/:id", func(c *gin.Context) {
+		auth.Set(c, &auth.Principal{UserID: "user-1"})
+		c.Error(apierror.Internal("Failed to load order").Wrap(errors.New("connection reset")))
+	})
+	router.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+	router.GET("/ok", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{})
+	})
+	return router
+}
+
+func TestRequestID(t *testing.T) {
+	router := newRouter(t, &bytes.Buffer{})
+
+	tests := []struct {
+		name     string
+		incoming string
+		reused   bool
+	}{
+		{"generated", "", false},
+		{"honoured", "req-123.abc", true},
+		{"unsafe replaced", "bad id\nwith newline", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/ok", nil)
+			if tt.incoming != "" {
+				req.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(RequestIDHeader)
+			if got == "" {
+				t.Fatal("response has no request ID")
+			}
+			if (got == tt.incoming) != tt.reused {
+				t.Fatalf("request ID = %q for incoming %q", got, tt.incoming)
+			}
+		})
+	}
+}
+
+func TestErrorsCarryRequestIDAndAreLogged(t *testing.T) {
+	var logs bytes.Buffer
+	router := newRouter(t, &logs)
+
+	req := httptest.NewRequest(http.MethodGet, "/orders/42", nil)
+	req.Header.Set(RequestIDHeader, "req-1")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	var body apierror.Body
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusInternalServerError || body.RequestID != "req-1" {
+		t.Fatalf("response = %d %+v, want 500 with request ID", rec.Code, body)
+	}
+	if bytes.Contains(rec.Body.Bytes(), []byte("connection reset")) {
+		t.Fatalf("response leaks the cause: %s", rec.Body.String())
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(logs.Bytes(), &entry); err != nil {
+		t.Fatalf("decode log %q: %v", logs.String(), err)
+	}
+	want := map[string]interface{}{
+		"level":      "ERROR",
+		"request_id": "req-1",
+		"route":      "/orders/:id",
+		"status":     float64(500),
+		"user_id":    "user-1",
+		"error":      "Failed to load order: connection reset",
+	}
+	for k, v := range want {
+		if entry[k] != v {
+			t.Errorf("log %s = %v, want %v", k, entry[k], v)
+		}
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	var logs bytes.Buffer
+	router := newRouter(t, &logs)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	var body apierror.Body
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusInternalServerError || body.Code != apierror.CodeInternal || body.RequestID == "" {
+		t.Fatalf("response = %d %+v, want 500 internal_error with request ID", rec.Code, body)
+	}
+	if !bytes.Contains(logs.Bytes(), []byte("panic: boom")) {
+		t.Fatalf("panic not logged: %s", logs.String())
+	}
+}
@@ -0,0 +1,25 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/metrics"
+)
+
+// Metrics records request counts and latency per route template. Requests
+// that match no route are grouped under "unmatched".
+func Metrics(m *metrics.Metrics) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		m.RequestStarted()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.RequestFinished(c.Request.Method, route, c.Writer.Status(), time.Since(start))
+	}
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/ratelimit"
+)
+
+// RateKey picks the bucket a request counts against; "" skips the limit.
+type RateKey func(c *gin.Context) string
+
+// ByIP counts requests per client address.
+func ByIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// maxAccountBody caps how much of a body ByAccount reads, before anything
+// has authenticated the request.
+const maxAccountBody = 64 << 10
+
+// ByAccount counts requests per account named in the JSON body, using the
+// first of fields that is present (for example "email", "phone"). The body
+// is decoded into a struct with those json tags, so keys match the way the
+// handler's binding matches them: case-insensitively, the last one winning.
+// The body is restored for the handler. Accounts that don't exist are
+// limited the same way, so the limit reveals nothing about which ones do.
+// Bodies over 64 KiB are refused with 413.
+func ByAccount(fields ...string) RateKey {
+	structFields := make([]reflect.StructField, len(fields))
+	for i, field := range fields {
+		structFields[i] = reflect.StructField{
+			Name: fmt.Sprintf("Field%d", i),
+			Type: reflect.TypeOf(""),
+			Tag:  reflect.StructTag(fmt.Sprintf(`json:%q`, field)),
+		}
+	}
+	bodyType := reflect.StructOf(structFields)
+
+	return func(c *gin.Context) string {
+		if c.Request.Body == nil {
+			return ""
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxAccountBody))
+		c.Request.Body = io.NopCloser(bytes.NewReader(data))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.Error(apierror.ErrRequestTooLarge)
+			c.Abort()
+			return ""
+		}
+		if err != nil {
+			return ""
+		}
+
+		body := reflect.New(bodyType)
+		if json.Unmarshal(data, body.Interface()) != nil {
+			return ""
+		}
+		for i, field := range fields {
+			if v := body.Elem().Field(i).String(); v != "" {
+				return field + ":" + strings.ToLower(strings.TrimSpace(v))
+			}
+		}
+		return ""
+	}
+}
+
+// RateLimit refuses requests beyond limit with 429 and a Retry-After header.
+// name separates the buckets of different routes. If the store fails the
+// request is let through, since a broken limiter must not take auth down.
+func RateLimit(store ratelimit.Store, name string, limit ratelimit.Limit, key RateKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !limit.Enabled() {
+			c.Next()
+			return
+		}
+		k := key(c)
+		if c.IsAborted() {
+			return
+		}
+		if k == "" {
+			c.Next()
+			return
+		}
+
+		decision, err := store.Take(c.Request.Context(), name+":"+k, limit)
+		if err != nil {
+			slog.WarnContext(c.Request.Context(), "rate limiter unavailable", "limit", name, "error", err)
+			c.Next()
+			return
+		}
+		if !decision.Allowed {
+			c.Header("Retry-After", ratelimit.RetryAfter(decision.RetryAfter))
+			c.Error(apierror.ErrRateLimited)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
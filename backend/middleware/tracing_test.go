@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"ecomm-backend/logging"
+)
+
+func TestTracing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := tracetest.NewSpanRecorder()
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+
+	var logs bytes.Buffer
+	logger, err := logging.New(logging.Config{Format: "json"}, &logs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handlerSpan trace.SpanContext
+	router := gin.New()
+	router.Use(Tracing("test"), RequestID(), Logger(logger))
+	router.GET("/orders/:id", func(c *gin.Context) {
+		handlerSpan = trace.SpanContextFromContext(c.Request.Context())
+		c.Status(http.StatusOK)
+	})
+	router.GET("/livez", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for _, path := range []string{"/orders/42", "/livez"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1 (probes are not traced)", len(spans))
+	}
+	if spans[0].Name() != "/orders/:id" {
+		t.Errorf("span name = %q, want the route template", spans[0].Name())
+	}
+	if handlerSpan.SpanID() != spans[0].SpanContext().SpanID() {
+		t.Error("handler context does not carry the request span")
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(bytes.SplitN(logs.Bytes(), []byte("\n"), 2)[0], &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["trace_id"] != spans[0].SpanContext().TraceID().String() {
+		t.Errorf("log trace_id = %v, want %s", entry["trace_id"], spans[0].SpanContext().TraceID())
+	}
+}
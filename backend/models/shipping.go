@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// ShippingOption is a priced delivery option for a cart; the chosen one is
+// stored on the order.
+type ShippingOption struct {
+	ID            string    `bson:"id" json:"id"`
+	Name          string    `bson:"name" json:"name"`
+	Zone          string    `bson:"zone" json:"zone"`
+	Cost          float64   `bson:"cost" json:"cost"`
+	Free          bool      `bson:"free" json:"free"`
+	EstimatedFrom time.Time `bson:"estimated_from" json:"estimated_from"`
+	EstimatedBy   time.Time `bson:"estimated_by" json:"estimated_by"`
+}
@@ -0,0 +1,25 @@
+package models
+
+// TaxComponent is a single named levy, e.g. CGST, SGST, IGST or VAT.
+type TaxComponent struct {
+	Name   string  `bson:"name" json:"name"`
+	Rate   float64 `bson:"rate" json:"rate"`
+	Amount float64 `bson:"amount" json:"amount"`
+}
+
+type TaxLine struct {
+	ProductID     string         `bson:"product_id" json:"product_id"`
+	TaxClass      string         `bson:"tax_class" json:"tax_class"`
+	TaxableAmount float64        `bson:"taxable_amount" json:"taxable_amount"`
+	Components    []TaxComponent `bson:"components" json:"components"`
+	TotalTax      float64        `bson:"total_tax" json:"total_tax"`
+}
+
+type TaxBreakdown struct {
+	Jurisdiction string         `bson:"jurisdiction" json:"jurisdiction"`
+	Origin       string         `bson:"origin,omitempty" json:"origin,omitempty"`
+	Destination  string         `bson:"destination,omitempty" json:"destination,omitempty"`
+	Lines        []TaxLine      `bson:"lines" json:"lines"`
+	Components   []TaxComponent `bson:"components" json:"components"`
+	TotalTax     float64        `bson:"total_tax" json:"total_tax"`
+}
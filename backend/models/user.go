@@ -12,50 +12,163 @@ type Address struct {
 	StreetName string             `bson:"street_name" json:"street_name"`
 	CityName   string             `bson:"city_name" json:"city_name"`
 	PinCode    string             `bson:"pin_code" json:"pin_code"`
+	State      string             `bson:"state,omitempty" json:"state,omitempty"`
+	Country    string             `bson:"country,omitempty" json:"country,omitempty"`
 }
 
 type ProductUser struct {
-	ID         primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
-	ProductID  string             `bson:"product_id" json:"product_id"`
-	ProductName string            `bson:"product_name" json:"product_name"`
-	Price      float64            `bson:"price" json:"price"`
-	Rating     *float64           `bson:"rating,omitempty" json:"rating,omitempty"`
-	Image      string             `bson:"image,omitempty" json:"image,omitempty"`
-	Quantity   int                `bson:"quantity" json:"quantity"`
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	ProductID   string             `bson:"product_id" json:"product_id"`
+	ProductName string             `bson:"product_name" json:"product_name"`
+	Price       float64            `bson:"price" json:"price"`
+	Rating      *float64           `bson:"rating,omitempty" json:"rating,omitempty"`
+	Image       string             `bson:"image,omitempty" json:"image,omitempty"`
+	TaxClass    string             `bson:"tax_class,omitempty" json:"tax_class,omitempty"`
+	Weight      int                `bson:"weight,omitempty" json:"weight,omitempty"`
+	Quantity    int                `bson:"quantity" json:"quantity"`
 }
 
+const (
+	PaymentMethodCOD     = "cod"
+	PaymentMethodDigital = "digital"
+)
+
+const (
+	OrderStatusPendingPayment = "pending_payment"
+	OrderStatusConfirmed      = "confirmed"
+	OrderStatusPaid           = "paid"
+)
+
 type Payment struct {
 	Digital bool `bson:"digital" json:"digital"`
 	COD     bool `bson:"cod" json:"cod"`
 }
 
 type Order struct {
-	ID              primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
-	OrderList       []ProductUser      `bson:"order_list" json:"order_list"`
-	OrderedOn       time.Time          `bson:"ordered_on" json:"ordered_on"`
-	TotalPrice      float64            `bson:"total_price" json:"total_price"`
-	Discount        *float64           `bson:"discount,omitempty" json:"discount,omitempty"`
-	PaymentMethod   Payment            `bson:"payment_method" json:"payment_method"`
-	RazorpayOrderID string             `bson:"razorpay_order_id,omitempty" json:"razorpay_order_id,omitempty"`
-	RazorpayPaymentID string            `bson:"razorpay_payment_id,omitempty" json:"razorpay_payment_id,omitempty"`
-	Status          string             `bson:"status,omitempty" json:"status,omitempty"`
-	DeliveryAddress *Address           `bson:"delivery_address,omitempty" json:"delivery_address,omitempty"`
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	OrderList         []ProductUser      `bson:"order_list" json:"order_list"`
+	OrderedOn         time.Time          `bson:"ordered_on" json:"ordered_on"`
+	Subtotal          float64            `bson:"subtotal,omitempty" json:"subtotal,omitempty"`
+	Tax               *TaxBreakdown      `bson:"tax,omitempty" json:"tax,omitempty"`
+	Shipping          *ShippingOption    `bson:"shipping,omitempty" json:"shipping,omitempty"`
+	TotalPrice        float64            `bson:"total_price" json:"total_price"`
+	Discount          *float64           `bson:"discount,omitempty" json:"discount,omitempty"`
+	PaymentMethod     Payment            `bson:"payment_method" json:"payment_method"`
+	RazorpayOrderID   string             `bson:"razorpay_order_id,omitempty" json:"razorpay_order_id,omitempty"`
+	RazorpayPaymentID string             `bson:"razorpay_payment_id,omitempty" json:"razorpay_payment_id,omitempty"`
+	Status            string             `bson:"status,omitempty" json:"status,omitempty"`
+	DeliveryAddress   *Address           `bson:"delivery_address,omitempty" json:"delivery_address,omitempty"`
+}
+
+// PasswordReset is an outstanding reset request. Only the hash of the token
+// mailed to the user is stored.
+type PasswordReset struct {
+	TokenHash string    `bson:"token_hash"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+// EmailVerification is an outstanding verification link. Only the hash of
+// the token is stored; SentAt throttles resends.
+type EmailVerification struct {
+	TokenHash string    `bson:"token_hash"`
+	ExpiresAt time.Time `bson:"expires_at"`
+	SentAt    time.Time `bson:"sent_at"`
+}
+
+// LoginOTP is an outstanding one-time login code sent by SMS. Attempts
+// counts the guesses made against it.
+type LoginOTP struct {
+	CodeHash  string    `bson:"code_hash"`
+	ExpiresAt time.Time `bson:"expires_at"`
+	SentAt    time.Time `bson:"sent_at"`
+	Attempts  int       `bson:"attempts"`
+}
+
+// Roles. Users are customers unless their role is set in the database.
+const (
+	RoleCustomer = "customer"
+	RoleAdmin    = "admin"
+)
+
+// TwoFactor holds a user's TOTP second factor. It is pending until the
+// first code is confirmed. LastStep is the time step of the last accepted
+// code, so no code works twice; RecoveryCodes are hashes of the unused
+// recovery codes.
+type TwoFactor struct {
+	Secret        string   `bson:"secret"`
+	Enabled       bool     `bson:"enabled"`
+	LastStep      int64    `bson:"last_step,omitempty"`
+	RecoveryCodes []string `bson:"recovery_codes,omitempty"`
+}
+
+// Session is one login, on one device. Its tokens name it and are refused
+// once it is revoked, which removes it, or has expired.
+type Session struct {
+	ID         string    `bson:"session_id" json:"id"`
+	UserAgent  string    `bson:"user_agent" json:"user_agent"`
+	IP         string    `bson:"ip" json:"ip"`
+	AuthMethod string    `bson:"auth_method,omitempty" json:"auth_method,omitempty"`
+	CreatedAt  time.Time `bson:"created_at" json:"created_at"`
+	LastSeenAt time.Time `bson:"last_seen_at" json:"last_seen_at"`
+	ExpiresAt  time.Time `bson:"expires_at" json:"expires_at"`
+	// RefreshID is the ID of the session's latest refresh token; older ones
+	// are refused.
+	RefreshID string `bson:"refresh_id,omitempty" json:"-"`
+}
+
+// Identity links a user to their account at an OpenID Connect provider,
+// which can then log them in. Subject is the provider's ID for the account;
+// Email is the address it had when linked.
+type Identity struct {
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"-"`
+	Email    string    `bson:"email,omitempty" json:"email,omitempty"`
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
 }
 
 type User struct {
-	ID          primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
-	FirstName   string             `bson:"first_name" json:"first_name"`
-	LastName    string             `bson:"last_name" json:"last_name"`
-	Password    string             `bson:"password" json:"-"`
-	Email       string             `bson:"email" json:"email"`
-	Phone       string             `bson:"phone" json:"phone"`
-	Token       string             `bson:"token,omitempty" json:"token,omitempty"`
-	RefreshToken string            `bson:"refresh_token,omitempty" json:"refresh_token,omitempty"`
-	UserID      string             `bson:"user_id" json:"user_id"`
-	UserCart    []ProductUser      `bson:"usercart" json:"usercart"`
-	Address     []Address          `bson:"address" json:"address"`
-	Orders      []Order            `bson:"orders" json:"orders"`
-	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
+	ID                primitive.ObjectID `bson:"_id,omitempty" json:"_id,omitempty"`
+	FirstName         string             `bson:"first_name" json:"first_name"`
+	LastName          string             `bson:"last_name" json:"last_name"`
+	Password          string             `bson:"password" json:"-"`
+	Email             string             `bson:"email" json:"email"`
+	Phone             string             `bson:"phone" json:"phone"`
+	PhoneVerified     bool               `bson:"phone_verified" json:"phone_verified"`
+	LoginOTP          *LoginOTP          `bson:"login_otp,omitempty" json:"-"`
+	Role              string             `bson:"role,omitempty" json:"role,omitempty"`
+	TwoFactor         *TwoFactor         `bson:"two_factor,omitempty" json:"-"`
+	EmailVerified     bool               `bson:"email_verified" json:"email_verified"`
+	EmailVerification *EmailVerification `bson:"email_verification,omitempty" json:"-"`
+	Token             string             `bson:"token,omitempty" json:"token,omitempty"`
+	RefreshToken      string             `bson:"refresh_token,omitempty" json:"refresh_token,omitempty"`
+	UserID            string             `bson:"user_id" json:"user_id"`
+	// FailedLogins counts consecutive wrong passwords; enough of them set
+	// LockedUntil.
+	FailedLogins int        `bson:"failed_logins,omitempty" json:"-"`
+	LockedUntil  *time.Time `bson:"locked_until,omitempty" json:"-"`
+	// TokenVersion is embedded in issued tokens; bumping it revokes them all.
+	TokenVersion  int            `bson:"token_version,omitempty" json:"-"`
+	PasswordReset *PasswordReset `bson:"password_reset,omitempty" json:"-"`
+	// Sessions are the user's logins, oldest first.
+	Sessions   []Session     `bson:"sessions,omitempty" json:"-"`
+	Identities []Identity    `bson:"identities,omitempty" json:"identities,omitempty"`
+	UserCart   []ProductUser `bson:"usercart" json:"usercart"`
+	Address    []Address     `bson:"address" json:"address"`
+	Orders     []Order       `bson:"orders" json:"orders"`
+	CreatedAt  time.Time     `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time     `bson:"updatedAt" json:"updatedAt"`
 }
 
+// ActiveSession returns the session with ID id if it exists and has not
+// expired at now.
+func (u *User) ActiveSession(id string, now time.Time) (*Session, bool) {
+	for i := range u.Sessions {
+		if u.Sessions[i].ID == id {
+			if !now.Before(u.Sessions[i].ExpiresAt) {
+				return nil, false
+			}
+			return &u.Sessions[i], true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SinkLog     = "log"
+	SinkFile    = "file"
+	SinkWebhook = "webhook"
+)
+
+// Message is a notification for one user, such as a password reset email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier delivers messages to users. The log and file sinks are for local
+// development, as messages carry reset links and login codes; production
+// posts them to a provider relay through the webhook sink.
+type Notifier interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config selects the sink: "log" writes messages to the application log,
+// "file" appends them to File and "webhook" posts them to URL.
+type Config struct {
+	Sink         string `yaml:"sink"`
+	File         string `yaml:"file"`
+	URL          string `yaml:"url"`
+	WebhookToken string `yaml:"webhook_token"`
+}
+
+// Validate reports an unknown sink, a file sink without a path or a
+// webhook sink without an absolute URL.
+func (cfg Config) Validate() error {
+	switch strings.ToLower(cfg.Sink) {
+	case "", SinkLog:
+	case SinkFile:
+		if cfg.File == "" {
+			return errors.New("notify file sink needs a file path")
+		}
+	case SinkWebhook:
+		if u, err := url.Parse(cfg.URL); err != nil || !u.IsAbs() {
+			return fmt.Errorf("notify webhook sink needs an absolute URL; got %q", cfg.URL)
+		}
+	default:
+		return fmt.Errorf("notify sink must be log, file or webhook; got %q", cfg.Sink)
+	}
+	return nil
+}
+
+// Local reports whether cfg keeps messages on this machine, in the log or a
+// file, rather than delivering them.
+func (cfg Config) Local() bool {
+	return strings.ToLower(cfg.Sink) != SinkWebhook
+}
+
+// New returns the notifier cfg selects.
+func New(cfg Config) Notifier {
+	switch strings.ToLower(cfg.Sink) {
+	case SinkFile:
+		return NewFile(cfg.File)
+	case SinkWebhook:
+		return NewWebhook(cfg.URL, cfg.WebhookToken)
+	}
+	return Log{}
+}
+
+// Log writes each message to the default logger.
+type Log struct{}
+
+func (Log) Send(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "notification", "to", msg.To, "subject", msg.Subject, "body", msg.Body)
+	return nil
+}
+
+// File appends each message to a file, like a local outbox.
+type File struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+func (f *File) Send(_ context.Context, msg Message) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	out, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open outbox: %w", err)
+	}
+	defer out.Close()
+
+	_, err = fmt.Fprintf(out, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), msg.To, msg.Subject, msg.Body)
+	return err
+}
+
+// Memory keeps messages in memory so tests can read them back.
+type Memory struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *Memory) Send(_ context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// Messages returns the messages sent to to, oldest first.
+func (m *Memory) Messages(to string) []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []Message
+	for _, msg := range m.messages {
+		if msg.To == to {
+			out = append(out, msg)
+		}
+	}
+	return out
+}
@@ -0,0 +1,41 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	var got map[string]string
+	var auth string
+	status := http.StatusAccepted
+	relay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(status)
+	}))
+	defer relay.Close()
+
+	notifier := New(Config{Sink: SinkWebhook, URL: relay.URL, WebhookToken: "relay-token"})
+	msg := Message{To: "asha@example.com", Subject: "Reset your password", Body: "https://shop.example/reset?token=abc"}
+	if err := notifier.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if got["to"] != msg.To || got["subject"] != msg.Subject || got["body"] != msg.Body {
+		t.Fatalf("relay got %v, want %+v", got, msg)
+	}
+	if auth != "Bearer relay-token" {
+		t.Fatalf("Authorization = %q, want the bearer token", auth)
+	}
+
+	// The relay refusing the message is a failed delivery
+	status = http.StatusBadGateway
+	if err := notifier.Send(context.Background(), msg); err == nil {
+		t.Fatal("send succeeded although the relay refused it")
+	}
+}
@@ -0,0 +1,78 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// GitHubURL is where GitHub.com users log in.
+const GitHubURL = "https://github.com"
+
+// GitHubScopes let the access token read the profile and email addresses.
+var GitHubScopes = []string{"read:user", "user:email"}
+
+func (p *Provider) github() bool {
+	return p.cfg.Type == TypeGitHub
+}
+
+// githubEndpoints returns the OAuth endpoints under the GitHub web URL.
+func (p *Provider) githubEndpoints() *metadata {
+	base := strings.TrimSuffix(p.cfg.Issuer, "/")
+	return &metadata{
+		Issuer:                p.cfg.Issuer,
+		AuthorizationEndpoint: base + "/login/oauth/authorize",
+		TokenEndpoint:         base + "/login/oauth/access_token",
+	}
+}
+
+// githubAPI returns the REST API root: api.github.com for GitHub.com, and
+// /api/v3 under the web URL for GitHub Enterprise Server.
+func (p *Provider) githubAPI() string {
+	base := strings.TrimSuffix(p.cfg.Issuer, "/")
+	if u, err := url.Parse(base); err == nil && u.Host == "github.com" {
+		return "https://api.github.com"
+	}
+	return base + "/api/v3"
+}
+
+// githubClaims reads the account accessToken belongs to. Its email is the
+// primary address, and only if GitHub has verified it; the login is
+// refused without one, as it would be for an OpenID Connect provider.
+func (p *Provider) githubClaims(ctx context.Context, accessToken string) (*Claims, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := p.getJSON(ctx, p.githubAPI()+"/user", accessToken, &user); err != nil {
+		return nil, fmt.Errorf("oidc: github user: %w", err)
+	}
+	if user.ID == 0 {
+		return nil, fmt.Errorf("%w: github user has no id", ErrInvalidToken)
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.getJSON(ctx, p.githubAPI()+"/user/emails", accessToken, &emails); err != nil {
+		return nil, fmt.Errorf("oidc: github emails: %w", err)
+	}
+
+	claims := &Claims{Name: user.Name}
+	if claims.Name == "" {
+		claims.Name = user.Login
+	}
+	claims.Subject = strconv.FormatInt(user.ID, 10)
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			claims.Email = e.Email
+			claims.EmailVerified = true
+		}
+	}
+	return claims, nil
+}
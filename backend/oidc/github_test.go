@@ -0,0 +1,101 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// mockGitHub answers GitHub's token, user and emails endpoints for one
+// authorization code.
+func mockGitHub(t *testing.T, emails []map[string]interface{}) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login/oauth/access_token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostFormValue("client_id") != "id" || r.PostFormValue("client_secret") != "secret" ||
+			r.PostFormValue("code") != "good-code" || r.PostFormValue("code_verifier") != "verifier" {
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "gho_token", "token_type": "bearer"})
+	})
+	authorized := func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer gho_token" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+		}
+	}
+	mux.HandleFunc("/api/v3/user", authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": 583231, "login": "octocat", "name": "The Octocat"})
+	}))
+	mux.HandleFunc("/api/v3/user/emails", authorized(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(emails)
+	}))
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func newGitHub(srv *httptest.Server) *Provider {
+	return NewProvider(Config{Name: "github", Type: TypeGitHub, Issuer: srv.URL, ClientID: "id", ClientSecret: "secret", RedirectURL: "https://shop.example/callback"})
+}
+
+func TestGitHubAuthCodeURL(t *testing.T) {
+	p := NewProvider(Config{Name: "github", Type: TypeGitHub, ClientID: "id", ClientSecret: "secret", RedirectURL: "https://shop.example/callback"})
+	authURL, err := p.AuthCodeURL(context.Background(), &LoginState{State: "s", Nonce: "n", Verifier: "verifier"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	if u.Host != "github.com" || u.Path != "/login/oauth/authorize" || q.Get("scope") != "read:user user:email" ||
+		q.Get("state") != "s" || q.Get("code_challenge") != CodeChallenge("verifier") || q.Has("nonce") {
+		t.Fatalf("authorization URL = %s", authURL)
+	}
+	if api := p.githubAPI(); api != "https://api.github.com" {
+		t.Fatalf("API = %s, want https://api.github.com", api)
+	}
+}
+
+func TestGitHubExchange(t *testing.T) {
+	state := &LoginState{State: "s", Verifier: "verifier"}
+
+	p := newGitHub(mockGitHub(t, []map[string]interface{}{
+		{"email": "octocat@users.noreply.github.com", "primary": false, "verified": true},
+		{"email": "octocat@github.com", "primary": true, "verified": true},
+	}))
+	claims, err := p.Exchange(context.Background(), "good-code", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "583231" || claims.Email != "octocat@github.com" || !claims.EmailVerified || claims.Name != "The Octocat" {
+		t.Fatalf("claims = %+v", claims)
+	}
+
+	// A refused code
+	if _, err := p.Exchange(context.Background(), "bad-code", state); !errors.Is(err, ErrExchange) {
+		t.Fatalf("got %v, want ErrExchange", err)
+	}
+
+	// Only a verified primary address is trusted
+	p = newGitHub(mockGitHub(t, []map[string]interface{}{
+		{"email": "octocat@github.com", "primary": true, "verified": false},
+		{"email": "octocat@example.com", "primary": false, "verified": true},
+	}))
+	claims, err = p.Exchange(context.Background(), "good-code", state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Email != "" || claims.EmailVerified {
+		t.Fatalf("claims = %+v, want no email", claims)
+	}
+}
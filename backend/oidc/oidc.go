@@ -0,0 +1,568 @@
+// Package oidc logs users in through external OpenID Connect providers,
+// such as Google, with the authorization code flow and PKCE. A provider's
+// endpoints and signing keys come from its discovery document, so adding
+// one only takes its issuer URL and client credentials. GitHub, which has
+// no discovery document and issues no ID tokens, has its own adapter that
+// reads the account from its REST API instead.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+var (
+	// ErrExchange means the provider refused the authorization code.
+	ErrExchange = errors.New("oidc: code exchange refused")
+	// ErrInvalidToken means the ID token failed verification.
+	ErrInvalidToken = errors.New("oidc: invalid id token")
+)
+
+// DefaultScopes are requested when a provider configures none.
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// Provider types.
+const (
+	TypeOIDC   = "oidc"
+	TypeGitHub = "github"
+)
+
+const (
+	// clockSkew is how far the provider's clock may be off from ours.
+	clockSkew = time.Minute
+	// keysRefreshInterval is how often an ID token signed with an unknown
+	// key may make a provider's keys be fetched again.
+	keysRefreshInterval = time.Minute
+)
+
+// signingMethods are the ID token algorithms accepted.
+var signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+var namePattern = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// Config describes one provider. Name appears in the login URLs. Type is
+// TypeOIDC (the default) or TypeGitHub, whose Issuer is the GitHub web URL,
+// https://github.com unless it is a GitHub Enterprise Server.
+// RedirectURL must be registered with the provider and lead to
+// GET /api/auth/oidc/<name>/callback, directly or through a frontend page
+// that passes its query on. Scopes defaults to DefaultScopes, or
+// GitHubScopes for GitHub.
+type Config struct {
+	Name         string   `yaml:"name"`
+	Type         string   `yaml:"type"`
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	RedirectURL  string   `yaml:"redirect_url"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+// Validate reports a malformed name, an unknown type, an issuer that is
+// not an https URL (plain http is allowed on loopback addresses, for local
+// mock servers), a missing client ID, a relative redirect URL, or scopes
+// without openid. GitHub may leave the issuer out but needs a client
+// secret.
+func (cfg Config) Validate() error {
+	var errs []error
+	if !namePattern.MatchString(cfg.Name) {
+		errs = append(errs, fmt.Errorf("oidc provider name must be lower-case letters, digits, - or _; got %q", cfg.Name))
+	}
+	github := cfg.Type == TypeGitHub
+	if cfg.Type != "" && cfg.Type != TypeOIDC && !github {
+		errs = append(errs, fmt.Errorf("oidc provider %s: type must be oidc or github; got %q", cfg.Name, cfg.Type))
+	}
+	if u, err := url.Parse(cfg.Issuer); !(github && cfg.Issuer == "") &&
+		(err != nil || !u.IsAbs() || !(u.Scheme == "https" || u.Scheme == "http" && isLoopback(u.Hostname()))) {
+		errs = append(errs, fmt.Errorf("oidc provider %s: issuer must be an https URL; got %q", cfg.Name, cfg.Issuer))
+	}
+	if cfg.ClientID == "" {
+		errs = append(errs, fmt.Errorf("oidc provider %s: client id is required", cfg.Name))
+	}
+	if github && cfg.ClientSecret == "" {
+		errs = append(errs, fmt.Errorf("oidc provider %s: github needs a client secret", cfg.Name))
+	}
+	if u, err := url.Parse(cfg.RedirectURL); err != nil || !u.IsAbs() {
+		errs = append(errs, fmt.Errorf("oidc provider %s: redirect url must be an absolute URL; got %q", cfg.Name, cfg.RedirectURL))
+	}
+	if !github && len(cfg.Scopes) > 0 && !slices.Contains(cfg.Scopes, "openid") {
+		errs = append(errs, fmt.Errorf("oidc provider %s: scopes must include openid", cfg.Name))
+	}
+	return errors.Join(errs...)
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Claims are the ID token claims used to find or create the user. For
+// GitHub they are filled in from the account instead.
+type Claims struct {
+	Email         string `json:"email"`
+	EmailVerified Bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Nonce         string `json:"nonce"`
+	// AuthorizedParty is the client the token was issued to.
+	AuthorizedParty string `json:"azp"`
+	jwt.RegisteredClaims
+}
+
+// Bool is a JSON boolean that also accepts "true" and "false" strings,
+// which some providers send for email_verified.
+type Bool bool
+
+func (b *Bool) UnmarshalJSON(data []byte) error {
+	switch string(data) {
+	case "true", `"true"`:
+		*b = true
+	case "false", `"false"`, "null":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+	return nil
+}
+
+// metadata is the part of the discovery document the login flow uses.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider runs logins against one provider. Its discovery document is
+// fetched on first use and its keys whenever a token names an unknown one.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu          sync.Mutex
+	metadata    *metadata
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+}
+
+func NewProvider(cfg Config) *Provider {
+	if cfg.Type == TypeGitHub {
+		if cfg.Issuer == "" {
+			cfg.Issuer = GitHubURL
+		}
+		if len(cfg.Scopes) == 0 {
+			cfg.Scopes = GitHubScopes
+		}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = DefaultScopes
+	}
+	return &Provider{
+		cfg: cfg,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+	}
+}
+
+// NewProviders returns a provider for each of cfgs, by name.
+func NewProviders(cfgs []Config) map[string]*Provider {
+	providers := make(map[string]*Provider, len(cfgs))
+	for _, cfg := range cfgs {
+		providers[cfg.Name] = NewProvider(cfg)
+	}
+	return providers
+}
+
+// Name returns the name the provider is configured under.
+func (p *Provider) Name() string {
+	return p.cfg.Name
+}
+
+// RedirectURL returns where the provider sends users back to.
+func (p *Provider) RedirectURL() string {
+	return p.cfg.RedirectURL
+}
+
+// AuthCodeURL returns the provider URL that starts a login with state.
+func (p *Provider) AuthCodeURL(ctx context.Context, state *LoginState) (string, error) {
+	md, err := p.endpoints(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(md.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("oidc: invalid authorization endpoint: %w", err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", strings.Join(p.cfg.Scopes, " "))
+	q.Set("state", state.State)
+	if !p.github() {
+		q.Set("nonce", state.Nonce)
+	}
+	q.Set("code_challenge", CodeChallenge(state.Verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange trades the authorization code from the login started with
+// state for an ID token, and returns its claims once it is verified. For
+// GitHub it trades the code for an access token and returns the claims of
+// the account that token reads.
+func (p *Provider) Exchange(ctx context.Context, code string, state *LoginState) (*Claims, error) {
+	md, err := p.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"code_verifier": {state.Verifier},
+	}
+	if p.cfg.ClientSecret == "" || p.github() {
+		form.Set("client_id", p.cfg.ClientID)
+	}
+	// GitHub only takes the secret in the form
+	if p.github() {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, md.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" && !p.github() {
+		// client_secret_basic form-encodes both parts (RFC 6749 section 2.3.1)
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		AccessToken      string `json:"access_token"`
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return nil, fmt.Errorf("oidc: token response with status %d: %w", resp.StatusCode, err)
+	}
+	// GitHub refuses codes with 200 and an error
+	if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized ||
+		resp.StatusCode == http.StatusOK && body.Error != "" {
+		return nil, fmt.Errorf("%w: %s %s", ErrExchange, body.Error, body.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("oidc: token request failed with status %d", resp.StatusCode)
+	}
+	if p.github() {
+		if body.AccessToken == "" {
+			return nil, fmt.Errorf("%w: no access token in the response", ErrExchange)
+		}
+		return p.githubClaims(ctx, body.AccessToken)
+	}
+	if body.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id token in the response", ErrInvalidToken)
+	}
+	return p.Verify(ctx, body.IDToken, state.Nonce)
+}
+
+// Verify checks an ID token's signature against the provider's keys, its
+// issuer, audience, lifetime and nonce, and returns its claims.
+func (p *Provider) Verify(ctx context.Context, idToken, nonce string) (*Claims, error) {
+	var claims Claims
+	_, err := jwt.ParseWithClaims(idToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := p.key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		if !keyFits(token.Method, key) {
+			return nil, fmt.Errorf("key %q does not fit algorithm %s", kid, token.Method.Alg())
+		}
+		return key, nil
+	},
+		jwt.WithValidMethods(signingMethods),
+		jwt.WithIssuer(p.cfg.Issuer),
+		jwt.WithAudience(p.cfg.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+	if subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+	// A token for several audiences must say it was issued to us
+	if (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != p.cfg.ClientID {
+		return nil, fmt.Errorf("%w: issued to %q", ErrInvalidToken, claims.AuthorizedParty)
+	}
+	return &claims, nil
+}
+
+// endpoints returns where to send users to log in and where to exchange
+// codes: GitHub's fixed endpoints, or those in the discovery document.
+func (p *Provider) endpoints(ctx context.Context) (*metadata, error) {
+	if p.github() {
+		return p.githubEndpoints(), nil
+	}
+	return p.discover(ctx)
+}
+
+// discover returns the provider's discovery document, fetching it once.
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	cached := p.metadata
+	p.mu.Unlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	// Fetched without the lock, so a slow provider doesn't hold up logins
+	// that already have what they need; concurrent first logins may each
+	// fetch it
+	var md metadata
+	if err := p.getJSON(ctx, strings.TrimSuffix(p.cfg.Issuer, "/")+"/.well-known/openid-configuration", "", &md); err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	// The document is only trusted for the issuer it was fetched from
+	// (OpenID Connect Discovery section 4.3)
+	if md.Issuer != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery: issuer %q does not match %q", md.Issuer, p.cfg.Issuer)
+	}
+	if md.AuthorizationEndpoint == "" || md.TokenEndpoint == "" || md.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery: missing endpoints")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.metadata == nil {
+		p.metadata = &md
+	}
+	return p.metadata, nil
+}
+
+// key returns the provider key with ID kid, refetching the provider's keys
+// when it is unknown, as happens after the provider rotates them. A token
+// without a kid may use the only key there is.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	md, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Claim the refetch under the lock, so only one request at a time
+	// fetches the keys, but fetch them without it
+	p.mu.Lock()
+	if key, ok := p.findKey(kid); ok {
+		p.mu.Unlock()
+		return key, nil
+	}
+	lastFetched := p.keysFetched
+	if time.Since(lastFetched) < keysRefreshInterval {
+		p.mu.Unlock()
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	p.keysFetched = time.Now()
+	p.mu.Unlock()
+
+	keys, err := p.fetchKeys(ctx, md.JWKSURI)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		// Let the next request try again
+		p.keysFetched = lastFetched
+		return nil, err
+	}
+	p.keys = keys
+	if key, ok := p.findKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// fetchKeys fetches the provider's signing keys from its JWKS document.
+func (p *Provider) fetchKeys(ctx context.Context, url string) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, url, "", &set); err != nil {
+		return nil, fmt.Errorf("fetch keys: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		// Keys of types we can't use are skipped rather than failing the set
+		if key, err := k.publicKey(); err == nil {
+			keys[k.ID] = key
+		}
+	}
+	return keys, nil
+}
+
+// findKey looks kid up among the fetched keys. Callers hold mu.
+func (p *Provider) findKey(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// getJSON fetches url into v, sending accessToken, if any, as a bearer
+// token.
+func (p *Provider) getJSON(ctx context.Context, url, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// keyFits reports whether key is the kind method verifies with, so an RSA
+// key can't be used to check, say, an HMAC signature.
+func keyFits(method jwt.SigningMethod, key crypto.PublicKey) bool {
+	switch method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		_, ok := key.(*ecdsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodEd25519:
+		_, ok := key.(ed25519.PublicKey)
+		return ok
+	}
+	return false
+}
+
+// jwk is a provider public key in JSON Web Key form (RFC 7517).
+type jwk struct {
+	KeyType string `json:"kty"`
+	ID      string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid rsa exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Curve {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("ec point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Curve)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.KeyType)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, errors.New("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
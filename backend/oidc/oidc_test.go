@@ -0,0 +1,159 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCodeChallenge(t *testing.T) {
+	// RFC 7636 appendix B
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"; got != want {
+		t.Fatalf("CodeChallenge = %q, want %q", got, want)
+	}
+}
+
+func TestLoginState(t *testing.T) {
+	key := []byte("state-key")
+	state, err := NewLoginState("google", 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Verifier) < 43 || state.State == state.Nonce {
+		t.Fatalf("weak login state %+v", state)
+	}
+	sealed, err := state.Seal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := OpenLoginState(key, sealed, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Provider != "google" || opened.Verifier != state.Verifier || !opened.Matches(state.State) {
+		t.Fatalf("opened %+v, want %+v", opened, state)
+	}
+	if opened.Matches("") || opened.Matches(state.Nonce) {
+		t.Fatal("state matched the wrong value")
+	}
+
+	// A changed payload, another key or an expired state are all refused
+	payload, mac, _ := strings.Cut(sealed, ".")
+	forged := payload[:len(payload)-2] + "AA." + mac
+	for name, open := range map[string]func() error{
+		"forged": func() error { _, err := OpenLoginState(key, forged, time.Now()); return err },
+		"key":    func() error { _, err := OpenLoginState([]byte("other"), sealed, time.Now()); return err },
+		"expiry": func() error { _, err := OpenLoginState(key, sealed, time.Now().Add(11*time.Minute)); return err },
+		"empty":  func() error { _, err := OpenLoginState(key, "", time.Now()); return err },
+	} {
+		if err := open(); !errors.Is(err, ErrInvalidState) {
+			t.Errorf("%s: got %v, want ErrInvalidState", name, err)
+		}
+	}
+}
+
+func TestEmailVerifiedAsString(t *testing.T) {
+	var claims Claims
+	if err := json.Unmarshal([]byte(`{"email_verified":"true"}`), &claims); err != nil || !claims.EmailVerified {
+		t.Fatalf("got %v, %v", claims.EmailVerified, err)
+	}
+	if err := json.Unmarshal([]byte(`{"email_verified":false}`), &claims); err != nil || claims.EmailVerified {
+		t.Fatalf("got %v, %v", claims.EmailVerified, err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	good := Config{Name: "google", Issuer: "https://accounts.google.com", ClientID: "id", RedirectURL: "https://shop.example/callback"}
+	if err := good.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	local := good
+	local.Issuer = "http://127.0.0.1:5556"
+	if err := local.Validate(); err != nil {
+		t.Fatalf("loopback issuer refused: %v", err)
+	}
+	github := Config{Name: "github", Type: TypeGitHub, ClientID: "id", ClientSecret: "secret", RedirectURL: "https://shop.example/callback"}
+	if err := github.Validate(); err != nil {
+		t.Fatalf("github refused: %v", err)
+	}
+	github.ClientSecret = ""
+	if err := github.Validate(); err == nil {
+		t.Error("github without a client secret accepted")
+	}
+
+	for name, change := range map[string]func(*Config){
+		"name":     func(c *Config) { c.Name = "Google Login" },
+		"issuer":   func(c *Config) { c.Issuer = "http://accounts.example.com" },
+		"client":   func(c *Config) { c.ClientID = "" },
+		"redirect": func(c *Config) { c.RedirectURL = "/callback" },
+		"scopes":   func(c *Config) { c.Scopes = []string{"email"} },
+		"type":     func(c *Config) { c.Type = "saml" },
+	} {
+		bad := good
+		change(&bad)
+		if err := bad.Validate(); err == nil {
+			t.Errorf("%s: Validate(%+v) succeeded, want an error", name, bad)
+		}
+	}
+}
+
+func TestKeyFetchDoesNotBlock(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 srv.URL,
+				"authorization_endpoint": srv.URL + "/authorize",
+				"token_endpoint":         srv.URL + "/token",
+				"jwks_uri":               srv.URL + "/jwks",
+			})
+		case "/jwks":
+			close(entered)
+			<-release
+			w.Write([]byte(`{"keys":[]}`))
+		}
+	}))
+	defer srv.Close()
+
+	p := NewProvider(Config{Name: "test", Issuer: srv.URL, ClientID: "id", RedirectURL: "http://127.0.0.1/callback"})
+	ctx := context.Background()
+	if _, err := p.discover(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// One request refetches the keys while another starts a login
+	fetched := make(chan error)
+	go func() {
+		_, err := p.key(ctx, "rotated")
+		fetched <- err
+	}()
+	<-entered
+
+	started := make(chan error)
+	go func() {
+		_, err := p.AuthCodeURL(ctx, &LoginState{State: "s", Nonce: "n", Verifier: "v"})
+		started <- err
+	}()
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("login waited for the key fetch")
+	}
+
+	close(release)
+	if err := <-fetched; err == nil {
+		t.Fatal("unknown key was found")
+	}
+}
@@ -0,0 +1,56 @@
+package payments
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrInvalidSignature = errors.New("invalid payment signature")
+
+// Order is a payment order created with the gateway; the client completes it
+// in the gateway's checkout widget.
+type Order struct {
+	ID       string `json:"order_id"`
+	Amount   int64  `json:"amount"` // in paise
+	Currency string `json:"currency"`
+	Key      string `json:"razorpay_key"`
+}
+
+// Gateway creates payment orders and verifies completed payments.
+type Gateway interface {
+	CreateOrder(ctx context.Context, amount float64, currency, receipt string) (*Order, error)
+	VerifyPayment(orderID, paymentID, signature string) error
+}
+
+// DefaultMockKey is the key the mock gateway hands to the client when none is
+// configured.
+const DefaultMockKey = "rzp_test_key"
+
+// Config holds the Razorpay API credentials.
+type Config struct {
+	RazorpayKey    string `yaml:"razorpay_key"`
+	RazorpaySecret string `yaml:"razorpay_secret"`
+}
+
+// Live reports whether cfg has credentials for the real gateway.
+func (cfg Config) Live() bool {
+	return cfg.RazorpayKey != "" && cfg.RazorpaySecret != ""
+}
+
+// New returns Razorpay when cfg has both a key and a secret and the mock
+// gateway otherwise.
+func New(cfg Config) Gateway {
+	if cfg.Live() {
+		return NewRazorpay(cfg.RazorpayKey, cfg.RazorpaySecret)
+	}
+	key := cfg.RazorpayKey
+	if key == "" {
+		key = DefaultMockKey
+	}
+	return NewMock(key)
+}
+
+// toPaise converts a rupee amount into the smallest currency unit.
+func toPaise(amount float64) int64 {
+	return int64(amount*100 + 0.5)
+}
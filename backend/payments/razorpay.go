@@ -0,0 +1,93 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+const razorpayOrdersURL = "https://api.razorpay.com/v1/orders"
+
+// Razorpay creates orders through the Razorpay Orders API and verifies
+// checkout signatures with the account's key secret.
+type Razorpay struct {
+	keyID     string
+	keySecret string
+	client    *http.Client
+}
+
+func NewRazorpay(keyID, keySecret string) *Razorpay {
+	return &Razorpay{
+		keyID:     keyID,
+		keySecret: keySecret,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+	}
+}
+
+func (r *Razorpay) CreateOrder(ctx context.Context, amount float64, currency, receipt string) (*Order, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"amount":   toPaise(amount),
+		"currency": currency,
+		"receipt":  receipt,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, razorpayOrdersURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(r.keyID, r.keySecret)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("razorpay: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("razorpay: create order failed with status %d", resp.StatusCode)
+	}
+
+	var created struct {
+		ID       string `json:"id"`
+		Amount   int64  `json:"amount"`
+		Currency string `json:"currency"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return nil, fmt.Errorf("razorpay: %w", err)
+	}
+
+	return &Order{
+		ID:       created.ID,
+		Amount:   created.Amount,
+		Currency: created.Currency,
+		Key:      r.keyID,
+	}, nil
+}
+
+// VerifyPayment checks the signature Razorpay checkout returns, which is
+// HMAC-SHA256("<order_id>|<payment_id>") keyed with the key secret.
+func (r *Razorpay) VerifyPayment(orderID, paymentID, signature string) error {
+	mac := hmac.New(sha256.New, []byte(r.keySecret))
+	mac.Write([]byte(orderID + "|" + paymentID))
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
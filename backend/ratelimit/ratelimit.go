@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limit is a token bucket: up to Burst requests at once, refilled evenly so
+// that Burst more are allowed every Per. A zero Burst disables the limit.
+type Limit struct {
+	Burst int           `yaml:"burst"`
+	Per   time.Duration `yaml:"per"`
+}
+
+// Enabled reports whether l limits anything.
+func (l Limit) Enabled() bool {
+	return l.Burst > 0 && l.Per > 0
+}
+
+// Validate reports a negative burst or a burst without a period.
+func (l Limit) Validate() error {
+	if l.Burst < 0 || l.Per < 0 {
+		return errors.New("rate limit cannot be negative")
+	}
+	if l.Burst > 0 && l.Per == 0 {
+		return fmt.Errorf("rate limit of %d needs a period", l.Burst)
+	}
+	return nil
+}
+
+// Decision is the outcome of taking a token. RetryAfter is set when the
+// request was refused.
+type Decision struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Store holds the buckets. MemoryStore suits a single instance; an
+// implementation backed by a shared cache lets every instance enforce the
+// same limits.
+type Store interface {
+	// Take removes one token from key's bucket if one is available.
+	Take(ctx context.Context, key string, limit Limit) (Decision, error)
+}
+
+// RetryAfter formats a wait as whole seconds for the Retry-After header,
+// rounding up so clients never retry too early.
+func RetryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds()))))
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// refill tops the bucket up for the time elapsed since it was last used.
+func (b *bucket) refill(now time.Time) {
+	rate := float64(b.limit.Burst) / b.limit.Per.Seconds()
+	b.tokens = math.Min(float64(b.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+}
+
+// sweepInterval is how often MemoryStore drops buckets that have refilled.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps buckets in process memory. It is safe for concurrent use.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}, now: time.Now}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit) (Decision, error) {
+	if !limit.Enabled() {
+		return Decision{Allowed: true}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{tokens: float64(limit.Burst), last: now, limit: limit}
+		s.buckets[key] = b
+	}
+	b.refill(now)
+
+	if b.tokens < 1 {
+		rate := float64(limit.Burst) / limit.Per.Seconds()
+		wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		return Decision{RetryAfter: wait}, nil
+	}
+	b.tokens--
+	return Decision{Allowed: true, Remaining: int(b.tokens)}, nil
+}
+
+// sweep forgets buckets that are full again, so idle keys don't accumulate.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+	for key, b := range s.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := NewMemoryStore()
+	s.now = func() time.Time { return now }
+	limit := Limit{Burst: 3, Per: time.Minute}
+	ctx := context.Background()
+
+	take := func(key string) Decision {
+		t.Helper()
+		d, err := s.Take(ctx, key, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	for i := 2; i >= 0; i-- {
+		if d := take("a"); !d.Allowed || d.Remaining != i {
+			t.Fatalf("take = %+v, want allowed with %d left", d, i)
+		}
+	}
+	d := take("a")
+	if d.Allowed || d.RetryAfter != 20*time.Second {
+		t.Fatalf("take = %+v, want refused for 20s", d)
+	}
+	if !take("b").Allowed {
+		t.Fatal("keys should not share a bucket")
+	}
+
+	// One token comes back every 20s
+	now = now.Add(20 * time.Second)
+	if !take("a").Allowed || take("a").Allowed {
+		t.Fatal("expected exactly one token after 20s")
+	}
+
+	// Full buckets are swept once idle
+	now = now.Add(2 * time.Minute)
+	take("c")
+	if _, ok := s.buckets["a"]; ok {
+		t.Fatal("idle bucket was not swept")
+	}
+}
+
+func TestDisabledLimit(t *testing.T) {
+	s := NewMemoryStore()
+	for i := 0; i < 100; i++ {
+		if d, _ := s.Take(context.Background(), "k", Limit{}); !d.Allowed {
+			t.Fatal("zero limit should allow everything")
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ecomm-backend/models"
+)
+
+// memoryProductRepository keeps products in insertion order. It is safe for
+// concurrent use.
+type memoryProductRepository struct {
+	mu       sync.RWMutex
+	products []models.Product
+}
+
+func NewMemoryProductRepository() ProductRepository {
+	return &memoryProductRepository{}
+}
+
+func copyProduct(p models.Product) models.Product {
+	if p.Stock != nil {
+		stock := *p.Stock
+		p.Stock = &stock
+	}
+	return p
+}
+
+func (r *memoryProductRepository) FindAll(ctx context.Context) ([]models.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	products := make([]models.Product, 0, len(r.products))
+	for _, p := range r.products {
+		products = append(products, copyProduct(p))
+	}
+	return products, nil
+}
+
+func (r *memoryProductRepository) FindByID(ctx context.Context, id string) (*models.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, p := range r.products {
+		if p.ID.Hex() == id || p.ProductID == id {
+			cp := copyProduct(p)
+			return &cp, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryProductRepository) SearchByName(ctx context.Context, query string) ([]models.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	query = strings.ToLower(query)
+	products := []models.Product{}
+	for _, p := range r.products {
+		if strings.Contains(strings.ToLower(p.ProductName), query) {
+			products = append(products, copyProduct(p))
+		}
+	}
+	return products, nil
+}
+
+func (r *memoryProductRepository) InsertMany(ctx context.Context, products []models.Product) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range products {
+		if p.ID.IsZero() {
+			p.ID = primitive.NewObjectID()
+		}
+		r.products = append(r.products, copyProduct(p))
+	}
+	return nil
+}
+
+func (r *memoryProductRepository) ReserveStock(ctx context.Context, id primitive.ObjectID, qty int) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.products {
+		p := &r.products[i]
+		if p.ID != id {
+			continue
+		}
+		if p.Stock == nil || *p.Stock < qty {
+			return false, nil
+		}
+		*p.Stock -= qty
+		p.UpdatedAt = time.Now()
+		return true, nil
+	}
+	return false, nil
+}
+
+func (r *memoryProductRepository) ReleaseStock(ctx context.Context, id primitive.ObjectID, qty int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range r.products {
+		p := &r.products[i]
+		if p.ID == id && p.Stock != nil {
+			*p.Stock += qty
+			p.UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,526 @@
+package repository
+
+import (
+	"context"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ecomm-backend/models"
+)
+
+// memoryUserRepository keeps users in memory. It is safe for concurrent use
+// and deep-copies users in and out, so callers never share state with the
+// store.
+type memoryUserRepository struct {
+	mu    sync.RWMutex
+	users map[string]*models.User
+}
+
+func NewMemoryUserRepository() UserRepository {
+	return &memoryUserRepository{users: map[string]*models.User{}}
+}
+
+// copyUser copies u down to every pointer and slice, so neither the store
+// nor its callers see the other's later changes.
+func copyUser(u *models.User) *models.User {
+	cp := *u
+	cp.LoginOTP = copyPtr(u.LoginOTP)
+	cp.TwoFactor = copyTwoFactor(u.TwoFactor)
+	cp.EmailVerification = copyPtr(u.EmailVerification)
+	cp.LockedUntil = copyPtr(u.LockedUntil)
+	cp.PasswordReset = copyPtr(u.PasswordReset)
+	cp.Sessions = slices.Clone(u.Sessions)
+	cp.Identities = slices.Clone(u.Identities)
+	cp.UserCart = copyItems(u.UserCart)
+	cp.Address = slices.Clone(u.Address)
+	if u.Orders != nil {
+		cp.Orders = make([]models.Order, len(u.Orders))
+		for i, o := range u.Orders {
+			cp.Orders[i] = copyOrder(o)
+		}
+	}
+	return &cp
+}
+
+// copyPtr copies the value p points to, for types without pointers or
+// slices of their own.
+func copyPtr[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func copyTwoFactor(t *models.TwoFactor) *models.TwoFactor {
+	if t == nil {
+		return nil
+	}
+	cp := *t
+	cp.RecoveryCodes = slices.Clone(t.RecoveryCodes)
+	return &cp
+}
+
+func copyItems(items []models.ProductUser) []models.ProductUser {
+	cp := slices.Clone(items)
+	for i := range cp {
+		cp[i].Rating = copyPtr(cp[i].Rating)
+	}
+	return cp
+}
+
+func copyOrder(o models.Order) models.Order {
+	o.OrderList = copyItems(o.OrderList)
+	o.Shipping = copyPtr(o.Shipping)
+	o.Discount = copyPtr(o.Discount)
+	o.DeliveryAddress = copyPtr(o.DeliveryAddress)
+	if o.Tax != nil {
+		tax := *o.Tax
+		tax.Components = slices.Clone(tax.Components)
+		tax.Lines = slices.Clone(tax.Lines)
+		for i := range tax.Lines {
+			tax.Lines[i].Components = slices.Clone(tax.Lines[i].Components)
+		}
+		o.Tax = &tax
+	}
+	return o
+}
+
+func (r *memoryUserRepository) find(match func(*models.User) bool) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, u := range r.users {
+		if match(u) {
+			return copyUser(u), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// update applies fn to the stored user under the write lock.
+func (r *memoryUserRepository) update(userID string, fn func(*models.User)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	fn(u)
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *memoryUserRepository) FindByUserID(ctx context.Context, userID string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.UserID == userID })
+}
+
+func (r *memoryUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	email = strings.ToLower(email)
+	return r.find(func(u *models.User) bool { return u.Email == email })
+}
+
+func (r *memoryUserRepository) FindByPhone(ctx context.Context, phone string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return u.Phone == phone })
+}
+
+func (r *memoryUserRepository) FindByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	return r.find(func(u *models.User) bool { return hasIdentity(u, provider, subject) })
+}
+
+func hasIdentity(u *models.User, provider, subject string) bool {
+	for _, identity := range u.Identities {
+		if identity.Provider == provider && identity.Subject == subject {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *memoryUserRepository) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, u := range r.users {
+		// Users who signed up through a provider may have no phone
+		if u.UserID == user.UserID || u.Email == user.Email || user.Phone != "" && u.Phone == user.Phone {
+			return ErrDuplicate
+		}
+		for _, identity := range user.Identities {
+			if hasIdentity(u, identity.Provider, identity.Subject) {
+				return ErrDuplicate
+			}
+		}
+	}
+	stored := copyUser(user)
+	if stored.ID.IsZero() {
+		stored.ID = primitive.NewObjectID()
+	}
+	r.users[user.UserID] = stored
+	return nil
+}
+
+func (r *memoryUserRepository) UpdateTokens(ctx context.Context, userID, token, refreshToken string) error {
+	return r.update(userID, func(u *models.User) {
+		u.Token = token
+		u.RefreshToken = refreshToken
+	})
+}
+
+func (r *memoryUserRepository) RecordFailedLogin(ctx context.Context, userID string) (int, error) {
+	var failures int
+	err := r.update(userID, func(u *models.User) {
+		u.FailedLogins++
+		failures = u.FailedLogins
+	})
+	return failures, err
+}
+
+func (r *memoryUserRepository) LockUntil(ctx context.Context, userID string, until time.Time) error {
+	return r.update(userID, func(u *models.User) {
+		u.LockedUntil = &until
+	})
+}
+
+func (r *memoryUserRepository) ResetFailedLogins(ctx context.Context, userID string) error {
+	return r.update(userID, func(u *models.User) {
+		u.FailedLogins = 0
+		u.LockedUntil = nil
+	})
+}
+
+func (r *memoryUserRepository) SetPasswordReset(ctx context.Context, userID string, reset models.PasswordReset) error {
+	return r.update(userID, func(u *models.User) {
+		u.PasswordReset = &reset
+	})
+}
+
+func (r *memoryUserRepository) FindByPasswordReset(ctx context.Context, tokenHash string) (*models.User, error) {
+	return r.find(func(u *models.User) bool {
+		return u.PasswordReset != nil && u.PasswordReset.TokenHash == tokenHash
+	})
+}
+
+func (r *memoryUserRepository) ResetPassword(ctx context.Context, userID, tokenHash, passwordHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[userID]
+	if !ok || u.PasswordReset == nil || u.PasswordReset.TokenHash != tokenHash {
+		return ErrNotFound
+	}
+	u.Password = passwordHash
+	u.PasswordReset = nil
+	u.FailedLogins = 0
+	u.LockedUntil = nil
+	u.TokenVersion++
+	u.Token, u.RefreshToken = "", ""
+	u.Sessions = nil
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *memoryUserRepository) SetEmailVerification(ctx context.Context, userID string, verification models.EmailVerification) error {
+	return r.update(userID, func(u *models.User) {
+		u.EmailVerification = &verification
+	})
+}
+
+func (r *memoryUserRepository) FindByEmailVerification(ctx context.Context, tokenHash string) (*models.User, error) {
+	return r.find(func(u *models.User) bool {
+		return u.EmailVerification != nil && u.EmailVerification.TokenHash == tokenHash
+	})
+}
+
+func (r *memoryUserRepository) VerifyEmail(ctx context.Context, userID, tokenHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[userID]
+	if !ok || u.EmailVerification == nil || u.EmailVerification.TokenHash != tokenHash {
+		return ErrNotFound
+	}
+	u.EmailVerified = true
+	u.EmailVerification = nil
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *memoryUserRepository) SetLoginOTP(ctx context.Context, userID string, otp models.LoginOTP) error {
+	return r.update(userID, func(u *models.User) {
+		u.LoginOTP = &otp
+	})
+}
+
+func (r *memoryUserRepository) RecordOTPAttempt(ctx context.Context, userID string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[userID]
+	if !ok || u.LoginOTP == nil {
+		return 0, ErrNotFound
+	}
+	u.LoginOTP.Attempts++
+	return u.LoginOTP.Attempts, nil
+}
+
+func (r *memoryUserRepository) ConsumeLoginOTP(ctx context.Context, userID, codeHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[userID]
+	if !ok || u.LoginOTP == nil || u.LoginOTP.CodeHash != codeHash {
+		return ErrNotFound
+	}
+	u.PhoneVerified = true
+	u.LoginOTP = nil
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *memoryUserRepository) SetTwoFactor(ctx context.Context, userID string, twoFactor *models.TwoFactor) error {
+	twoFactor = copyTwoFactor(twoFactor)
+	return r.update(userID, func(u *models.User) {
+		u.TwoFactor = twoFactor
+	})
+}
+
+func (r *memoryUserRepository) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[userID]
+	if !ok || u.TwoFactor == nil || u.TwoFactor.LastStep >= step {
+		return ErrNotFound
+	}
+	u.TwoFactor.LastStep = step
+	return nil
+}
+
+func (r *memoryUserRepository) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[userID]
+	if !ok || u.TwoFactor == nil {
+		return ErrNotFound
+	}
+	for i, hash := range u.TwoFactor.RecoveryCodes {
+		if hash == codeHash {
+			u.TwoFactor.RecoveryCodes = append(u.TwoFactor.RecoveryCodes[:i:i], u.TwoFactor.RecoveryCodes[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *memoryUserRepository) UpdateProfile(ctx context.Context, userID string, update ProfileUpdate) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+
+	// Emails and phones are unique, like the Mongo indexes
+	email := strings.ToLower(update.Email)
+	for _, other := range r.users {
+		if other.UserID == userID {
+			continue
+		}
+		if email != "" && other.Email == email || update.Phone != "" && other.Phone == update.Phone {
+			return ErrDuplicate
+		}
+	}
+
+	if update.FirstName != "" {
+		u.FirstName = update.FirstName
+	}
+	if update.LastName != "" {
+		u.LastName = update.LastName
+	}
+	if email != "" {
+		u.Email = email
+	}
+	if update.EmailVerification != nil {
+		verification := *update.EmailVerification
+		u.EmailVerified = false
+		u.EmailVerification = &verification
+	}
+	if update.Phone != "" {
+		u.Phone = update.Phone
+	}
+	if update.PhoneChanged {
+		u.PhoneVerified = false
+		u.LoginOTP = nil
+	}
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *memoryUserRepository) AddSession(ctx context.Context, userID string, session models.Session) error {
+	return r.update(userID, func(u *models.User) {
+		u.Sessions = append(u.Sessions, session)
+		if len(u.Sessions) > MaxSessions {
+			u.Sessions = append([]models.Session(nil), u.Sessions[len(u.Sessions)-MaxSessions:]...)
+		}
+	})
+}
+
+// session returns the stored session with ID sessionID. Callers hold mu.
+func (r *memoryUserRepository) session(userID, sessionID string) (*models.User, int, bool) {
+	u, ok := r.users[userID]
+	if !ok {
+		return nil, 0, false
+	}
+	for i := range u.Sessions {
+		if u.Sessions[i].ID == sessionID {
+			return u, i, true
+		}
+	}
+	return nil, 0, false
+}
+
+func (r *memoryUserRepository) TouchSession(ctx context.Context, userID, sessionID string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, i, ok := r.session(userID, sessionID)
+	if !ok {
+		return ErrNotFound
+	}
+	u.Sessions[i].LastSeenAt = at
+	return nil
+}
+
+func (r *memoryUserRepository) RotateRefresh(ctx context.Context, userID, sessionID, oldID, newID string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, i, ok := r.session(userID, sessionID)
+	if !ok || u.Sessions[i].RefreshID != oldID {
+		return ErrNotFound
+	}
+	u.Sessions[i].RefreshID = newID
+	u.Sessions[i].LastSeenAt = at
+	return nil
+}
+
+func (r *memoryUserRepository) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, i, ok := r.session(userID, sessionID)
+	if !ok {
+		return ErrNotFound
+	}
+	u.Sessions = append(u.Sessions[:i:i], u.Sessions[i+1:]...)
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *memoryUserRepository) LinkIdentity(ctx context.Context, userID string, identity models.Identity, takeOver bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	u, ok := r.users[userID]
+	if !ok {
+		return ErrNotFound
+	}
+	for _, other := range r.users {
+		if hasIdentity(other, identity.Provider, identity.Subject) {
+			return ErrDuplicate
+		}
+	}
+	u.Identities = append(u.Identities, identity)
+	if takeOver {
+		u.EmailVerified = true
+		u.EmailVerification = nil
+		u.Password = ""
+		u.PasswordReset = nil
+		u.TokenVersion++
+		u.Token = ""
+		u.RefreshToken = ""
+		u.Sessions = nil
+	}
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *memoryUserRepository) AddAddress(ctx context.Context, userID string, address models.Address) error {
+	return r.update(userID, func(u *models.User) {
+		u.Address = append(u.Address, address)
+	})
+}
+
+func (r *memoryUserRepository) SetAddresses(ctx context.Context, userID string, addresses []models.Address) error {
+	return r.update(userID, func(u *models.User) {
+		u.Address = append([]models.Address{}, addresses...)
+	})
+}
+
+func (r *memoryUserRepository) SetCart(ctx context.Context, userID string, cart []models.ProductUser) error {
+	return r.update(userID, func(u *models.User) {
+		u.UserCart = copyItems(cart)
+		if u.UserCart == nil {
+			u.UserCart = []models.ProductUser{}
+		}
+	})
+}
+
+func (r *memoryUserRepository) PlaceOrder(ctx context.Context, userID string, cart []models.ProductUser, order models.Order) error {
+	placed := false
+	err := r.update(userID, func(u *models.User) {
+		if !reflect.DeepEqual(u.UserCart, cart) {
+			return
+		}
+		u.Orders = append(u.Orders, copyOrder(order))
+		u.UserCart = []models.ProductUser{}
+		placed = true
+	})
+	if err == nil && !placed {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *memoryUserRepository) RemoveOrder(ctx context.Context, userID string, orderID primitive.ObjectID) error {
+	return r.update(userID, func(u *models.User) {
+		orders := u.Orders[:0]
+		for _, o := range u.Orders {
+			if o.ID != orderID {
+				orders = append(orders, o)
+			}
+		}
+		u.Orders = orders
+	})
+}
+
+func (r *memoryUserRepository) SetOrderGatewayID(ctx context.Context, userID string, orderID primitive.ObjectID, gatewayOrderID string) error {
+	found := false
+	err := r.update(userID, func(u *models.User) {
+		for i := range u.Orders {
+			if u.Orders[i].ID == orderID {
+				u.Orders[i].RazorpayOrderID = gatewayOrderID
+				found = true
+				return
+			}
+		}
+	})
+	if err == nil && !found {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *memoryUserRepository) MarkOrderPaid(ctx context.Context, userID, gatewayOrderID, paymentID string) (bool, error) {
+	matched := false
+	err := r.update(userID, func(u *models.User) {
+		for i := range u.Orders {
+			o := &u.Orders[i]
+			if o.RazorpayOrderID == gatewayOrderID && o.Status == models.OrderStatusPendingPayment {
+				o.Status = models.OrderStatusPaid
+				o.RazorpayPaymentID = paymentID
+				matched = true
+				return
+			}
+		}
+	})
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return matched, err
+}
@@ -0,0 +1,62 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ecomm-backend/models"
+)
+
+func TestMemoryUsersAreCopies(t *testing.T) {
+	ctx := context.Background()
+	r := NewMemoryUserRepository()
+	rating := 4.5
+	user := &models.User{
+		UserID:    "u1",
+		Email:     "asha@example.com",
+		TwoFactor: &models.TwoFactor{Secret: "s", Enabled: true, RecoveryCodes: []string{"a", "b"}},
+		UserCart:  []models.ProductUser{{ProductID: "p1", Rating: &rating, Quantity: 1}},
+	}
+	if err := r.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.SetLoginOTP(ctx, "u1", models.LoginOTP{CodeHash: "h", ExpiresAt: time.Now().Add(time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.LockUntil(ctx, "u1", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	before, err := r.FindByUserID(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes in the store don't reach users already handed out
+	if _, err := r.RecordOTPAttempt(ctx, "u1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.UseTOTPStep(ctx, "u1", 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.UseRecoveryCode(ctx, "u1", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if before.LoginOTP.Attempts != 0 || before.TwoFactor.LastStep != 0 || len(before.TwoFactor.RecoveryCodes) != 2 {
+		t.Fatalf("handed-out user changed: otp %+v, two-factor %+v", before.LoginOTP, before.TwoFactor)
+	}
+
+	// Nor do changes to them, or to what was stored, reach the store
+	*before.LockedUntil = time.Time{}
+	before.TwoFactor.Enabled = false
+	*before.UserCart[0].Rating = 1
+	user.TwoFactor.RecoveryCodes[1] = "changed"
+	after, err := r.FindByUserID(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.LockedUntil.IsZero() || !after.TwoFactor.Enabled || *after.UserCart[0].Rating != 4.5 ||
+		after.TwoFactor.RecoveryCodes[0] != "b" || after.LoginOTP.Attempts != 1 {
+		t.Fatalf("store changed through a copy: %+v", after)
+	}
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"ecomm-backend/models"
+)
+
+type mongoProductRepository struct {
+	collection *mongo.Collection
+	timeout    time.Duration
+}
+
+// NewMongoProductRepository stores products in collection; see
+// NewMongoUserRepository for timeout.
+func NewMongoProductRepository(collection *mongo.Collection, timeout time.Duration) ProductRepository {
+	return &mongoProductRepository{collection: collection, timeout: timeout}
+}
+
+func (r *mongoProductRepository) find(ctx context.Context, filter bson.M) ([]models.Product, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	products := []models.Product{}
+	if err = cursor.All(ctx, &products); err != nil {
+		return nil, err
+	}
+	return products, nil
+}
+
+func (r *mongoProductRepository) FindAll(ctx context.Context) ([]models.Product, error) {
+	return r.find(ctx, bson.M{})
+}
+
+func (r *mongoProductRepository) FindByID(ctx context.Context, id string) (*models.Product, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	filter := bson.M{"product_id": id}
+	if objectID, err := primitive.ObjectIDFromHex(id); err == nil {
+		filter = bson.M{"$or": []bson.M{{"_id": objectID}, {"product_id": id}}}
+	}
+
+	var product models.Product
+	err := r.collection.FindOne(ctx, filter).Decode(&product)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &product, nil
+}
+
+func (r *mongoProductRepository) SearchByName(ctx context.Context, query string) ([]models.Product, error) {
+	return r.find(ctx, bson.M{
+		"product_name": bson.M{"$regex": regexp.QuoteMeta(query), "$options": "i"},
+	})
+}
+
+func (r *mongoProductRepository) InsertMany(ctx context.Context, products []models.Product) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	docs := make([]interface{}, len(products))
+	for i, p := range products {
+		docs[i] = p
+	}
+	_, err := r.collection.InsertMany(ctx, docs)
+	return err
+}
+
+func (r *mongoProductRepository) ReserveStock(ctx context.Context, id primitive.ObjectID, qty int) (bool, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	result, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id, "stock": bson.M{"$gte": qty}},
+		bson.M{"$inc": bson.M{"stock": -qty}, "$set": bson.M{"updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
+
+func (r *mongoProductRepository) ReleaseStock(ctx context.Context, id primitive.ObjectID, qty int) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	_, err := r.collection.UpdateOne(ctx,
+		bson.M{"_id": id},
+		bson.M{"$inc": bson.M{"stock": qty}, "$set": bson.M{"updatedAt": time.Now()}},
+	)
+	return err
+}
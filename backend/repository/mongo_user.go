@@ -0,0 +1,374 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"ecomm-backend/models"
+)
+
+type mongoUserRepository struct {
+	collection *mongo.Collection
+	timeout    time.Duration
+}
+
+// NewMongoUserRepository stores users in collection. Each operation is given
+// at most timeout, within any deadline the caller already set.
+func NewMongoUserRepository(collection *mongo.Collection, timeout time.Duration) UserRepository {
+	return &mongoUserRepository{collection: collection, timeout: timeout}
+}
+
+func (r *mongoUserRepository) findOne(ctx context.Context, filter bson.M) (*models.User, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var user models.User
+	err := r.collection.FindOne(ctx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *mongoUserRepository) updateOne(ctx context.Context, userID string, update bson.M) error {
+	return r.updateMatching(ctx, bson.M{"user_id": userID}, update)
+}
+
+// updateMatching applies update to the user matching filter, returning
+// ErrNotFound when none does.
+func (r *mongoUserRepository) updateMatching(ctx context.Context, filter, update bson.M) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *mongoUserRepository) FindByUserID(ctx context.Context, userID string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"user_id": userID})
+}
+
+func (r *mongoUserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"email": strings.ToLower(email)})
+}
+
+func (r *mongoUserRepository) FindByPhone(ctx context.Context, phone string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"phone": phone})
+}
+
+func (r *mongoUserRepository) FindByIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}})
+}
+
+func (r *mongoUserRepository) Create(ctx context.Context, user *models.User) error {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	_, err := r.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *mongoUserRepository) UpdateTokens(ctx context.Context, userID, token, refreshToken string) error {
+	return r.updateOne(ctx, userID, bson.M{
+		"$set": bson.M{
+			"token":         token,
+			"refresh_token": refreshToken,
+			"updatedAt":     time.Now(),
+		},
+	})
+}
+
+func (r *mongoUserRepository) RecordFailedLogin(ctx context.Context, userID string) (int, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var user models.User
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"user_id": userID},
+		bson.M{"$inc": bson.M{"failed_logins": 1}},
+		options.FindOneAndUpdate().
+			SetReturnDocument(options.After).
+			SetProjection(bson.M{"failed_logins": 1}),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return user.FailedLogins, nil
+}
+
+func (r *mongoUserRepository) LockUntil(ctx context.Context, userID string, until time.Time) error {
+	return r.updateOne(ctx, userID, bson.M{"$set": bson.M{"locked_until": until}})
+}
+
+func (r *mongoUserRepository) ResetFailedLogins(ctx context.Context, userID string) error {
+	return r.updateOne(ctx, userID, bson.M{"$unset": bson.M{"failed_logins": "", "locked_until": ""}})
+}
+
+func (r *mongoUserRepository) SetPasswordReset(ctx context.Context, userID string, reset models.PasswordReset) error {
+	return r.updateOne(ctx, userID, bson.M{"$set": bson.M{"password_reset": reset}})
+}
+
+func (r *mongoUserRepository) FindByPasswordReset(ctx context.Context, tokenHash string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"password_reset.token_hash": tokenHash})
+}
+
+func (r *mongoUserRepository) ResetPassword(ctx context.Context, userID, tokenHash, passwordHash string) error {
+	// Matching on the token hash makes the reset single-use even when two
+	// requests race
+	return r.updateMatching(ctx,
+		bson.M{"user_id": userID, "password_reset.token_hash": tokenHash},
+		bson.M{
+			"$set":   bson.M{"password": passwordHash, "updatedAt": time.Now()},
+			"$inc":   bson.M{"token_version": 1},
+			"$unset": bson.M{"password_reset": "", "failed_logins": "", "locked_until": "", "token": "", "refresh_token": "", "sessions": ""},
+		},
+	)
+}
+
+func (r *mongoUserRepository) SetEmailVerification(ctx context.Context, userID string, verification models.EmailVerification) error {
+	return r.updateOne(ctx, userID, bson.M{"$set": bson.M{"email_verification": verification}})
+}
+
+func (r *mongoUserRepository) FindByEmailVerification(ctx context.Context, tokenHash string) (*models.User, error) {
+	return r.findOne(ctx, bson.M{"email_verification.token_hash": tokenHash})
+}
+
+func (r *mongoUserRepository) VerifyEmail(ctx context.Context, userID, tokenHash string) error {
+	return r.updateMatching(ctx,
+		bson.M{"user_id": userID, "email_verification.token_hash": tokenHash},
+		bson.M{
+			"$set":   bson.M{"email_verified": true, "updatedAt": time.Now()},
+			"$unset": bson.M{"email_verification": ""},
+		},
+	)
+}
+
+func (r *mongoUserRepository) SetLoginOTP(ctx context.Context, userID string, otp models.LoginOTP) error {
+	return r.updateOne(ctx, userID, bson.M{"$set": bson.M{"login_otp": otp}})
+}
+
+func (r *mongoUserRepository) RecordOTPAttempt(ctx context.Context, userID string) (int, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	var user models.User
+	err := r.collection.FindOneAndUpdate(ctx,
+		bson.M{"user_id": userID, "login_otp": bson.M{"$exists": true}},
+		bson.M{"$inc": bson.M{"login_otp.attempts": 1}},
+		options.FindOneAndUpdate().
+			SetReturnDocument(options.After).
+			SetProjection(bson.M{"login_otp": 1}),
+	).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return user.LoginOTP.Attempts, nil
+}
+
+func (r *mongoUserRepository) ConsumeLoginOTP(ctx context.Context, userID, codeHash string) error {
+	return r.updateMatching(ctx,
+		bson.M{"user_id": userID, "login_otp.code_hash": codeHash},
+		bson.M{
+			"$set":   bson.M{"phone_verified": true, "updatedAt": time.Now()},
+			"$unset": bson.M{"login_otp": ""},
+		},
+	)
+}
+
+func (r *mongoUserRepository) SetTwoFactor(ctx context.Context, userID string, twoFactor *models.TwoFactor) error {
+	if twoFactor == nil {
+		return r.updateOne(ctx, userID, bson.M{"$unset": bson.M{"two_factor": ""}})
+	}
+	return r.updateOne(ctx, userID, bson.M{"$set": bson.M{"two_factor": twoFactor}})
+}
+
+func (r *mongoUserRepository) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	return r.updateMatching(ctx,
+		bson.M{"user_id": userID, "two_factor": bson.M{"$exists": true}, "two_factor.last_step": bson.M{"$not": bson.M{"$gte": step}}},
+		bson.M{"$set": bson.M{"two_factor.last_step": step}},
+	)
+}
+
+func (r *mongoUserRepository) UseRecoveryCode(ctx context.Context, userID, codeHash string) error {
+	return r.updateMatching(ctx,
+		bson.M{"user_id": userID, "two_factor.recovery_codes": codeHash},
+		bson.M{"$pull": bson.M{"two_factor.recovery_codes": codeHash}},
+	)
+}
+
+func (r *mongoUserRepository) UpdateProfile(ctx context.Context, userID string, update ProfileUpdate) error {
+	set := bson.M{"updatedAt": time.Now()}
+	if update.FirstName != "" {
+		set["first_name"] = update.FirstName
+	}
+	if update.LastName != "" {
+		set["last_name"] = update.LastName
+	}
+	if update.Email != "" {
+		set["email"] = strings.ToLower(update.Email)
+	}
+	if update.EmailVerification != nil {
+		set["email_verified"] = false
+		set["email_verification"] = *update.EmailVerification
+	}
+	if update.Phone != "" {
+		set["phone"] = update.Phone
+	}
+	change := bson.M{"$set": set}
+	if update.PhoneChanged {
+		set["phone_verified"] = false
+		change["$unset"] = bson.M{"login_otp": ""}
+	}
+	err := r.updateOne(ctx, userID, change)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *mongoUserRepository) AddSession(ctx context.Context, userID string, session models.Session) error {
+	return r.updateOne(ctx, userID, bson.M{
+		"$push": bson.M{"sessions": bson.M{"$each": []models.Session{session}, "$slice": -MaxSessions}},
+	})
+}
+
+func (r *mongoUserRepository) TouchSession(ctx context.Context, userID, sessionID string, at time.Time) error {
+	return r.updateMatching(ctx,
+		bson.M{"user_id": userID, "sessions.session_id": sessionID},
+		bson.M{"$set": bson.M{"sessions.$.last_seen_at": at}},
+	)
+}
+
+func (r *mongoUserRepository) RotateRefresh(ctx context.Context, userID, sessionID, oldID, newID string, at time.Time) error {
+	// Sessions from before rotation have no refresh ID yet
+	var current interface{} = oldID
+	if oldID == "" {
+		current = bson.M{"$in": bson.A{"", nil}}
+	}
+	return r.updateMatching(ctx,
+		bson.M{"user_id": userID, "sessions": bson.M{"$elemMatch": bson.M{"session_id": sessionID, "refresh_id": current}}},
+		bson.M{"$set": bson.M{"sessions.$.refresh_id": newID, "sessions.$.last_seen_at": at}},
+	)
+}
+
+func (r *mongoUserRepository) RevokeSession(ctx context.Context, userID, sessionID string) error {
+	return r.updateMatching(ctx,
+		bson.M{"user_id": userID, "sessions.session_id": sessionID},
+		bson.M{"$pull": bson.M{"sessions": bson.M{"session_id": sessionID}}},
+	)
+}
+
+func (r *mongoUserRepository) LinkIdentity(ctx context.Context, userID string, identity models.Identity, takeOver bool) error {
+	update := bson.M{
+		"$push": bson.M{"identities": identity},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	}
+	if takeOver {
+		update["$set"] = bson.M{"email_verified": true, "password": "", "updatedAt": time.Now()}
+		update["$inc"] = bson.M{"token_version": 1}
+		update["$unset"] = bson.M{"email_verification": "", "password_reset": "", "token": "", "refresh_token": "", "sessions": ""}
+	}
+	err := r.updateOne(ctx, userID, update)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *mongoUserRepository) AddAddress(ctx context.Context, userID string, address models.Address) error {
+	return r.updateOne(ctx, userID, bson.M{
+		"$push": bson.M{"address": address},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	})
+}
+
+func (r *mongoUserRepository) SetAddresses(ctx context.Context, userID string, addresses []models.Address) error {
+	return r.updateOne(ctx, userID, bson.M{
+		"$set": bson.M{"address": addresses, "updatedAt": time.Now()},
+	})
+}
+
+func (r *mongoUserRepository) SetCart(ctx context.Context, userID string, cart []models.ProductUser) error {
+	if cart == nil {
+		cart = []models.ProductUser{}
+	}
+	return r.updateOne(ctx, userID, bson.M{
+		"$set": bson.M{"usercart": cart, "updatedAt": time.Now()},
+	})
+}
+
+func (r *mongoUserRepository) PlaceOrder(ctx context.Context, userID string, cart []models.ProductUser, order models.Order) error {
+	return r.updateMatching(ctx, bson.M{"user_id": userID, "usercart": cart}, bson.M{
+		"$push": bson.M{"orders": order},
+		"$set": bson.M{
+			"usercart":  []models.ProductUser{},
+			"updatedAt": time.Now(),
+		},
+	})
+}
+
+func (r *mongoUserRepository) RemoveOrder(ctx context.Context, userID string, orderID primitive.ObjectID) error {
+	return r.updateOne(ctx, userID, bson.M{
+		"$pull": bson.M{"orders": bson.M{"_id": orderID}},
+		"$set":  bson.M{"updatedAt": time.Now()},
+	})
+}
+
+func (r *mongoUserRepository) SetOrderGatewayID(ctx context.Context, userID string, orderID primitive.ObjectID, gatewayOrderID string) error {
+	return r.updateMatching(ctx, bson.M{"user_id": userID, "orders._id": orderID}, bson.M{
+		"$set": bson.M{
+			"orders.$.razorpay_order_id": gatewayOrderID,
+			"updatedAt":                  time.Now(),
+		},
+	})
+}
+
+func (r *mongoUserRepository) MarkOrderPaid(ctx context.Context, userID, gatewayOrderID, paymentID string) (bool, error) {
+	ctx, cancel := withTimeout(ctx, r.timeout)
+	defer cancel()
+
+	filter := bson.M{
+		"user_id": userID,
+		"orders": bson.M{"$elemMatch": bson.M{
+			"razorpay_order_id": gatewayOrderID,
+			"status":            models.OrderStatusPendingPayment,
+		}},
+	}
+	update := bson.M{
+		"$set": bson.M{
+			"orders.$.status":              models.OrderStatusPaid,
+			"orders.$.razorpay_payment_id": paymentID,
+			"updatedAt":                    time.Now(),
+		},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+	return result.MatchedCount > 0, nil
+}
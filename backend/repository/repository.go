@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ecomm-backend/models"
+)
+
+var (
+	ErrNotFound  = errors.New("not found")
+	ErrDuplicate = errors.New("duplicate key")
+)
+
+// MaxSessions is how many sessions a user keeps; a new login beyond it ends
+// the oldest.
+const MaxSessions = 20
+
+// ProfileUpdate holds the profile fields to change; empty fields are left as is.
+type ProfileUpdate struct {
+	FirstName string
+	LastName  string
+	Email     string
+	Phone     string
+	// EmailVerification, set along with a new Email, marks the address
+	// unverified and replaces any outstanding verification.
+	EmailVerification *models.EmailVerification
+	// PhoneChanged, set along with a new Phone, marks the number unverified.
+	PhoneChanged bool
+}
+
+// UserRepository stores users. Carts, addresses and orders are embedded in
+// the user document, so their writes live here too until they move to
+// their own collections.
+type UserRepository interface {
+	FindByUserID(ctx context.Context, userID string) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	FindByPhone(ctx context.Context, phone string) (*models.User, error)
+	// FindByIdentity finds the user linked to the provider account with
+	// the given subject.
+	FindByIdentity(ctx context.Context, provider, subject string) (*models.User, error)
+	Create(ctx context.Context, user *models.User) error
+
+	UpdateTokens(ctx context.Context, userID, token, refreshToken string) error
+
+	// RecordFailedLogin counts a wrong password and returns the number of
+	// consecutive failures.
+	RecordFailedLogin(ctx context.Context, userID string) (int, error)
+	// LockUntil refuses logins to the account until the given time.
+	LockUntil(ctx context.Context, userID string, until time.Time) error
+	// ResetFailedLogins clears the failure count and any lock.
+	ResetFailedLogins(ctx context.Context, userID string) error
+
+	// SetPasswordReset records a reset request, replacing any earlier one.
+	SetPasswordReset(ctx context.Context, userID string, reset models.PasswordReset) error
+	// FindByPasswordReset finds the user with an outstanding reset whose
+	// token has the given hash.
+	FindByPasswordReset(ctx context.Context, tokenHash string) (*models.User, error)
+	// ResetPassword consumes the reset with tokenHash and sets the new
+	// password. It also clears any lockout and revokes every token and
+	// session issued so far. It returns ErrNotFound if the reset was
+	// already used.
+	ResetPassword(ctx context.Context, userID, tokenHash, passwordHash string) error
+
+	// SetEmailVerification records a verification link, replacing any
+	// earlier one.
+	SetEmailVerification(ctx context.Context, userID string, verification models.EmailVerification) error
+	// FindByEmailVerification finds the user with an outstanding
+	// verification whose token has the given hash.
+	FindByEmailVerification(ctx context.Context, tokenHash string) (*models.User, error)
+	// VerifyEmail consumes the verification with tokenHash and marks the
+	// email verified. It returns ErrNotFound if it was already used.
+	VerifyEmail(ctx context.Context, userID, tokenHash string) error
+
+	// SetLoginOTP records a login code, replacing any earlier one.
+	SetLoginOTP(ctx context.Context, userID string, otp models.LoginOTP) error
+	// RecordOTPAttempt counts a guess at the outstanding login code and
+	// returns the guesses made so far.
+	RecordOTPAttempt(ctx context.Context, userID string) (int, error)
+	// ConsumeLoginOTP uses up the login code with codeHash and marks the
+	// phone verified. It returns ErrNotFound if the code was already used.
+	ConsumeLoginOTP(ctx context.Context, userID, codeHash string) error
+
+	// SetTwoFactor stores the user's second factor, replacing any earlier
+	// one; nil removes it.
+	SetTwoFactor(ctx context.Context, userID string, twoFactor *models.TwoFactor) error
+	// UseTOTPStep records that a code from step was accepted. It returns
+	// ErrNotFound if a code from that step or a later one already was.
+	UseTOTPStep(ctx context.Context, userID string, step int64) error
+	// UseRecoveryCode removes the recovery code with codeHash. It returns
+	// ErrNotFound if there is no such unused code.
+	UseRecoveryCode(ctx context.Context, userID, codeHash string) error
+	UpdateProfile(ctx context.Context, userID string, update ProfileUpdate) error
+
+	// AddSession records a new login, dropping the oldest sessions beyond
+	// MaxSessions.
+	AddSession(ctx context.Context, userID string, session models.Session) error
+	// TouchSession sets the session's last seen time. It returns
+	// ErrNotFound if there is no such session.
+	TouchSession(ctx context.Context, userID, sessionID string, at time.Time) error
+	// RotateRefresh moves the session from refresh token oldID to newID and
+	// sets its last seen time. It returns ErrNotFound if there is no such
+	// session or its refresh token is no longer oldID.
+	RotateRefresh(ctx context.Context, userID, sessionID, oldID, newID string, at time.Time) error
+	// RevokeSession removes the session, so its tokens stop working. It
+	// returns ErrNotFound if there is no such session.
+	RevokeSession(ctx context.Context, userID, sessionID string) error
+
+	// LinkIdentity links a provider account to the user. takeOver is for an
+	// account whose unverified address the provider has just shown the user
+	// owns: whoever registered it may not be them, so it also marks the
+	// email verified, clears the password and revokes every token and
+	// session. It returns ErrDuplicate if the provider account is linked
+	// to a user already.
+	LinkIdentity(ctx context.Context, userID string, identity models.Identity, takeOver bool) error
+
+	AddAddress(ctx context.Context, userID string, address models.Address) error
+	SetAddresses(ctx context.Context, userID string, addresses []models.Address) error
+
+	SetCart(ctx context.Context, userID string, cart []models.ProductUser) error
+
+	// PlaceOrder appends the order and empties the cart in one write, as
+	// long as the cart still holds exactly cart. Otherwise, for example
+	// when another checkout got there first, it returns ErrNotFound.
+	PlaceOrder(ctx context.Context, userID string, cart []models.ProductUser, order models.Order) error
+	RemoveOrder(ctx context.Context, userID string, orderID primitive.ObjectID) error
+	// SetOrderGatewayID records the gateway order an order is paid through.
+	SetOrderGatewayID(ctx context.Context, userID string, orderID primitive.ObjectID, gatewayOrderID string) error
+	// MarkOrderPaid moves the pending order for a gateway order ID to paid. It
+	// reports false when no pending order matched.
+	MarkOrderPaid(ctx context.Context, userID, gatewayOrderID, paymentID string) (bool, error)
+}
+
+// ProductRepository stores the product catalogue.
+type ProductRepository interface {
+	FindAll(ctx context.Context) ([]models.Product, error)
+	// FindByID looks a product up by its Mongo ID or its product_id.
+	FindByID(ctx context.Context, id string) (*models.Product, error)
+	SearchByName(ctx context.Context, query string) ([]models.Product, error)
+	InsertMany(ctx context.Context, products []models.Product) error
+
+	// ReserveStock decrements stock when at least qty is available. It
+	// reports false when there isn't enough.
+	ReserveStock(ctx context.Context, id primitive.ObjectID, qty int) (bool, error)
+	ReleaseStock(ctx context.Context, id primitive.ObjectID, qty int) error
+}
@@ -0,0 +1,86 @@
+package routes
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/models"
+)
+
+func (s *testServer) addresses(token string) []models.Address {
+	s.t.Helper()
+
+	rec := s.do(http.MethodGet, "/api/address", nil, token)
+	expectStatus(s.t, rec, http.StatusOK)
+
+	var resp struct {
+		Addresses []models.Address `json:"addresses"`
+	}
+	decode(s.t, rec, &resp)
+	return resp.Addresses
+}
+
+func TestAddressCRUD(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+
+	if got := s.addresses(token); len(got) != 0 {
+		t.Fatalf("new user has addresses %+v", got)
+	}
+
+	id := s.addAddress(token)
+
+	rec := s.do(http.MethodPut, "/api/address/"+id, gin.H{"city_name": "Navi Mumbai", "pin_code": "400703"}, token)
+	expectStatus(t, rec, http.StatusOK)
+
+	got := s.addresses(token)
+	if len(got) != 1 || got[0].CityName != "Navi Mumbai" || got[0].PinCode != "400703" || got[0].HouseName != "12 Sea View" {
+		t.Fatalf("addresses after update = %+v", got)
+	}
+
+	rec = s.do(http.MethodPut, "/api/address/"+id, gin.H{"pin_code": "4000"}, token)
+	expectError(t, rec, http.StatusBadRequest, apierror.CodeValidation)
+
+	rec = s.do(http.MethodPut, "/api/address/missing", gin.H{"city_name": "Pune"}, token)
+	expectError(t, rec, http.StatusNotFound, apierror.CodeAddressNotFound)
+
+	expectStatus(t, s.do(http.MethodDelete, "/api/address/"+id, nil, token), http.StatusOK)
+	if got := s.addresses(token); len(got) != 0 {
+		t.Fatalf("addresses after delete = %+v", got)
+	}
+}
+
+func TestAddAddressValidation(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+
+	tests := []struct {
+		name string
+		body interface{}
+	}{
+		{"missing fields", gin.H{"house_name": "12"}},
+		{"bad pin code", gin.H{"house_name": "12", "street_name": "MG Road", "city_name": "Pune", "pin_code": "012345"}},
+		{"malformed json", `{"house_name"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := s.do(http.MethodPost, "/api/address", tt.body, token)
+			expectError(t, rec, http.StatusBadRequest, apierror.CodeValidation)
+		})
+	}
+
+	// Postal codes outside India follow the address's country
+	rec := s.do(http.MethodPost, "/api/address", gin.H{
+		"house_name":  "1",
+		"street_name": "High Street",
+		"city_name":   "London",
+		"pin_code":    "SW1A 1AA",
+		"country":     "GB",
+	}, token)
+	expectStatus(t, rec, http.StatusOK)
+}
@@ -1,53 +1,110 @@
 package routes
 
 import (
+	"ecomm-backend/config"
 	"ecomm-backend/controllers"
 	"ecomm-backend/middleware"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(router *gin.Engine) {
+func SetupRoutes(router *gin.Engine, h *controllers.Handler) {
+	auth := middleware.Authenticate(h.Tokens, h.Users)
+
+	// Auth endpoints are limited per client IP, and login, password recovery
+	// and OTP login also per account tried, to slow down guessing and account
+	// enumeration
+	limits := h.Config.RateLimit
+	registerLimit := middleware.RateLimit(h.RateLimits, "register", limits.IP, middleware.ByIP)
+	loginLimit := middleware.RateLimit(h.RateLimits, "login", limits.IP, middleware.ByIP)
+	accountLimit := middleware.RateLimit(h.RateLimits, "login_account", limits.Account, middleware.ByAccount("email"))
+	forgotLimit := middleware.RateLimit(h.RateLimits, "forgot_password", limits.IP, middleware.ByIP)
+	forgotAccountLimit := middleware.RateLimit(h.RateLimits, "forgot_password_account", limits.Account, middleware.ByAccount("email"))
+	resetLimit := middleware.RateLimit(h.RateLimits, "reset_password", limits.IP, middleware.ByIP)
+	verifyLimit := middleware.RateLimit(h.RateLimits, "verify_email", limits.IP, middleware.ByIP)
+	otpRequestLimit := middleware.RateLimit(h.RateLimits, "otp_request", limits.IP, middleware.ByIP)
+	otpVerifyLimit := middleware.RateLimit(h.RateLimits, "otp_verify", limits.IP, middleware.ByIP)
+	otpAccountLimit := middleware.RateLimit(h.RateLimits, "otp_account", limits.Account, middleware.ByAccount("phone"))
+	twoFactorLimit := middleware.RateLimit(h.RateLimits, "two_factor", limits.IP, middleware.ByIP)
+	refreshLimit := middleware.RateLimit(h.RateLimits, "refresh", limits.IP, middleware.ByIP)
+	oidcStartLimit := middleware.RateLimit(h.RateLimits, "oidc_start", limits.IP, middleware.ByIP)
+	oidcCallbackLimit := middleware.RateLimit(h.RateLimits, "oidc_callback", limits.IP, middleware.ByIP)
+
+	// Actions configured to need a verified email address refuse users who
+	// haven't opened their verification link yet
+	verified := func(action string) gin.HandlerFunc {
+		if !h.Config.Auth.EmailVerification.Requires(action) {
+			return func(c *gin.Context) { c.Next() }
+		}
+		return middleware.RequireVerifiedEmail()
+	}
+	checkout := verified(config.ActionCheckout)
+	cart := verified(config.ActionCart)
+	address := verified(config.ActionAddress)
+
+	// Public keys for verifying tokens
+	router.GET("/.well-known/jwks.json", h.JWKS)
+
 	api := router.Group("/api")
 	{
 		// Auth routes (public)
-		api.POST("/auth/register", controllers.SignUp)
-		api.POST("/auth/login", controllers.Login)
-		api.POST("/auth/logout", controllers.Logout)
+		api.POST("/auth/register", registerLimit, h.SignUp)
+		api.POST("/auth/login", loginLimit, accountLimit, h.Login)
+		api.POST("/auth/logout", auth, h.Logout)
+		api.POST("/auth/refresh", refreshLimit, h.RefreshToken)
+		api.POST("/auth/forgot-password", forgotLimit, forgotAccountLimit, h.ForgotPassword)
+		api.POST("/auth/reset-password", resetLimit, h.ResetPassword)
+		api.GET("/auth/verify-email", verifyLimit, h.VerifyEmail)
+		api.POST("/auth/verify-email/resend", verifyLimit, auth, h.ResendVerificationEmail)
+		api.POST("/auth/otp/request", otpRequestLimit, otpAccountLimit, h.RequestOTP)
+		api.POST("/auth/otp/verify", otpVerifyLimit, otpAccountLimit, h.VerifyOTP)
+		api.POST("/auth/2fa/verify", twoFactorLimit, h.VerifyTwoFactor)
+		api.POST("/auth/2fa/enroll", twoFactorLimit, h.EnrollTwoFactorAtLogin)
+		api.POST("/auth/2fa/confirm", twoFactorLimit, h.ConfirmTwoFactorAtLogin)
+		api.GET("/auth/oidc/:provider", oidcStartLimit, h.OIDCLogin)
+		api.GET("/auth/oidc/:provider/callback", oidcCallbackLimit, h.OIDCCallback)
 
 		// Product routes (public)
-		api.GET("/products", controllers.GetAllProducts)
-		api.GET("/products/:id", controllers.GetProductById)
-		api.GET("/products/search", controllers.SearchProducts)
+		api.GET("/products", h.GetAllProducts)
+		api.GET("/products/:id", h.GetProductById)
+		api.GET("/products/search", h.SearchProducts)
 
 		// Cart routes (protected - require authentication)
-		api.GET("/cart", middleware.Authenticate(), controllers.GetCart)
-		api.POST("/cart", middleware.Authenticate(), controllers.AddToCart)
-		api.PUT("/cart/items/:id", middleware.Authenticate(), controllers.UpdateCartItem)
-		api.DELETE("/cart/:id", middleware.Authenticate(), controllers.RemoveFromCart)
-		api.DELETE("/cart", middleware.Authenticate(), controllers.ClearCart)
+		api.GET("/cart", auth, h.GetCart)
+		api.POST("/cart", auth, cart, h.AddToCart)
+		api.GET("/cart/shipping-options", auth, h.GetShippingOptions)
+		api.PUT("/cart/items/:id", auth, cart, h.UpdateCartItem)
+		api.DELETE("/cart/:id", auth, cart, h.RemoveFromCart)
+		api.DELETE("/cart", auth, cart, h.ClearCart)
 
 		// Checkout route (protected)
-		api.POST("/checkout", middleware.Authenticate(), controllers.Checkout)
+		api.POST("/checkout", auth, checkout, h.Checkout)
 
 		// User routes (protected)
-		api.GET("/user/profile", middleware.Authenticate(), controllers.GetProfile)
-		api.PUT("/user/profile", middleware.Authenticate(), controllers.UpdateProfile)
+		api.GET("/user/profile", auth, h.GetProfile)
+		api.PUT("/user/profile", auth, h.UpdateProfile)
+		api.GET("/user/2fa", auth, h.GetTwoFactor)
+		api.POST("/user/2fa/enroll", auth, h.EnrollTwoFactor)
+		api.POST("/user/2fa/confirm", auth, h.ConfirmTwoFactor)
+		api.POST("/user/2fa/recovery-codes", auth, h.RegenerateRecoveryCodes)
+		api.DELETE("/user/2fa", auth, h.DisableTwoFactor)
+		api.GET("/user/sessions", auth, h.GetSessions)
+		api.DELETE("/user/sessions/:id", auth, h.RevokeSession)
 
 		// Address routes (protected)
-		api.GET("/address", middleware.Authenticate(), controllers.GetAddresses)
-		api.POST("/address", middleware.Authenticate(), controllers.AddAddress)
-		api.PUT("/address/:id", middleware.Authenticate(), controllers.UpdateAddress)
-		api.DELETE("/address/:id", middleware.Authenticate(), controllers.DeleteAddress)
+		api.GET("/address", auth, h.GetAddresses)
+		api.POST("/address", auth, address, h.AddAddress)
+		api.PUT("/address/:id", auth, address, h.UpdateAddress)
+		api.DELETE("/address/:id", auth, address, h.DeleteAddress)
 
 		// Order routes (protected)
-		api.GET("/orders", middleware.Authenticate(), controllers.GetOrders)
-		api.GET("/orders/:id", middleware.Authenticate(), controllers.GetOrderById)
+		api.GET("/orders", auth, h.GetOrders)
+		api.GET("/orders/:id", auth, h.GetOrderById)
+		api.GET("/orders/:id/invoice", auth, h.GetOrderInvoice)
 
 		// Payment routes (protected) - Mock endpoints for frontend compatibility
-		api.POST("/payment/create-order", middleware.Authenticate(), controllers.CreatePaymentOrder)
-		api.POST("/payment/verify", middleware.Authenticate(), controllers.VerifyPayment)
-		api.GET("/payment/:id", middleware.Authenticate(), controllers.GetPaymentStatus)
+		api.POST("/payment/create-order", auth, checkout, h.CreatePaymentOrder)
+		api.POST("/payment/verify", auth, checkout, h.VerifyPayment)
+		api.GET("/payment/:id", auth, h.GetPaymentStatus)
 	}
 }
-
@@ -0,0 +1,336 @@
+package routes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/config"
+	"ecomm-backend/models"
+	"ecomm-backend/ratelimit"
+)
+
+func TestSignUpAndLogin(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+
+	signup := gin.H{
+		"first_name": "Asha",
+		"last_name":  "Rao",
+		"email":      "Asha@Example.com",
+		"password":   "secret123",
+		"phone":      "+919800000001",
+	}
+	expectStatus(t, s.do(http.MethodPost, "/api/auth/register", signup, ""), http.StatusCreated)
+
+	// Taken emails and phones look like a fresh signup, but the existing
+	// account keeps its password
+	for name, change := range map[string]gin.H{
+		"duplicate email": {"email": "asha@example.com", "phone": "+919800000002"},
+		"duplicate phone": {"email": "other@example.com"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dup := gin.H{}
+			for k, v := range signup {
+				dup[k] = v
+			}
+			for k, v := range change {
+				dup[k] = v
+			}
+			dup["password"] = "attacker1"
+
+			rec := s.do(http.MethodPost, "/api/auth/register", dup, "")
+			expectStatus(t, rec, http.StatusCreated)
+			if rec.Body.String() != `{"message":"Successfully Signed Up!!"}` {
+				t.Fatalf("body = %s, want the usual signup response", rec.Body.String())
+			}
+			rec = s.do(http.MethodPost, "/api/auth/login", gin.H{"email": dup["email"], "password": "attacker1"}, "")
+			expectError(t, rec, http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+		})
+	}
+
+	t.Run("login is case-insensitive on email", func(t *testing.T) {
+		rec := s.do(http.MethodPost, "/api/auth/login", gin.H{"email": "ASHA@example.com", "password": "secret123"}, "")
+		expectStatus(t, rec, http.StatusOK)
+
+		var user models.User
+		decode(t, rec, &user)
+		if user.Token == "" || user.RefreshToken == "" {
+			t.Fatalf("expected tokens, got %+v", user)
+		}
+		if user.Email != "asha@example.com" {
+			t.Fatalf("email = %q", user.Email)
+		}
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		rec := s.do(http.MethodPost, "/api/auth/login", gin.H{"email": "asha@example.com", "password": "wrong-pass"}, "")
+		expectError(t, rec, http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+	})
+
+	t.Run("unknown email", func(t *testing.T) {
+		rec := s.do(http.MethodPost, "/api/auth/login", gin.H{"email": "nobody@example.com", "password": "secret123"}, "")
+		expectError(t, rec, http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+	})
+}
+
+func TestLoginLockout(t *testing.T) {
+	t.Parallel()
+	// Without the per-account limit, which would otherwise answer first
+	s := newTestServer(t, func(cfg *config.Config) { cfg.RateLimit.Account = ratelimit.Limit{} })
+	var profile models.User
+	decode(t, s.do(http.MethodGet, "/api/user/profile", nil, s.signUp()), &profile)
+	email := profile.Email
+
+	login := func(password string) *httptest.ResponseRecorder {
+		return s.do(http.MethodPost, "/api/auth/login", gin.H{"email": email, "password": password}, "")
+	}
+
+	// Below the threshold a correct password clears the count
+	for i := 0; i < 4; i++ {
+		expectError(t, login("wrong-pass"), http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+	}
+	expectStatus(t, login("secret123"), http.StatusOK)
+
+	for i := 0; i < 5; i++ {
+		expectError(t, login("wrong-pass"), http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+	}
+	// A locked account answers like an unknown one, even to the right password
+	locked := login("secret123")
+	unknown := s.do(http.MethodPost, "/api/auth/login", gin.H{"email": "nobody-" + email, "password": "secret123"}, "")
+	expectError(t, locked, http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+	expectError(t, unknown, http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+	var lockedBody, unknownBody apierror.Body
+	decode(t, locked, &lockedBody)
+	decode(t, unknown, &unknownBody)
+	if locked.Header().Get("Retry-After") != "" || lockedBody.Error != unknownBody.Error {
+		t.Fatalf("locked answered %s (Retry-After %q), unknown %s", locked.Body, locked.Header().Get("Retry-After"), unknown.Body)
+	}
+
+	// Once the lock expires the account works again
+	user, err := s.users.FindByEmail(context.Background(), email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.users.LockUntil(context.Background(), user.UserID, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, login("secret123"), http.StatusOK)
+}
+
+func TestAuthRateLimits(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+
+	// Per account, whether or not it exists
+	for i := 0; i < 10; i++ {
+		rec := s.do(http.MethodPost, "/api/auth/login", gin.H{"email": "ghost@example.com", "password": "secret123"}, "")
+		expectError(t, rec, http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+	}
+	rec := s.do(http.MethodPost, "/api/auth/login", gin.H{"email": "GHOST@example.com", "password": "secret123"}, "")
+	expectError(t, rec, http.StatusTooManyRequests, apierror.CodeRateLimited)
+
+	// Per IP, across accounts
+	for i := 0; i < 9; i++ {
+		rec := s.do(http.MethodPost, "/api/auth/login", gin.H{"email": fmt.Sprintf("ghost%d@example.com", i), "password": "secret123"}, "")
+		expectError(t, rec, http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+	}
+	rec = s.do(http.MethodPost, "/api/auth/login", gin.H{"email": "someone@example.com", "password": "secret123"}, "")
+	expectError(t, rec, http.StatusTooManyRequests, apierror.CodeRateLimited)
+
+	// Other routes have their own buckets
+	expectStatus(t, s.do(http.MethodPost, "/api/auth/register", gin.H{
+		"first_name": "Asha", "last_name": "Rao", "email": "asha@example.com", "password": "secret123", "phone": "+919800000001",
+	}, ""), http.StatusCreated)
+}
+
+func TestAccountLimitMatchesBinding(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, func(cfg *config.Config) { cfg.RateLimit.IP = ratelimit.Limit{} })
+
+	for i := 0; i < 10; i++ {
+		rec := s.do(http.MethodPost, "/api/auth/login", gin.H{"email": "ghost@example.com", "password": "secret123"}, "")
+		expectError(t, rec, http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+	}
+
+	// The handler logs in as the last key that matches case-insensitively,
+	// so that is the account the attempt counts against
+	body := `{"email":"decoy@example.com","password":"secret123","Email":"ghost@example.com"}`
+	expectError(t, s.do(http.MethodPost, "/api/auth/login", body, ""), http.StatusTooManyRequests, apierror.CodeRateLimited)
+}
+
+func TestForwardedForNotTrusted(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, func(cfg *config.Config) { cfg.RateLimit.Account = ratelimit.Limit{} })
+
+	// Without trusted proxies a client can't pick its own bucket
+	login := func(forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"email":"ghost@example.com","password":"secret123"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		return rec
+	}
+	for i := 0; i < 20; i++ {
+		expectError(t, login(fmt.Sprintf("203.0.113.%d", i)), http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+	}
+	expectError(t, login("198.51.100.1"), http.StatusTooManyRequests, apierror.CodeRateLimited)
+}
+
+func TestAuthBodyLimit(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+
+	// The per-account limit reads the body before anything else does
+	body := `{"email":"` + strings.Repeat("a", 64<<10) + `@example.com","password":"secret123"}`
+	expectError(t, s.do(http.MethodPost, "/api/auth/login", body, ""), http.StatusRequestEntityTooLarge, apierror.CodeRequestTooLarge)
+}
+
+func TestSignUpValidation(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+
+	tests := []struct {
+		name string
+		body interface{}
+	}{
+		{"empty body", nil},
+		{"malformed json", `{"email":`},
+		{"wrong type", `{"first_name": 12}`},
+		{"missing fields", gin.H{"email": "a@example.com"}},
+		{"invalid email", gin.H{"first_name": "Asha", "last_name": "Rao", "email": "nope", "password": "secret123", "phone": "+919800000001"}},
+		{"short password", gin.H{"first_name": "Asha", "last_name": "Rao", "email": "a@example.com", "password": "123", "phone": "+919800000001"}},
+		{"local phone", gin.H{"first_name": "Asha", "last_name": "Rao", "email": "a@example.com", "password": "secret123", "phone": "9800000001"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := s.do(http.MethodPost, "/api/auth/register", tt.body, "")
+			expectError(t, rec, http.StatusBadRequest, apierror.CodeValidation)
+		})
+	}
+
+	t.Run("field details", func(t *testing.T) {
+		rec := s.do(http.MethodPost, "/api/auth/register", gin.H{"email": "nope"}, "")
+		var body struct {
+			Details []apierror.FieldError `json:"details"`
+		}
+		decode(t, rec, &body)
+
+		fields := map[string]bool{}
+		for _, d := range body.Details {
+			fields[d.Field] = true
+		}
+		for _, f := range []string{"first_name", "last_name", "email", "password", "phone"} {
+			if !fields[f] {
+				t.Errorf("missing detail for %s in %+v", f, body.Details)
+			}
+		}
+	})
+}
+
+func TestLogout(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	other, email := s.signUpUnverified()
+
+	rec := s.do(http.MethodPost, "/api/auth/login", gin.H{"email": email, "password": "secret123"}, "")
+	expectStatus(t, rec, http.StatusOK)
+	var user models.User
+	decode(t, rec, &user)
+
+	expectError(t, s.do(http.MethodPost, "/api/auth/logout", nil, ""), http.StatusUnauthorized, apierror.CodeTokenMissing)
+	expectStatus(t, s.do(http.MethodPost, "/api/auth/logout", nil, user.Token), http.StatusOK)
+
+	// Only the session logged out of ends, tokens and all
+	expectError(t, s.do(http.MethodGet, "/api/user/profile", nil, user.Token), http.StatusUnauthorized, apierror.CodeTokenRevoked)
+	expectError(t, s.do(http.MethodPost, "/api/auth/refresh", gin.H{"refresh_token": user.RefreshToken}, ""), http.StatusUnauthorized, apierror.CodeTokenRevoked)
+	expectStatus(t, s.do(http.MethodGet, "/api/user/profile", nil, other), http.StatusOK)
+}
+
+func TestProtectedRoutesRequireToken(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+
+	routes := []struct {
+		method, path string
+	}{
+		{http.MethodGet, "/api/cart"},
+		{http.MethodPost, "/api/cart"},
+		{http.MethodGet, "/api/cart/shipping-options"},
+		{http.MethodPut, "/api/cart/items/x"},
+		{http.MethodDelete, "/api/cart/x"},
+		{http.MethodDelete, "/api/cart"},
+		{http.MethodPost, "/api/checkout"},
+		{http.MethodGet, "/api/user/profile"},
+		{http.MethodPut, "/api/user/profile"},
+		{http.MethodGet, "/api/address"},
+		{http.MethodPost, "/api/address"},
+		{http.MethodPut, "/api/address/x"},
+		{http.MethodDelete, "/api/address/x"},
+		{http.MethodGet, "/api/orders"},
+		{http.MethodGet, "/api/orders/x"},
+		{http.MethodGet, "/api/orders/x/invoice"},
+		{http.MethodPost, "/api/payment/create-order"},
+		{http.MethodPost, "/api/payment/verify"},
+		{http.MethodGet, "/api/payment/x"},
+	}
+
+	for _, r := range routes {
+		t.Run(r.method+" "+r.path, func(t *testing.T) {
+			expectError(t, s.do(r.method, r.path, nil, ""), http.StatusUnauthorized, apierror.CodeTokenMissing)
+			expectError(t, s.do(r.method, r.path, nil, "not-a-jwt"), http.StatusUnauthorized, apierror.CodeTokenInvalid)
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+
+	rec := s.doWithHeader(http.MethodGet, "/api/user/profile", "Authorization", "Bearer "+token)
+	expectStatus(t, rec, http.StatusOK)
+}
+
+func TestProfile(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+
+	rec := s.do(http.MethodGet, "/api/user/profile", nil, token)
+	expectStatus(t, rec, http.StatusOK)
+	if body := rec.Body.String(); containsAny(body, "password", "secret123") {
+		t.Fatalf("profile leaks the password: %s", body)
+	}
+
+	rec = s.do(http.MethodPut, "/api/user/profile", gin.H{}, token)
+	expectError(t, rec, http.StatusBadRequest, apierror.CodeBadRequest)
+
+	rec = s.do(http.MethodPut, "/api/user/profile", gin.H{"phone": "12345"}, token)
+	expectError(t, rec, http.StatusBadRequest, apierror.CodeValidation)
+
+	rec = s.do(http.MethodPut, "/api/user/profile", gin.H{"first_name": "Meera"}, token)
+	expectStatus(t, rec, http.StatusOK)
+
+	var user models.User
+	decode(t, s.do(http.MethodGet, "/api/user/profile", nil, token), &user)
+	if user.FirstName != "Meera" || user.LastName != "User" {
+		t.Fatalf("profile = %s %s, want Meera User", user.FirstName, user.LastName)
+	}
+}
+
+func TestUnknownRoute(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+
+	expectError(t, s.do(http.MethodGet, "/api/nope", nil, ""), http.StatusNotFound, apierror.CodeRouteNotFound)
+}
@@ -0,0 +1,148 @@
+package routes
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/models"
+)
+
+type cartResponse struct {
+	Items    []models.ProductUser   `json:"items"`
+	Subtotal float64                `json:"subtotal"`
+	Tax      *models.TaxBreakdown   `json:"tax"`
+	Shipping *models.ShippingOption `json:"shipping"`
+	Total    float64                `json:"total"`
+}
+
+func (s *testServer) cart(token string) cartResponse {
+	s.t.Helper()
+
+	rec := s.do(http.MethodGet, "/api/cart", nil, token)
+	expectStatus(s.t, rec, http.StatusOK)
+
+	var cart cartResponse
+	decode(s.t, rec, &cart)
+	return cart
+}
+
+func TestCartLifecycle(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+	lamp := s.seedProduct("Desk Lamp", 200, nil)
+	chair := s.seedProduct("Office Chair", 1000, nil)
+
+	if cart := s.cart(token); len(cart.Items) != 0 || cart.Total != 0 || cart.Shipping != nil {
+		t.Fatalf("new cart = %+v, want empty", cart)
+	}
+
+	// Adding the same product twice bumps its quantity
+	expectStatus(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": lamp.ID.Hex()}, token), http.StatusOK)
+	expectStatus(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": lamp.ProductID, "qty": 2}, token), http.StatusOK)
+	expectStatus(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": chair.ProductID}, token), http.StatusOK)
+
+	cart := s.cart(token)
+	if len(cart.Items) != 2 || cart.Items[0].Quantity != 3 {
+		t.Fatalf("cart items = %+v, want lamp x3 and chair", cart.Items)
+	}
+	if cart.Subtotal != 1600 {
+		t.Fatalf("subtotal = %v, want 1600", cart.Subtotal)
+	}
+	if cart.Tax == nil || cart.Tax.TotalTax != 288 {
+		t.Fatalf("tax = %+v, want 18%% GST of 288", cart.Tax)
+	}
+	if cart.Shipping == nil || !cart.Shipping.Free {
+		t.Fatalf("shipping = %+v, want free standard delivery", cart.Shipping)
+	}
+	if cart.Total != 1888 {
+		t.Fatalf("total = %v, want 1888", cart.Total)
+	}
+
+	rec := s.do(http.MethodPut, "/api/cart/items/"+lamp.ProductID, gin.H{"quantity": 1}, token)
+	expectStatus(t, rec, http.StatusOK)
+	if cart := s.cart(token); cart.Items[0].Quantity != 1 {
+		t.Fatalf("lamp quantity = %d, want 1", cart.Items[0].Quantity)
+	}
+
+	expectStatus(t, s.do(http.MethodDelete, "/api/cart/"+lamp.ProductID, nil, token), http.StatusOK)
+	if cart := s.cart(token); len(cart.Items) != 1 || cart.Items[0].ProductID != chair.ProductID {
+		t.Fatalf("cart after removal = %+v, want only the chair", cart.Items)
+	}
+
+	expectStatus(t, s.do(http.MethodDelete, "/api/cart", nil, token), http.StatusOK)
+	if cart := s.cart(token); len(cart.Items) != 0 {
+		t.Fatalf("cart after clear = %+v, want empty", cart.Items)
+	}
+}
+
+func TestCartErrors(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+	lamp := s.seedProduct("Desk Lamp", 200, nil)
+
+	rec := s.do(http.MethodPost, "/api/cart", gin.H{"productId": "missing"}, token)
+	expectError(t, rec, http.StatusNotFound, apierror.CodeProductNotFound)
+
+	rec = s.do(http.MethodPost, "/api/cart", gin.H{}, token)
+	expectError(t, rec, http.StatusBadRequest, apierror.CodeValidation)
+
+	rec = s.do(http.MethodPost, "/api/cart", gin.H{"productId": lamp.ProductID, "qty": 500}, token)
+	expectError(t, rec, http.StatusBadRequest, apierror.CodeValidation)
+
+	rec = s.do(http.MethodPut, "/api/cart/items/"+lamp.ProductID, gin.H{"quantity": 1}, token)
+	expectError(t, rec, http.StatusNotFound, apierror.CodeCartItemNotFound)
+
+	rec = s.do(http.MethodPut, "/api/cart/items/"+lamp.ProductID, gin.H{"quantity": 0}, token)
+	expectError(t, rec, http.StatusBadRequest, apierror.CodeValidation)
+
+	rec = s.do(http.MethodGet, "/api/cart?address=missing", nil, token)
+	expectError(t, rec, http.StatusNotFound, apierror.CodeAddressNotFound)
+}
+
+func TestShippingOptions(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+	lamp := s.seedProduct("Desk Lamp", 200, nil)
+
+	rec := s.do(http.MethodGet, "/api/cart/shipping-options", nil, token)
+	expectError(t, rec, http.StatusBadRequest, apierror.CodeCartEmpty)
+
+	expectStatus(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": lamp.ProductID}, token), http.StatusOK)
+
+	rec = s.do(http.MethodGet, "/api/cart/shipping-options", nil, token)
+	expectError(t, rec, http.StatusBadRequest, apierror.CodeAddressNotFound)
+
+	addressID := s.addAddress(token)
+	rec = s.do(http.MethodGet, "/api/cart/shipping-options?address="+addressID, nil, token)
+	expectStatus(t, rec, http.StatusOK)
+
+	var resp struct {
+		Zone    string                  `json:"zone"`
+		Options []models.ShippingOption `json:"options"`
+	}
+	decode(t, rec, &resp)
+	if resp.Zone != "local" {
+		t.Fatalf("zone = %q, want local", resp.Zone)
+	}
+	if len(resp.Options) != 2 {
+		t.Fatalf("options = %+v, want standard and express", resp.Options)
+	}
+
+	// The cart quotes the selected option
+	rec = s.do(http.MethodGet, "/api/cart?shipping=express", nil, token)
+	expectStatus(t, rec, http.StatusOK)
+	var cart cartResponse
+	decode(t, rec, &cart)
+	if cart.Shipping == nil || cart.Shipping.ID != "express" || cart.Shipping.Cost == 0 {
+		t.Fatalf("shipping = %+v, want paid express delivery", cart.Shipping)
+	}
+
+	rec = s.do(http.MethodGet, "/api/cart?shipping=teleport", nil, token)
+	expectError(t, rec, http.StatusBadRequest, apierror.CodeShippingOption)
+}
@@ -0,0 +1,419 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/models"
+	"ecomm-backend/payments"
+	"ecomm-backend/repository"
+)
+
+type checkoutResponse struct {
+	OrderID  string                 `json:"order_id"`
+	Status   string                 `json:"status"`
+	Subtotal float64                `json:"subtotal"`
+	Total    float64                `json:"total"`
+	Shipping *models.ShippingOption `json:"shipping"`
+	Payment  *payments.Order        `json:"payment"`
+}
+
+func (s *testServer) orders(token string) []models.Order {
+	s.t.Helper()
+
+	rec := s.do(http.MethodGet, "/api/orders", nil, token)
+	expectStatus(s.t, rec, http.StatusOK)
+
+	var resp struct {
+		Orders []models.Order `json:"orders"`
+	}
+	decode(s.t, rec, &resp)
+	return resp.Orders
+}
+
+func TestCheckoutCOD(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+	lamp := s.seedProduct("Desk Lamp", 200, intPtr(5))
+	addressID := s.addAddress(token)
+
+	expectStatus(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": lamp.ProductID, "qty": 2}, token), http.StatusOK)
+
+	rec := s.do(http.MethodPost, "/api/checkout", gin.H{
+		"address_id":     addressID,
+		"payment_method": "cod",
+	}, token)
+	expectStatus(t, rec, http.StatusOK)
+
+	var receipt checkoutResponse
+	decode(t, rec, &receipt)
+	if receipt.Status != models.OrderStatusConfirmed || receipt.Payment != nil {
+		t.Fatalf("receipt = %+v, want confirmed without payment", receipt)
+	}
+	// 400 + 18% GST + local standard delivery for 1kg
+	if receipt.Subtotal != 400 || receipt.Total != 532 {
+		t.Fatalf("subtotal/total = %v/%v, want 400/532", receipt.Subtotal, receipt.Total)
+	}
+
+	product, _ := s.products.FindByID(context.Background(), lamp.ProductID)
+	if *product.Stock != 3 {
+		t.Fatalf("stock = %d, want 3", *product.Stock)
+	}
+	if cart := s.cart(token); len(cart.Items) != 0 {
+		t.Fatalf("cart after checkout = %+v, want empty", cart.Items)
+	}
+
+	orders := s.orders(token)
+	if len(orders) != 1 || orders[0].ID.Hex() != receipt.OrderID {
+		t.Fatalf("orders = %+v, want the placed order", orders)
+	}
+	if orders[0].DeliveryAddress == nil || orders[0].DeliveryAddress.ID.Hex() != addressID {
+		t.Fatalf("delivery address = %+v", orders[0].DeliveryAddress)
+	}
+
+	rec = s.do(http.MethodGet, "/api/orders/"+receipt.OrderID, nil, token)
+	expectStatus(t, rec, http.StatusOK)
+
+	rec = s.do(http.MethodGet, "/api/orders/"+receipt.OrderID+"/invoice", nil, token)
+	expectStatus(t, rec, http.StatusOK)
+	var invoice struct {
+		Invoice struct {
+			Number string  `json:"invoice_number"`
+			Total  float64 `json:"total"`
+		} `json:"invoice"`
+	}
+	decode(t, rec, &invoice)
+	if invoice.Invoice.Number != "INV-"+receipt.OrderID || invoice.Invoice.Total != receipt.Total {
+		t.Fatalf("invoice = %+v", invoice.Invoice)
+	}
+
+	expectError(t, s.do(http.MethodGet, "/api/orders/missing", nil, token), http.StatusNotFound, apierror.CodeOrderNotFound)
+	expectError(t, s.do(http.MethodGet, "/api/orders/missing/invoice", nil, token), http.StatusNotFound, apierror.CodeOrderNotFound)
+}
+
+func TestCheckoutPricesServerCart(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+	lamp := s.seedProduct("Desk Lamp", 200, intPtr(5))
+	chair := s.seedProduct("Office Chair", 1000, intPtr(5))
+	addressID := s.addAddress(token)
+
+	expectStatus(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": lamp.ProductID, "qty": 2}, token), http.StatusOK)
+
+	// A cart line priced before the product's price changed
+	user := s.profile(token)
+	cart := []models.ProductUser{{ProductID: lamp.ProductID, ProductName: lamp.ProductName, Price: 1, Quantity: 2}}
+	if err := s.users.SetCart(context.Background(), user.UserID, cart); err != nil {
+		t.Fatal(err)
+	}
+
+	// Items in the request are not the cart
+	rec := s.do(http.MethodPost, "/api/checkout", gin.H{
+		"address_id":     addressID,
+		"payment_method": "cod",
+		"cartItems":      []gin.H{{"product_id": chair.ProductID, "price": 1, "quantity": 1}},
+	}, token)
+	expectStatus(t, rec, http.StatusOK)
+
+	var receipt checkoutResponse
+	decode(t, rec, &receipt)
+	if receipt.Subtotal != 400 || receipt.Total != 532 {
+		t.Fatalf("subtotal/total = %v/%v, want 400/532", receipt.Subtotal, receipt.Total)
+	}
+	orders := s.orders(token)
+	if len(orders) != 1 || len(orders[0].OrderList) != 1 || orders[0].OrderList[0].Price != 200 {
+		t.Fatalf("orders = %+v, want the lamp at 200", orders)
+	}
+	if product, _ := s.products.FindByID(context.Background(), chair.ProductID); *product.Stock != 5 {
+		t.Fatalf("chair stock = %d, want 5", *product.Stock)
+	}
+}
+
+func TestCheckoutDigitalPayment(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+	lamp := s.seedProduct("Desk Lamp", 600, nil)
+	addressID := s.addAddress(token)
+
+	expectStatus(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": lamp.ProductID}, token), http.StatusOK)
+
+	rec := s.do(http.MethodPost, "/api/checkout", gin.H{
+		"address_id":      addressID,
+		"payment_method":  "digital",
+		"shipping_option": "express",
+	}, token)
+	expectStatus(t, rec, http.StatusOK)
+
+	var receipt checkoutResponse
+	decode(t, rec, &receipt)
+	if receipt.Status != models.OrderStatusPendingPayment || receipt.Payment == nil {
+		t.Fatalf("receipt = %+v, want pending payment with a gateway order", receipt)
+	}
+	if receipt.Shipping == nil || receipt.Shipping.ID != "express" {
+		t.Fatalf("shipping = %+v, want express", receipt.Shipping)
+	}
+	if receipt.Payment.Amount != int64(receipt.Total*100+0.5) {
+		t.Fatalf("payment amount = %d paise, want %v rupees", receipt.Payment.Amount, receipt.Total)
+	}
+
+	rec = s.do(http.MethodPost, "/api/payment/verify", gin.H{
+		"razorpay_order_id":   receipt.Payment.ID,
+		"razorpay_payment_id": "pay_123",
+		"razorpay_signature":  "sig",
+	}, token)
+	expectStatus(t, rec, http.StatusOK)
+
+	var verified struct {
+		Success bool   `json:"success"`
+		Status  string `json:"status"`
+	}
+	decode(t, rec, &verified)
+	if !verified.Success || verified.Status != models.OrderStatusPaid {
+		t.Fatalf("verify = %+v, want paid", verified)
+	}
+
+	orders := s.orders(token)
+	if orders[0].Status != models.OrderStatusPaid || orders[0].RazorpayPaymentID != "pay_123" {
+		t.Fatalf("order = %+v, want paid with pay_123", orders[0])
+	}
+
+	// A second verification finds the order already paid
+	expectError(t, s.do(http.MethodPost, "/api/payment/verify", gin.H{
+		"razorpay_order_id":   receipt.Payment.ID,
+		"razorpay_payment_id": "pay_123",
+		"razorpay_signature":  "sig",
+	}, token), http.StatusConflict, apierror.CodeOrderNotPending)
+
+	// A gateway order the user has no order for is unknown
+	expectError(t, s.do(http.MethodPost, "/api/payment/verify", gin.H{
+		"razorpay_order_id":   "order_unknown",
+		"razorpay_payment_id": "pay_456",
+		"razorpay_signature":  "sig",
+	}, token), http.StatusNotFound, apierror.CodeOrderNotFound)
+}
+
+func TestCheckoutOutOfStockRollsBack(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+	lamp := s.seedProduct("Desk Lamp", 200, intPtr(5))
+	chair := s.seedProduct("Office Chair", 1000, intPtr(1))
+	addressID := s.addAddress(token)
+
+	expectStatus(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": lamp.ProductID, "qty": 2}, token), http.StatusOK)
+	expectStatus(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": chair.ProductID, "qty": 3}, token), http.StatusOK)
+
+	rec := s.do(http.MethodPost, "/api/checkout", gin.H{"address_id": addressID, "payment_method": "cod"}, token)
+	expectError(t, rec, http.StatusConflict, apierror.CodeOutOfStock)
+
+	// The lamp reservation is released and nothing else changed
+	product, _ := s.products.FindByID(context.Background(), lamp.ProductID)
+	if *product.Stock != 5 {
+		t.Fatalf("lamp stock = %d, want 5", *product.Stock)
+	}
+	if len(s.orders(token)) != 0 {
+		t.Fatal("order was placed despite missing stock")
+	}
+	if cart := s.cart(token); len(cart.Items) != 2 {
+		t.Fatalf("cart = %+v, want both items kept", cart.Items)
+	}
+}
+
+// stockBarrier holds every stock reservation until n have been asked for,
+// so that many checkouts have all read the cart before any places an order.
+type stockBarrier struct {
+	repository.ProductRepository
+	arrived sync.WaitGroup
+	ready   chan struct{}
+}
+
+func newStockBarrier(products repository.ProductRepository, n int) *stockBarrier {
+	b := &stockBarrier{ProductRepository: products, ready: make(chan struct{})}
+	b.arrived.Add(n)
+	go func() {
+		b.arrived.Wait()
+		close(b.ready)
+	}()
+	return b
+}
+
+func (b *stockBarrier) ReserveStock(ctx context.Context, id primitive.ObjectID, qty int) (bool, error) {
+	b.arrived.Done()
+	select {
+	case <-b.ready:
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+	return b.ProductRepository.ReserveStock(ctx, id, qty)
+}
+
+func TestConcurrentCheckoutsOrderOnce(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+	lamp := s.seedProduct("Desk Lamp", 200, intPtr(10))
+	addressID := s.addAddress(token)
+
+	expectStatus(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": lamp.ProductID, "qty": 2}, token), http.StatusOK)
+
+	// The same cart checked out from several tabs at once
+	const tabs = 4
+	s.handler.Products = newStockBarrier(s.products, tabs)
+	body := `{"address_id":"` + addressID + `","payment_method":"cod"}`
+	start := make(chan struct{})
+	results := make(chan *httptest.ResponseRecorder, tabs)
+	var wg sync.WaitGroup
+	for i := 0; i < tabs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			results <- s.do(http.MethodPost, "/api/checkout", body, token)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(results)
+
+	placed := 0
+	for rec := range results {
+		switch rec.Code {
+		case http.StatusOK:
+			placed++
+		default:
+			expectError(t, rec, http.StatusConflict, apierror.CodeCartChanged)
+		}
+	}
+	if placed != 1 {
+		t.Fatalf("%d checkouts succeeded, want 1", placed)
+	}
+	if orders := s.orders(token); len(orders) != 1 {
+		t.Fatalf("%d orders placed, want 1", len(orders))
+	}
+	product, _ := s.products.FindByID(context.Background(), lamp.ProductID)
+	if *product.Stock != 8 {
+		t.Fatalf("stock = %d, want 8", *product.Stock)
+	}
+	if cart := s.cart(token); len(cart.Items) != 0 {
+		t.Fatalf("cart = %+v, want it emptied", cart.Items)
+	}
+}
+
+// failingGateway can't create payment orders.
+type failingGateway struct{ payments.Gateway }
+
+func (failingGateway) CreateOrder(ctx context.Context, amount float64, currency, receipt string) (*payments.Order, error) {
+	return nil, errors.New("gateway unavailable")
+}
+
+func TestCheckoutPaymentGatewayRollsBack(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	s.handler.Payments = failingGateway{}
+	token := s.signUp()
+	lamp := s.seedProduct("Desk Lamp", 200, intPtr(5))
+	addressID := s.addAddress(token)
+
+	expectStatus(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": lamp.ProductID, "qty": 2}, token), http.StatusOK)
+
+	rec := s.do(http.MethodPost, "/api/checkout", gin.H{"address_id": addressID, "payment_method": "digital"}, token)
+	expectError(t, rec, http.StatusBadGateway, apierror.CodePaymentGateway)
+
+	product, _ := s.products.FindByID(context.Background(), lamp.ProductID)
+	if *product.Stock != 5 {
+		t.Fatalf("stock = %d, want 5", *product.Stock)
+	}
+	if len(s.orders(token)) != 0 {
+		t.Fatal("order was placed without a payment order")
+	}
+	if cart := s.cart(token); len(cart.Items) != 1 {
+		t.Fatalf("cart = %+v, want the lamp kept", cart.Items)
+	}
+}
+
+func TestCheckoutErrors(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+	lamp := s.seedProduct("Desk Lamp", 200, nil)
+	addressID := s.addAddress(token)
+
+	rec := s.do(http.MethodPost, "/api/checkout", gin.H{"address_id": addressID, "payment_method": "cod"}, token)
+	expectError(t, rec, http.StatusBadRequest, apierror.CodeCartEmpty)
+
+	expectStatus(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": lamp.ProductID}, token), http.StatusOK)
+
+	tests := []struct {
+		name   string
+		body   interface{}
+		status int
+		code   apierror.Code
+	}{
+		{"missing address", gin.H{"payment_method": "cod"}, http.StatusBadRequest, apierror.CodeValidation},
+		{"missing payment method", gin.H{"address_id": addressID}, http.StatusBadRequest, apierror.CodeValidation},
+		{"unknown payment method", gin.H{"address_id": addressID, "payment_method": "barter"}, http.StatusBadRequest, apierror.CodeValidation},
+		{"malformed json", `{"address_id": `, http.StatusBadRequest, apierror.CodeValidation},
+		{"unknown address", gin.H{"address_id": "missing", "payment_method": "cod"}, http.StatusNotFound, apierror.CodeAddressNotFound},
+		{"unknown shipping option", gin.H{"address_id": addressID, "payment_method": "cod", "shipping_option": "teleport"}, http.StatusBadRequest, apierror.CodeShippingOption},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectError(t, s.do(http.MethodPost, "/api/checkout", tt.body, token), tt.status, tt.code)
+		})
+	}
+}
+
+func TestPaymentEndpoints(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+	lamp := s.seedProduct("Desk Lamp", 600, nil)
+	addressID := s.addAddress(token)
+
+	expectStatus(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": lamp.ProductID}, token), http.StatusOK)
+	var receipt checkoutResponse
+	decode(t, s.do(http.MethodPost, "/api/checkout", gin.H{"address_id": addressID, "payment_method": "digital"}, token), &receipt)
+
+	// The amount comes from the order; one sent by the client is ignored
+	rec := s.do(http.MethodPost, "/api/payment/create-order", gin.H{"order_id": receipt.OrderID, "amount": 1}, token)
+	expectStatus(t, rec, http.StatusOK)
+	var order payments.Order
+	decode(t, rec, &order)
+	if order.ID == "" || order.ID == receipt.Payment.ID || order.Amount != int64(receipt.Total*100+0.5) || order.Currency != "INR" {
+		t.Fatalf("payment order = %+v, want a new one for %v rupees", order, receipt.Total)
+	}
+	if orders := s.orders(token); orders[0].RazorpayOrderID != order.ID {
+		t.Fatalf("order gateway ID = %q, want %q", orders[0].RazorpayOrderID, order.ID)
+	}
+
+	rec = s.do(http.MethodPost, "/api/payment/create-order", gin.H{"amount": 499.5}, token)
+	expectError(t, rec, http.StatusBadRequest, apierror.CodeValidation)
+	rec = s.do(http.MethodPost, "/api/payment/create-order", gin.H{"order_id": primitive.NewObjectID().Hex()}, token)
+	expectError(t, rec, http.StatusNotFound, apierror.CodeOrderNotFound)
+
+	rec = s.do(http.MethodPost, "/api/payment/verify", gin.H{"razorpay_order_id": order.ID}, token)
+	expectError(t, rec, http.StatusBadRequest, apierror.CodeValidation)
+
+	// A paid order can't be paid again
+	expectStatus(t, s.do(http.MethodPost, "/api/payment/verify", gin.H{
+		"razorpay_order_id":   order.ID,
+		"razorpay_payment_id": "pay_123",
+		"razorpay_signature":  "sig",
+	}, token), http.StatusOK)
+	rec = s.do(http.MethodPost, "/api/payment/create-order", gin.H{"order_id": receipt.OrderID}, token)
+	expectError(t, rec, http.StatusConflict, apierror.CodeOrderNotPending)
+
+	rec = s.do(http.MethodGet, "/api/payment/pay_123", nil, token)
+	expectStatus(t, rec, http.StatusOK)
+}
@@ -0,0 +1,279 @@
+package routes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/config"
+	"ecomm-backend/controllers"
+	"ecomm-backend/metrics"
+	"ecomm-backend/middleware"
+	"ecomm-backend/models"
+	"ecomm-backend/notify"
+	"ecomm-backend/repository"
+	"ecomm-backend/signing"
+	"ecomm-backend/tax"
+	"ecomm-backend/utils"
+)
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+
+	// Sell from Maharashtra with the built-in shipping rates, whatever the
+	// environment says
+	tax.Reset()
+	tax.Register(tax.NewGST("MH"))
+
+	os.Exit(m.Run())
+}
+
+// testServer is the API router backed by in-memory repositories.
+type testServer struct {
+	t        *testing.T
+	router   *gin.Engine
+	handler  *controllers.Handler
+	users    repository.UserRepository
+	products repository.ProductRepository
+	metrics  *metrics.Metrics
+	keys     *signing.KeyRing
+	tokens   *utils.TokenManager
+	outbox   *notify.Memory
+	sms      *notify.Memory
+}
+
+// newTestServer builds a server on the test config; configure adjusts it
+// before the routes are set up.
+func newTestServer(t *testing.T, configure ...func(*config.Config)) *testServer {
+	t.Helper()
+
+	users := repository.NewMemoryUserRepository()
+	products := repository.NewMemoryProductRepository()
+
+	// Cheap password hashing and Ed25519 keys keep signups fast
+	cfg := config.Default()
+	cfg.Env = config.EnvTest
+	cfg.Auth.BcryptCost = bcrypt.MinCost
+	cfg.Auth.Signing.Algorithm = signing.AlgorithmEdDSA
+	for _, fn := range configure {
+		fn(cfg)
+	}
+	keys, err := signing.Open(cfg.Auth.Signing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := metrics.New()
+	router := gin.New()
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		t.Fatal(err)
+	}
+	router.Use(middleware.RequestID(), middleware.Metrics(m), middleware.Recovery(), middleware.ErrorHandler())
+	h := controllers.NewHandler(cfg, users, products, keys, m)
+	outbox := &notify.Memory{}
+	h.Notifier = outbox
+	sms := &notify.Memory{}
+	h.SMS = sms
+	SetupRoutes(router, h)
+	router.NoRoute(func(c *gin.Context) {
+		c.Error(apierror.ErrRouteNotFound)
+	})
+
+	return &testServer{t: t, router: router, handler: h, users: users, products: products, metrics: m, keys: keys, tokens: h.Tokens, outbox: outbox, sms: sms}
+}
+
+// do sends body (marshalled to JSON unless it is a string) and records the response.
+func (s *testServer) do(method, path string, body interface{}, token string) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	var reader *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(b))
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			s.t.Fatalf("marshal request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("token", token)
+	}
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// doWithHeader sends a bodyless request with a single custom header.
+func (s *testServer) doWithHeader(method, path, header, value string) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set(header, value)
+
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+var userSeq int64
+
+// signUp registers a new user, verifies their email and logs them in,
+// returning their token.
+func (s *testServer) signUp() string {
+	s.t.Helper()
+
+	token, email := s.signUpUnverified()
+	expectStatus(s.t, s.verifyEmail(s.mailedToken(email)), http.StatusOK)
+	return token
+}
+
+// signUpUnverified registers a new user without verifying their email and
+// logs them in, returning their token and email.
+func (s *testServer) signUpUnverified() (string, string) {
+	s.t.Helper()
+
+	n := atomic.AddInt64(&userSeq, 1)
+	email := fmt.Sprintf("user%d@example.com", n)
+	rec := s.do(http.MethodPost, "/api/auth/register", gin.H{
+		"first_name": "Test",
+		"last_name":  "User",
+		"email":      email,
+		"password":   "secret123",
+		"phone":      fmt.Sprintf("+9198765%05d", n),
+	}, "")
+	expectStatus(s.t, rec, http.StatusCreated)
+
+	rec = s.do(http.MethodPost, "/api/auth/login", gin.H{"email": email, "password": "secret123"}, "")
+	expectStatus(s.t, rec, http.StatusOK)
+
+	var user models.User
+	decode(s.t, rec, &user)
+	if user.Token == "" {
+		s.t.Fatal("login returned no token")
+	}
+	return user.Token, email
+}
+
+var linkPattern = regexp.MustCompile(`http\S+`)
+
+// mailedToken returns the token in the link of the last message sent to email.
+func (s *testServer) mailedToken(email string) string {
+	s.t.Helper()
+
+	messages := s.outbox.Messages(email)
+	if len(messages) == 0 {
+		s.t.Fatalf("no message sent to %s", email)
+	}
+	link, err := url.Parse(linkPattern.FindString(messages[len(messages)-1].Body))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return link.Query().Get("token")
+}
+
+// seedProduct stores a product and returns it with its generated ID.
+func (s *testServer) seedProduct(name string, price float64, stock *int) models.Product {
+	s.t.Helper()
+
+	ctx := context.Background()
+	productID := "product_" + strings.ToLower(strings.ReplaceAll(name, " ", "_"))
+	err := s.products.InsertMany(ctx, []models.Product{{
+		ProductID:   productID,
+		ProductName: name,
+		Price:       price,
+		Stock:       stock,
+	}})
+	if err != nil {
+		s.t.Fatalf("seed product: %v", err)
+	}
+	product, err := s.products.FindByID(ctx, productID)
+	if err != nil {
+		s.t.Fatalf("find seeded product: %v", err)
+	}
+	return *product
+}
+
+// addAddress saves a Mumbai address for the user and returns its ID.
+func (s *testServer) addAddress(token string) string {
+	s.t.Helper()
+
+	rec := s.do(http.MethodPost, "/api/address", gin.H{
+		"house_name":  "12 Sea View",
+		"street_name": "Marine Drive",
+		"city_name":   "Mumbai",
+		"pin_code":    "400020",
+	}, token)
+	expectStatus(s.t, rec, http.StatusOK)
+
+	var resp struct {
+		Address models.Address `json:"address"`
+	}
+	decode(s.t, rec, &resp)
+	return resp.Address.ID.Hex()
+}
+
+func containsAny(s string, substrs ...string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+	}
+}
+
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, status, rec.Body.String())
+	}
+}
+
+// expectError checks the status and error code of an error envelope.
+func expectError(t *testing.T, rec *httptest.ResponseRecorder, status int, code apierror.Code) {
+	t.Helper()
+	expectStatus(t, rec, status)
+
+	var body struct {
+		Error string        `json:"error"`
+		Code  apierror.Code `json:"code"`
+	}
+	decode(t, rec, &body)
+	if body.Code != code {
+		t.Fatalf("code = %q, want %q; body: %s", body.Code, code, rec.Body.String())
+	}
+	if body.Error == "" {
+		t.Fatalf("error message is empty; body: %s", rec.Body.String())
+	}
+}
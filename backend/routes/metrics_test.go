@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scrape returns the metrics exposition for the test server's registry.
+func (s *testServer) scrape() string {
+	s.t.Helper()
+
+	rec := httptest.NewRecorder()
+	s.metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	expectStatus(s.t, rec, http.StatusOK)
+	return rec.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+	lamp := s.seedProduct("Desk Lamp", 200, intPtr(5))
+	addressID := s.addAddress(token)
+
+	expectStatus(t, s.do(http.MethodPost, "/api/auth/login", gin.H{"email": "nobody@example.com", "password": "secret1"}, ""), http.StatusUnauthorized)
+	expectStatus(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": lamp.ProductID, "qty": 2}, token), http.StatusOK)
+	expectStatus(t, s.do(http.MethodPost, "/api/checkout", gin.H{"address_id": addressID, "payment_method": "cod"}, token), http.StatusOK)
+	expectStatus(t, s.do(http.MethodGet, "/api/products/"+lamp.ProductID, nil, ""), http.StatusOK)
+	expectStatus(t, s.do(http.MethodGet, "/no/such/route", nil, ""), http.StatusNotFound)
+
+	body := s.scrape()
+	for _, want := range []string{
+		`ecomm_signups_total 1`,
+		`ecomm_logins_total{outcome="failure"} 1`,
+		`ecomm_cart_additions_total 1`,
+		`ecomm_checkouts_total{payment_method="cod"} 1`,
+		`ecomm_order_value_inr_count{payment_method="cod"} 1`,
+		`ecomm_order_value_inr_sum{payment_method="cod"} 532`,
+		// Routes are labelled by template so IDs don't multiply series
+		`ecomm_http_requests_total{method="GET",route="/api/products/:id",status="200"} 1`,
+		`ecomm_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`ecomm_http_requests_in_flight 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %q", want)
+		}
+	}
+}
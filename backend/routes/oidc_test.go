@@ -0,0 +1,414 @@
+package routes
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/config"
+	"ecomm-backend/models"
+	"ecomm-backend/oidc"
+	"ecomm-backend/repository"
+)
+
+const (
+	mockClientID     = "shop"
+	mockClientSecret = "shop-secret"
+	mockKeyID        = "mock-key"
+)
+
+// mockAccount is the provider account the mock provider logs in as.
+type mockAccount struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+type mockGrant struct {
+	account     mockAccount
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+// mockOIDC is a minimal OpenID Connect provider. Its authorize endpoint
+// approves every request as the account the test names, and its token
+// endpoint checks the client secret, redirect URI and PKCE verifier before
+// issuing an ID token. tamper and forger let a test spoil that token.
+type mockOIDC struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu      sync.Mutex
+	account mockAccount
+	grants  map[string]mockGrant
+	tamper  func(jwt.MapClaims)
+	forger  *rsa.PrivateKey
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDC{t: t, key: key, grants: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": mockKeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockOIDC) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != mockClientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" ||
+		!strings.Contains(q.Get("scope"), "openid") || q.Get("nonce") == "" || q.Get("state") == "" {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	code := randomCode()
+	m.grants[code] = mockGrant{account: m.account, nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), redirectURI: q.Get("redirect_uri")}
+	m.mu.Unlock()
+
+	callback, _ := url.Parse(q.Get("redirect_uri"))
+	cq := callback.Query()
+	cq.Set("code", code)
+	cq.Set("state", q.Get("state"))
+	callback.RawQuery = cq.Encode()
+	http.Redirect(w, r, callback.String(), http.StatusFound)
+}
+
+func (m *mockOIDC) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, _ := r.BasicAuth()
+	if id != mockClientID || secret != mockClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	grant, ok := m.grants[r.PostFormValue("code")]
+	delete(m.grants, r.PostFormValue("code"))
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != grant.redirectURI ||
+		oidc.CodeChallenge(r.PostFormValue("code_verifier")) != grant.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            m.server.URL,
+		"sub":            grant.account.Subject,
+		"aud":            mockClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Hour).Unix(),
+		"nonce":          grant.nonce,
+		"email":          grant.account.Email,
+		"email_verified": grant.account.EmailVerified,
+		"given_name":     "Open",
+		"family_name":    "Id",
+	}
+	if m.tamper != nil {
+		m.tamper(claims)
+	}
+	key := m.key
+	if m.forger != nil {
+		key = m.forger
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = mockKeyID
+	idToken, err := token.SignedString(key)
+	if err != nil {
+		m.t.Error(err)
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": idToken})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomCode() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// newOIDCServer returns a test server with the mock provider configured
+// as "mock".
+func newOIDCServer(t *testing.T) (*testServer, *mockOIDC) {
+	t.Helper()
+
+	mock := newMockOIDC(t)
+	s := newTestServer(t, func(c *config.Config) {
+		c.Auth.OIDC.Providers = []oidc.Config{{
+			Name:         "mock",
+			Issuer:       mock.server.URL,
+			ClientID:     mockClientID,
+			ClientSecret: mockClientSecret,
+			RedirectURL:  "http://localhost:8080/api/auth/oidc/mock/callback",
+		}}
+	})
+	return s, mock
+}
+
+// startOIDC starts a login with the mock provider, approves it there as
+// account and returns the callback path and the login state cookie.
+func (s *testServer) startOIDC(mock *mockOIDC, account mockAccount) (string, *http.Cookie) {
+	s.t.Helper()
+
+	rec := s.do(http.MethodGet, "/api/auth/oidc/mock", nil, "")
+	expectStatus(s.t, rec, http.StatusFound)
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == "oidc_state" {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		s.t.Fatalf("login state cookie = %+v", cookie)
+	}
+
+	mock.mu.Lock()
+	mock.account = account
+	mock.mu.Unlock()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		s.t.Fatalf("provider refused the authorization request with status %d", resp.StatusCode)
+	}
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return callback.RequestURI(), cookie
+}
+
+func (s *testServer) finishOIDC(callback string, cookie *http.Cookie) *httptest.ResponseRecorder {
+	s.t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, callback, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// oidcLogin logs in through the mock provider as account.
+func (s *testServer) oidcLogin(mock *mockOIDC, account mockAccount) *httptest.ResponseRecorder {
+	s.t.Helper()
+	return s.finishOIDC(s.startOIDC(mock, account))
+}
+
+// oidcUser logs in through the mock provider as account and returns the user.
+func (s *testServer) oidcUser(mock *mockOIDC, account mockAccount) models.User {
+	s.t.Helper()
+
+	rec := s.oidcLogin(mock, account)
+	expectStatus(s.t, rec, http.StatusOK)
+	var user models.User
+	decode(s.t, rec, &user)
+	if user.Token == "" {
+		s.t.Fatal("login returned no token")
+	}
+	return user
+}
+
+func TestOIDCSignUp(t *testing.T) {
+	t.Parallel()
+	s, mock := newOIDCServer(t)
+	account := mockAccount{Subject: "mock|1", Email: "Ada@Example.com", EmailVerified: true}
+
+	// A new provider account signs up, with the provider's verified email
+	user := s.oidcUser(mock, account)
+	if user.Email != "ada@example.com" || !user.EmailVerified || user.FirstName != "Open" || user.LastName != "Id" {
+		t.Fatalf("user = %+v", user)
+	}
+	if len(user.Identities) != 1 || user.Identities[0].Provider != "mock" {
+		t.Fatalf("identities = %+v", user.Identities)
+	}
+	expectStatus(t, s.do(http.MethodGet, "/api/user/profile", nil, user.Token), http.StatusOK)
+	if session := s.sessions(user.Token).Sessions[0]; session.Method != "oidc" {
+		t.Fatalf("session = %+v, want auth method oidc", session)
+	}
+
+	// Later logins find the same user
+	again := s.oidcUser(mock, account)
+	if again.UserID != user.UserID {
+		t.Fatalf("second login made user %s, want %s", again.UserID, user.UserID)
+	}
+}
+
+func TestOIDCLinksByVerifiedEmail(t *testing.T) {
+	t.Parallel()
+	s, mock := newOIDCServer(t)
+
+	// A verified account is linked and keeps its password and sessions
+	token, email := s.signUpUnverified()
+	expectStatus(t, s.verifyEmail(s.mailedToken(email)), http.StatusOK)
+	var existing models.User
+	decode(t, s.do(http.MethodGet, "/api/user/profile", nil, token), &existing)
+
+	account := mockAccount{Subject: "mock|linked", Email: strings.ToUpper(email), EmailVerified: true}
+	user := s.oidcUser(mock, account)
+	if user.UserID != existing.UserID {
+		t.Fatalf("provider login made user %s, want the existing %s", user.UserID, existing.UserID)
+	}
+	expectStatus(t, s.do(http.MethodGet, "/api/user/profile", nil, token), http.StatusOK)
+	expectStatus(t, s.do(http.MethodPost, "/api/auth/login", gin.H{"email": email, "password": "secret123"}, ""), http.StatusOK)
+
+	// The link holds even after the address changes at the provider
+	account.Email = "someone-else@example.com"
+	if again := s.oidcUser(mock, account); again.UserID != existing.UserID {
+		t.Fatalf("login after an email change made user %s, want %s", again.UserID, existing.UserID)
+	}
+
+	// An address the provider hasn't verified reaches no account
+	expectError(t, s.oidcLogin(mock, mockAccount{Subject: "mock|unverified", Email: email, EmailVerified: false}),
+		http.StatusForbidden, apierror.CodeOIDCEmail)
+}
+
+func TestOIDCTakesOverUnverifiedAccount(t *testing.T) {
+	t.Parallel()
+	s, mock := newOIDCServer(t)
+
+	// Someone registered the address without ever verifying it
+	squatter, email := s.signUpUnverified()
+
+	user := s.oidcUser(mock, mockAccount{Subject: "mock|owner", Email: email, EmailVerified: true})
+	if !user.EmailVerified {
+		t.Fatal("email not verified after the provider vouched for it")
+	}
+
+	// Their password and sessions no longer get in
+	expectError(t, s.do(http.MethodGet, "/api/user/profile", nil, squatter), http.StatusUnauthorized, apierror.CodeTokenRevoked)
+	expectError(t, s.do(http.MethodPost, "/api/auth/login", gin.H{"email": email, "password": "secret123"}, ""),
+		http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+	expectStatus(t, s.do(http.MethodGet, "/api/user/profile", nil, user.Token), http.StatusOK)
+}
+
+// conflictingUsers refuses every new user as a duplicate, as an index on a
+// field the lookups don't cover would.
+type conflictingUsers struct {
+	repository.UserRepository
+	creates int
+}
+
+func (r *conflictingUsers) Create(ctx context.Context, user *models.User) error {
+	r.creates++
+	return repository.ErrDuplicate
+}
+
+func TestOIDCPersistentConflict(t *testing.T) {
+	t.Parallel()
+	s, mock := newOIDCServer(t)
+	users := &conflictingUsers{UserRepository: s.users}
+	s.handler.Users = users
+
+	// The lookup is retried once, then the conflict is reported
+	rec := s.oidcLogin(mock, mockAccount{Subject: "mock|3", Email: "linus@example.com", EmailVerified: true})
+	expectError(t, rec, http.StatusConflict, apierror.CodeOIDCConflict)
+	if users.creates != 2 {
+		t.Fatalf("tried to create the user %d times, want 2", users.creates)
+	}
+}
+
+func TestOIDCRejectsBadCallbacks(t *testing.T) {
+	t.Parallel()
+	s, mock := newOIDCServer(t)
+	account := mockAccount{Subject: "mock|2", Email: "grace@example.com", EmailVerified: true}
+
+	expectError(t, s.do(http.MethodGet, "/api/auth/oidc/nowhere", nil, ""), http.StatusNotFound, apierror.CodeProviderNotFound)
+
+	// The callback must come back to the browser that started the login
+	callback, cookie := s.startOIDC(mock, account)
+	expectError(t, s.finishOIDC(callback, nil), http.StatusBadRequest, apierror.CodeOIDCStateInvalid)
+	_, otherCookie := s.startOIDC(mock, account)
+	expectError(t, s.finishOIDC(callback, otherCookie), http.StatusBadRequest, apierror.CodeOIDCStateInvalid)
+
+	// A code is exchanged once
+	expectStatus(t, s.finishOIDC(callback, cookie), http.StatusOK)
+	expectError(t, s.finishOIDC(callback, cookie), http.StatusUnauthorized, apierror.CodeOIDCLoginFailed)
+
+	// The user declined at the provider
+	callback, cookie = s.startOIDC(mock, account)
+	u, _ := url.Parse(callback)
+	q := u.Query()
+	q.Del("code")
+	q.Set("error", "access_denied")
+	u.RawQuery = q.Encode()
+	expectError(t, s.finishOIDC(u.RequestURI(), cookie), http.StatusUnauthorized, apierror.CodeOIDCLoginFailed)
+
+	forger, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		tamper func(jwt.MapClaims)
+		forger *rsa.PrivateKey
+	}{
+		{name: "nonce", tamper: func(c jwt.MapClaims) { c["nonce"] = "replayed" }},
+		{name: "audience", tamper: func(c jwt.MapClaims) { c["aud"] = "another-client" }},
+		{name: "issuer", tamper: func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{name: "expired", tamper: func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{name: "authorized party", tamper: func(c jwt.MapClaims) { c["aud"] = []string{mockClientID, "other"}; c["azp"] = "other" }},
+		{name: "signature", forger: forger},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock.mu.Lock()
+			mock.tamper, mock.forger = tt.tamper, tt.forger
+			mock.mu.Unlock()
+
+			expectError(t, s.oidcLogin(mock, account), http.StatusUnauthorized, apierror.CodeOIDCLoginFailed)
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/config"
+	"ecomm-backend/models"
+	"ecomm-backend/ratelimit"
+)
+
+var otpPattern = regexp.MustCompile(`\b\d{6}\b`)
+
+// requestOTP asks for a login code for phone and returns the last code
+// texted to it.
+func (s *testServer) requestOTP(phone string) string {
+	s.t.Helper()
+
+	expectStatus(s.t, s.do(http.MethodPost, "/api/auth/otp/request", gin.H{"phone": phone}, ""), http.StatusAccepted)
+	messages := s.sms.Messages(phone)
+	if len(messages) == 0 {
+		s.t.Fatalf("no code sent to %s", phone)
+	}
+	return otpPattern.FindString(messages[len(messages)-1].Body)
+}
+
+func (s *testServer) verifyOTP(phone, code string) *httptest.ResponseRecorder {
+	return s.do(http.MethodPost, "/api/auth/otp/verify", gin.H{"phone": phone, "code": code}, "")
+}
+
+// wrongCode returns a code of the same length that isn't code.
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "111111"
+	}
+	return "000000"
+}
+
+func TestOTPLogin(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token, _ := s.signUpUnverified()
+	phone := s.profile(token).Phone
+
+	// Unknown numbers get the same answer and no text
+	rec := s.do(http.MethodPost, "/api/auth/otp/request", gin.H{"phone": "+919999999999"}, "")
+	expectStatus(t, rec, http.StatusAccepted)
+	if len(s.sms.Messages("+919999999999")) != 0 {
+		t.Fatal("sent a code to an unknown number")
+	}
+	expectError(t, s.verifyOTP("+919999999999", "123456"), http.StatusUnauthorized, apierror.CodeOTPInvalid)
+
+	code := s.requestOTP(phone)
+	if code == "" {
+		t.Fatalf("no code in %q", s.sms.Messages(phone)[0].Body)
+	}
+	user, err := s.users.FindByPhone(context.Background(), phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.LoginOTP.CodeHash == code {
+		t.Fatal("code stored in plain text")
+	}
+	if user.PhoneVerified {
+		t.Fatal("new phone is already verified")
+	}
+
+	expectError(t, s.verifyOTP(phone, wrongCode(code)), http.StatusUnauthorized, apierror.CodeOTPInvalid)
+
+	// The right code logs in like a password does and verifies the phone
+	rec = s.verifyOTP(phone, code)
+	expectStatus(t, rec, http.StatusOK)
+	var loggedIn models.User
+	decode(t, rec, &loggedIn)
+	if loggedIn.Token == "" || loggedIn.RefreshToken == "" || !loggedIn.PhoneVerified {
+		t.Fatalf("login = %+v, want a verified phone and a token pair", loggedIn)
+	}
+	if !s.profile(loggedIn.Token).PhoneVerified {
+		t.Fatal("phone not verified")
+	}
+
+	// Single use
+	expectError(t, s.verifyOTP(phone, code), http.StatusUnauthorized, apierror.CodeOTPInvalid)
+}
+
+func TestOTPAttemptLimit(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Auth.OTP.MaxAttempts = 2
+	})
+	token, _ := s.signUpUnverified()
+	phone := s.profile(token).Phone
+	code := s.requestOTP(phone)
+
+	expectError(t, s.verifyOTP(phone, wrongCode(code)), http.StatusUnauthorized, apierror.CodeOTPInvalid)
+	expectError(t, s.verifyOTP(phone, wrongCode(code)), http.StatusUnauthorized, apierror.CodeOTPInvalid)
+	expectError(t, s.verifyOTP(phone, code), http.StatusUnauthorized, apierror.CodeOTPInvalid)
+}
+
+func TestOTPLockout(t *testing.T) {
+	t.Parallel()
+	// Without the per-IP limit, and with a new code on every request
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.RateLimit.IP = ratelimit.Limit{}
+		cfg.Auth.OTP.MaxAttempts = 2
+		cfg.Auth.OTP.ResendInterval = 0
+		cfg.Auth.Lockout.Threshold = 3
+	})
+	token, email := s.signUpUnverified()
+	phone := s.profile(token).Phone
+
+	// Failures add up across codes
+	code := s.requestOTP(phone)
+	expectError(t, s.verifyOTP(phone, wrongCode(code)), http.StatusUnauthorized, apierror.CodeOTPInvalid)
+	expectError(t, s.verifyOTP(phone, wrongCode(code)), http.StatusUnauthorized, apierror.CodeOTPInvalid)
+	code = s.requestOTP(phone)
+	expectError(t, s.verifyOTP(phone, wrongCode(code)), http.StatusUnauthorized, apierror.CodeOTPInvalid)
+
+	// The account is locked for codes and passwords alike
+	code = s.requestOTP(phone)
+	expectError(t, s.verifyOTP(phone, code), http.StatusUnauthorized, apierror.CodeOTPInvalid)
+	rec := s.do(http.MethodPost, "/api/auth/login", gin.H{"email": email, "password": "secret123"}, "")
+	expectError(t, rec, http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+
+	// Once the lock expires the right code logs in and clears the count
+	ctx := context.Background()
+	user, err := s.users.FindByPhone(ctx, phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.FailedLogins != 3 {
+		t.Fatalf("failed logins = %d, want 3", user.FailedLogins)
+	}
+	if err := s.users.LockUntil(ctx, user.UserID, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, s.verifyOTP(phone, code), http.StatusOK)
+	if user, _ := s.users.FindByPhone(ctx, phone); user.FailedLogins != 0 || user.LockedUntil != nil {
+		t.Fatalf("failed logins = %d, locked until %v after login", user.FailedLogins, user.LockedUntil)
+	}
+}
+
+func TestOTPResend(t *testing.T) {
+	t.Parallel()
+
+	t.Run("throttled", func(t *testing.T) {
+		s := newTestServer(t)
+		token, _ := s.signUpUnverified()
+		phone := s.profile(token).Phone
+
+		first := s.requestOTP(phone)
+		if again := s.requestOTP(phone); again != first || len(s.sms.Messages(phone)) != 1 {
+			t.Fatalf("resent within the interval: %+v", s.sms.Messages(phone))
+		}
+		expectStatus(t, s.verifyOTP(phone, first), http.StatusOK)
+	})
+
+	t.Run("replaces the code", func(t *testing.T) {
+		s := newTestServer(t, func(cfg *config.Config) {
+			cfg.Auth.OTP.ResendInterval = 0
+		})
+		token, _ := s.signUpUnverified()
+		phone := s.profile(token).Phone
+
+		first := s.requestOTP(phone)
+		second := s.requestOTP(phone)
+		if len(s.sms.Messages(phone)) != 2 {
+			t.Fatalf("messages = %+v, want two", s.sms.Messages(phone))
+		}
+		if first != second {
+			expectError(t, s.verifyOTP(phone, first), http.StatusUnauthorized, apierror.CodeOTPInvalid)
+		}
+		expectStatus(t, s.verifyOTP(phone, second), http.StatusOK)
+	})
+}
+
+func TestOTPExpiry(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token, _ := s.signUpUnverified()
+	phone := s.profile(token).Phone
+	code := s.requestOTP(phone)
+
+	ctx := context.Background()
+	user, err := s.users.FindByPhone(ctx, phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := *user.LoginOTP
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	if err := s.users.SetLoginOTP(ctx, user.UserID, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	expectError(t, s.verifyOTP(phone, code), http.StatusUnauthorized, apierror.CodeOTPInvalid)
+}
+
+func TestPhoneChangeNeedsVerification(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token, _ := s.signUpUnverified()
+	phone := s.profile(token).Phone
+	expectStatus(t, s.verifyOTP(phone, s.requestOTP(phone)), http.StatusOK)
+
+	// Re-saving the same number leaves it verified
+	expectStatus(t, s.do(http.MethodPut, "/api/user/profile", gin.H{"phone": phone}, token), http.StatusOK)
+	if !s.profile(token).PhoneVerified {
+		t.Fatal("unchanged phone lost its verification")
+	}
+
+	expectStatus(t, s.do(http.MethodPut, "/api/user/profile", gin.H{"phone": "+919000000001"}, token), http.StatusOK)
+	if s.profile(token).PhoneVerified {
+		t.Fatal("new phone is verified without a code")
+	}
+}
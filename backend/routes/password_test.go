@@ -0,0 +1,100 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/models"
+)
+
+// resetToken requests a reset for email and returns the token from the link
+// that was sent.
+func (s *testServer) resetToken(email string) string {
+	s.t.Helper()
+
+	rec := s.do(http.MethodPost, "/api/auth/forgot-password", gin.H{"email": email}, "")
+	expectStatus(s.t, rec, http.StatusAccepted)
+	return s.mailedToken(email)
+}
+
+func TestPasswordReset(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	oldToken := s.signUp()
+
+	var profile models.User
+	decode(t, s.do(http.MethodGet, "/api/user/profile", nil, oldToken), &profile)
+	email := profile.Email
+
+	// Unknown emails get the same answer and no message
+	rec := s.do(http.MethodPost, "/api/auth/forgot-password", gin.H{"email": "nobody@example.com"}, "")
+	expectStatus(t, rec, http.StatusAccepted)
+	if len(s.outbox.Messages("nobody@example.com")) != 0 {
+		t.Fatal("sent a reset link to an unknown email")
+	}
+
+	// Only the latest link works
+	stale := s.resetToken(email)
+	token := s.resetToken(email)
+	if token == "" || token == stale {
+		t.Fatalf("tokens = %q, %q", stale, token)
+	}
+	user, err := s.users.FindByEmail(context.Background(), email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PasswordReset.TokenHash == token {
+		t.Fatal("reset token stored in plain text")
+	}
+
+	reset := func(token, password string) *httptest.ResponseRecorder {
+		return s.do(http.MethodPost, "/api/auth/reset-password", gin.H{"token": token, "password": password}, "")
+	}
+	expectError(t, reset(stale, "newsecret1"), http.StatusBadRequest, apierror.CodeResetTokenInvalid)
+	expectError(t, reset(token, "123"), http.StatusBadRequest, apierror.CodeValidation)
+	expectStatus(t, reset(token, "newsecret1"), http.StatusOK)
+
+	// Single use
+	expectError(t, reset(token, "another1"), http.StatusBadRequest, apierror.CodeResetTokenInvalid)
+
+	// Existing sessions end; the new password works and the old one doesn't
+	expectError(t, s.do(http.MethodGet, "/api/user/profile", nil, oldToken), http.StatusUnauthorized, apierror.CodeTokenRevoked)
+	expectError(t, s.do(http.MethodPost, "/api/auth/login", gin.H{"email": email, "password": "secret123"}, ""), http.StatusUnauthorized, apierror.CodeInvalidCredentials)
+	rec = s.do(http.MethodPost, "/api/auth/login", gin.H{"email": email, "password": "newsecret1"}, "")
+	expectStatus(t, rec, http.StatusOK)
+	var loggedIn models.User
+	decode(t, rec, &loggedIn)
+	expectStatus(t, s.do(http.MethodGet, "/api/user/profile", nil, loggedIn.Token), http.StatusOK)
+
+	if got := s.outbox.Messages(email); got[len(got)-1].Subject != "Your password was changed" {
+		t.Fatalf("last message = %+v, want a change notice", got[len(got)-1])
+	}
+}
+
+func TestPasswordResetExpiry(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+
+	var profile models.User
+	decode(t, s.do(http.MethodGet, "/api/user/profile", nil, s.signUp()), &profile)
+	token := s.resetToken(profile.Email)
+
+	user, err := s.users.FindByEmail(context.Background(), profile.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := *user.PasswordReset
+	expired.ExpiresAt = expired.ExpiresAt.Add(-2 * time.Hour)
+	if err := s.users.SetPasswordReset(context.Background(), user.UserID, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := s.do(http.MethodPost, "/api/auth/reset-password", gin.H{"token": token, "password": "newsecret1"}, "")
+	expectError(t, rec, http.StatusBadRequest, apierror.CodeResetTokenInvalid)
+}
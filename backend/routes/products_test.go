@@ -0,0 +1,83 @@
+package routes
+
+import (
+	"net/http"
+	"testing"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/models"
+)
+
+func TestListProductsSeedsCatalogue(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+
+	rec := s.do(http.MethodGet, "/api/products", nil, "")
+	expectStatus(t, rec, http.StatusOK)
+
+	var products []models.Product
+	decode(t, rec, &products)
+	if len(products) == 0 {
+		t.Fatal("expected the empty catalogue to be seeded")
+	}
+
+	// Seeding only happens once
+	var again []models.Product
+	decode(t, s.do(http.MethodGet, "/api/products", nil, ""), &again)
+	if len(again) != len(products) {
+		t.Fatalf("second listing has %d products, want %d", len(again), len(products))
+	}
+}
+
+func TestGetProduct(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	product := s.seedProduct("Desk Lamp", 899, nil)
+
+	for _, id := range []string{product.ID.Hex(), product.ProductID} {
+		rec := s.do(http.MethodGet, "/api/products/"+id, nil, "")
+		expectStatus(t, rec, http.StatusOK)
+
+		var got models.Product
+		decode(t, rec, &got)
+		if got.ProductName != "Desk Lamp" {
+			t.Fatalf("GET %s returned %q", id, got.ProductName)
+		}
+	}
+
+	rec := s.do(http.MethodGet, "/api/products/missing", nil, "")
+	expectError(t, rec, http.StatusNotFound, apierror.CodeProductNotFound)
+}
+
+func TestSearchProducts(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	s.seedProduct("Desk Lamp", 899, nil)
+	s.seedProduct("Floor Lamp", 1499, nil)
+	s.seedProduct("Office Chair", 5999, nil)
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"lamp", 2},
+		{"CHAIR", 1},
+		{"sofa", 0},
+		// Regex metacharacters are matched literally
+		{".*", 0},
+	}
+
+	for _, tt := range tests {
+		rec := s.do(http.MethodGet, "/api/products/search?name="+tt.query, nil, "")
+		expectStatus(t, rec, http.StatusOK)
+
+		var products []models.Product
+		decode(t, rec, &products)
+		if len(products) != tt.want {
+			t.Errorf("search %q returned %d products, want %d", tt.query, len(products), tt.want)
+		}
+	}
+
+	rec := s.do(http.MethodGet, "/api/products/search", nil, "")
+	expectError(t, rec, http.StatusBadRequest, apierror.CodeValidation)
+}
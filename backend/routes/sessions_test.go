@@ -0,0 +1,205 @@
+package routes
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/config"
+	"ecomm-backend/models"
+	"ecomm-backend/repository"
+)
+
+type sessionList struct {
+	Sessions []struct {
+		ID        string `json:"id"`
+		UserAgent string `json:"user_agent"`
+		IP        string `json:"ip"`
+		Method    string `json:"auth_method"`
+		Current   bool   `json:"current"`
+	} `json:"sessions"`
+}
+
+// loginFrom logs email in from a client with the given user agent and
+// returns the token.
+func (s *testServer) loginFrom(email, userAgent string) string {
+	s.t.Helper()
+
+	body := `{"email":"` + email + `","password":"secret123"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	expectStatus(s.t, rec, http.StatusOK)
+
+	var user models.User
+	decode(s.t, rec, &user)
+	return user.Token
+}
+
+func (s *testServer) sessions(token string) sessionList {
+	s.t.Helper()
+
+	rec := s.do(http.MethodGet, "/api/user/sessions", nil, token)
+	expectStatus(s.t, rec, http.StatusOK)
+	var list sessionList
+	decode(s.t, rec, &list)
+	return list
+}
+
+func TestSessions(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	_, email := s.signUpUnverified()
+	laptop := s.loginFrom(email, "Firefox on Linux")
+	phone := s.loginFrom(email, "Safari on iPhone")
+
+	// Every login is listed, the most recent first
+	list := s.sessions(laptop)
+	if len(list.Sessions) != 3 {
+		t.Fatalf("got %d sessions, want 3", len(list.Sessions))
+	}
+	phoneSession := list.Sessions[0]
+	if phoneSession.UserAgent != "Safari on iPhone" || phoneSession.IP == "" || phoneSession.Method != "password" || phoneSession.Current {
+		t.Fatalf("phone session = %+v", phoneSession)
+	}
+	if !list.Sessions[1].Current || list.Sessions[1].UserAgent != "Firefox on Linux" {
+		t.Fatalf("laptop session = %+v, want it marked current", list.Sessions[1])
+	}
+
+	// Revoking a session logs only that one out
+	expectStatus(t, s.do(http.MethodDelete, "/api/user/sessions/"+phoneSession.ID, nil, laptop), http.StatusOK)
+	expectError(t, s.do(http.MethodGet, "/api/user/profile", nil, phone), http.StatusUnauthorized, apierror.CodeTokenRevoked)
+	expectStatus(t, s.do(http.MethodGet, "/api/user/profile", nil, laptop), http.StatusOK)
+	if got := len(s.sessions(laptop).Sessions); got != 2 {
+		t.Fatalf("got %d sessions after revoking one, want 2", got)
+	}
+	expectError(t, s.do(http.MethodDelete, "/api/user/sessions/"+phoneSession.ID, nil, laptop), http.StatusNotFound, apierror.CodeSessionNotFound)
+
+	// Sessions belong to their user
+	other := s.signUp()
+	otherSession := s.sessions(other).Sessions[0].ID
+	expectError(t, s.do(http.MethodDelete, "/api/user/sessions/"+otherSession, nil, laptop), http.StatusNotFound, apierror.CodeSessionNotFound)
+	expectStatus(t, s.do(http.MethodGet, "/api/user/profile", nil, other), http.StatusOK)
+
+	// Revoking the current session is a logout
+	current := s.sessions(laptop).Sessions
+	for _, session := range current {
+		if session.Current {
+			expectStatus(t, s.do(http.MethodDelete, "/api/user/sessions/"+session.ID, nil, laptop), http.StatusOK)
+		}
+	}
+	expectError(t, s.do(http.MethodGet, "/api/user/profile", nil, laptop), http.StatusUnauthorized, apierror.CodeTokenRevoked)
+}
+
+func TestRefreshToken(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	_, email := s.signUpUnverified()
+
+	rec := s.do(http.MethodPost, "/api/auth/login", gin.H{"email": email, "password": "secret123"}, "")
+	expectStatus(t, rec, http.StatusOK)
+	var login models.User
+	decode(t, rec, &login)
+
+	refresh := func(token string) *httptest.ResponseRecorder {
+		return s.do(http.MethodPost, "/api/auth/refresh", gin.H{"refresh_token": token}, "")
+	}
+
+	// A new pair in the same session
+	rec = refresh(login.RefreshToken)
+	expectStatus(t, rec, http.StatusOK)
+	var pair struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	decode(t, rec, &pair)
+	if pair.Token == "" || pair.RefreshToken == "" {
+		t.Fatalf("refresh = %+v, want a token pair", pair)
+	}
+	list := s.sessions(pair.Token)
+	if len(list.Sessions) != 2 {
+		t.Fatalf("got %d sessions after refreshing, want 2", len(list.Sessions))
+	}
+
+	// Only refresh tokens are accepted
+	expectError(t, s.do(http.MethodPost, "/api/auth/refresh", gin.H{}, ""), http.StatusBadRequest, apierror.CodeValidation)
+	expectError(t, refresh(login.Token), http.StatusUnauthorized, apierror.CodeTokenInvalid)
+
+	// Nor once the session has ended
+	for _, session := range list.Sessions {
+		if session.Current {
+			expectStatus(t, s.do(http.MethodDelete, "/api/user/sessions/"+session.ID, nil, pair.Token), http.StatusOK)
+		}
+	}
+	expectError(t, refresh(login.RefreshToken), http.StatusUnauthorized, apierror.CodeTokenRevoked)
+	expectError(t, refresh(pair.RefreshToken), http.StatusUnauthorized, apierror.CodeTokenRevoked)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	_, email := s.signUpUnverified()
+
+	rec := s.do(http.MethodPost, "/api/auth/login", gin.H{"email": email, "password": "secret123"}, "")
+	expectStatus(t, rec, http.StatusOK)
+	var login models.User
+	decode(t, rec, &login)
+
+	type pair struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	refresh := func(token string) *httptest.ResponseRecorder {
+		return s.do(http.MethodPost, "/api/auth/refresh", gin.H{"refresh_token": token}, "")
+	}
+
+	// Each refresh issues a new refresh token
+	rec = refresh(login.RefreshToken)
+	expectStatus(t, rec, http.StatusOK)
+	var first pair
+	decode(t, rec, &first)
+	if first.RefreshToken == login.RefreshToken {
+		t.Fatal("refresh returned the same refresh token")
+	}
+	rec = refresh(first.RefreshToken)
+	expectStatus(t, rec, http.StatusOK)
+	var second pair
+	decode(t, rec, &second)
+
+	// A used refresh token is refused and ends the session, so the
+	// latest pair stops working too
+	expectError(t, refresh(login.RefreshToken), http.StatusUnauthorized, apierror.CodeTokenRevoked)
+	expectError(t, refresh(second.RefreshToken), http.StatusUnauthorized, apierror.CodeTokenRevoked)
+	expectError(t, s.do(http.MethodGet, "/api/user/profile", nil, second.Token), http.StatusUnauthorized, apierror.CodeTokenRevoked)
+
+	// Other sessions are untouched
+	other := s.loginFrom(email, "Safari on iPhone")
+	if got := len(s.sessions(other).Sessions); got != 2 {
+		t.Fatalf("got %d sessions, want the signup and the new login", got)
+	}
+}
+
+func TestSessionLimit(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, func(c *config.Config) {
+		c.RateLimit.IP.Burst = 0
+		c.RateLimit.Account.Burst = 0
+	})
+	first, email := s.signUpUnverified()
+
+	// The oldest session ends once there are too many
+	var last string
+	for i := 0; i < repository.MaxSessions; i++ {
+		last = s.loginFrom(email, "Chrome")
+	}
+	expectError(t, s.do(http.MethodGet, "/api/user/profile", nil, first), http.StatusUnauthorized, apierror.CodeTokenRevoked)
+	if got := len(s.sessions(last).Sessions); got != repository.MaxSessions {
+		t.Fatalf("got %d sessions, want %d", got, repository.MaxSessions)
+	}
+}
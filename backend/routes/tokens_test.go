@@ -0,0 +1,127 @@
+package routes
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
+	"ecomm-backend/config"
+	"ecomm-backend/models"
+	"ecomm-backend/signing"
+	"ecomm-backend/utils"
+)
+
+func TestJWKSVerifiesIssuedTokens(t *testing.T) {
+	s := newTestServer(t, func(c *config.Config) { c.Auth.Signing.Algorithm = signing.AlgorithmRS256 })
+	token := s.signUp()
+
+	rec := s.do(http.MethodGet, "/.well-known/jwks.json", nil, "")
+	expectStatus(t, rec, http.StatusOK)
+	if rec.Header().Get("Cache-Control") == "" {
+		t.Error("jwks response has no Cache-Control header")
+	}
+	var set signing.JWKSet
+	decode(t, rec, &set)
+
+	// Anyone holding the published keys can verify our tokens
+	keys := map[string]*rsa.PublicKey{}
+	for _, jwk := range set.Keys {
+		n, _ := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, _ := base64.RawURLEncoding.DecodeString(jwk.E)
+		keys[jwk.ID] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	parsed, err := jwt.Parse(token, func(tok *jwt.Token) (interface{}, error) {
+		return keys[tok.Header["kid"].(string)], nil
+	}, jwt.WithValidMethods([]string{signing.AlgorithmRS256}))
+	if err != nil || !parsed.Valid {
+		t.Fatalf("token does not verify with the published key: %v", err)
+	}
+}
+
+func TestTokensSignedWithOtherKeysRefused(t *testing.T) {
+	s := newTestServer(t)
+	token := s.signUp()
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token, claims); err != nil {
+		t.Fatal(err)
+	}
+
+	// The old shared secret no longer signs tokens, whatever the kid says
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = s.keys.Signing().ID
+	signed, err := forged.SignedString([]byte(config.DefaultJWTSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, s.do(http.MethodGet, "/api/user/profile", nil, signed), http.StatusUnauthorized, apierror.CodeTokenInvalid)
+
+	// Nor does a key that isn't in the ring
+	other, err := signing.Open(config.Default().Auth.Signing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged = jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	forged.Header["kid"] = other.Signing().ID
+	signed, err = forged.SignedString(other.Signing().Private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, s.do(http.MethodGet, "/api/user/profile", nil, signed), http.StatusUnauthorized, apierror.CodeTokenInvalid)
+}
+
+func TestTokenClaims(t *testing.T) {
+	s := newTestServer(t)
+	_, email := s.signUpUnverified()
+	rec := s.do(http.MethodPost, "/api/auth/login", gin.H{"email": email, "password": "secret123"}, "")
+	expectStatus(t, rec, http.StatusOK)
+	var user models.User
+	decode(t, rec, &user)
+
+	claims := jwt.MapClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(user.Token, claims); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.Default().Auth
+	if claims["iss"] != cfg.TokenIssuer || claims["sub"] != user.UserID || claims["typ"] != utils.TypeAccess {
+		t.Errorf("claims = %v", claims)
+	}
+	if aud, _ := claims.GetAudience(); len(aud) != 1 || aud[0] != cfg.TokenAudience {
+		t.Errorf("aud = %v, want %q", aud, cfg.TokenAudience)
+	}
+	for _, name := range []string{"iat", "nbf", "exp", "jti"} {
+		if _, ok := claims[name]; !ok {
+			t.Errorf("token has no %s claim", name)
+		}
+	}
+
+	// The refresh token names its user but isn't an access token
+	refresh, err := s.tokens.ValidateRefresh(user.RefreshToken)
+	if err != nil || refresh.Subject != user.UserID {
+		t.Fatalf("refresh token %+v, %v; want one for %q", refresh, err, user.UserID)
+	}
+	expectError(t, s.do(http.MethodGet, "/api/user/profile", nil, user.RefreshToken), http.StatusUnauthorized, apierror.CodeTokenInvalid)
+	if _, err := s.tokens.ValidateRefresh(user.Token); err == nil {
+		t.Fatal("access token accepted as a refresh token")
+	}
+
+	// Tokens for another service are refused even with our keys
+	other := utils.NewTokenManager(s.keys, cfg.TokenIssuer, "reporting-api")
+	token, _, err := other.Generate(user.Email, user.FirstName, user.LastName, user.UserID, 0, auth.MethodPassword, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, s.do(http.MethodGet, "/api/user/profile", nil, token), http.StatusUnauthorized, apierror.CodeTokenInvalid)
+	other = utils.NewTokenManager(s.keys, "https://elsewhere.example.com", cfg.TokenAudience)
+	token, _, err = other.Generate(user.Email, user.FirstName, user.LastName, user.UserID, 0, auth.MethodPassword, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, s.do(http.MethodGet, "/api/user/profile", nil, token), http.StatusUnauthorized, apierror.CodeTokenInvalid)
+}
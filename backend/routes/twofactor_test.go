@@ -0,0 +1,301 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/crypto/bcrypt"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/auth"
+	"ecomm-backend/config"
+	"ecomm-backend/models"
+	"ecomm-backend/totp"
+)
+
+type loginChallenge struct {
+	TwoFactorRequired  bool   `json:"two_factor_required"`
+	EnrollmentRequired bool   `json:"enrollment_required"`
+	ChallengeToken     string `json:"challenge_token"`
+}
+
+// loginChallenged logs in with a password and expects a two-factor challenge.
+func (s *testServer) loginChallenged(email string) loginChallenge {
+	s.t.Helper()
+
+	rec := s.do(http.MethodPost, "/api/auth/login", gin.H{"email": email, "password": "secret123"}, "")
+	expectStatus(s.t, rec, http.StatusAccepted)
+	var challenge loginChallenge
+	decode(s.t, rec, &challenge)
+	if !challenge.TwoFactorRequired || challenge.ChallengeToken == "" {
+		s.t.Fatalf("challenge = %+v", challenge)
+	}
+	return challenge
+}
+
+// totpCode returns the current code for the user's secret, first forgetting
+// the last code used so tests can log in several times within one period.
+func (s *testServer) totpCode(email string) string {
+	s.t.Helper()
+
+	ctx := context.Background()
+	user, err := s.users.FindByEmail(ctx, email)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	twoFactor := *user.TwoFactor
+	twoFactor.LastStep = 0
+	if err := s.users.SetTwoFactor(ctx, user.UserID, &twoFactor); err != nil {
+		s.t.Fatal(err)
+	}
+	code, err := totp.Code(twoFactor.Secret, totp.Step(time.Now()))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return code
+}
+
+func (s *testServer) verifyTwoFactor(body gin.H) *httptest.ResponseRecorder {
+	return s.do(http.MethodPost, "/api/auth/2fa/verify", body, "")
+}
+
+func TestTwoFactorEnrollment(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.RateLimit.Account.Burst = 0
+	})
+	token := s.signUp()
+	email := s.profile(token).Email
+
+	var status struct {
+		Enabled   bool `json:"enabled"`
+		Remaining int  `json:"recovery_codes_remaining"`
+	}
+	decode(t, s.do(http.MethodGet, "/api/user/2fa", nil, token), &status)
+	if status.Enabled {
+		t.Fatal("two-factor enabled for a new account")
+	}
+
+	rec := s.do(http.MethodPost, "/api/user/2fa/enroll", nil, token)
+	expectStatus(t, rec, http.StatusOK)
+	var enrollment struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+	decode(t, rec, &enrollment)
+	if enrollment.Secret == "" || enrollment.OTPAuthURI == "" {
+		t.Fatalf("enrollment = %+v", enrollment)
+	}
+
+	// Until confirmed, logins don't ask for a code
+	expectStatus(t, s.do(http.MethodPost, "/api/auth/login", gin.H{"email": email, "password": "secret123"}, ""), http.StatusOK)
+
+	code, err := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, s.do(http.MethodPost, "/api/user/2fa/confirm", gin.H{"code": wrongCode(code)}, token), http.StatusBadRequest, apierror.CodeTwoFactorInvalid)
+	rec = s.do(http.MethodPost, "/api/user/2fa/confirm", gin.H{"code": code}, token)
+	expectStatus(t, rec, http.StatusOK)
+	var confirmed struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decode(t, rec, &confirmed)
+	if len(confirmed.RecoveryCodes) != 10 {
+		t.Fatalf("recovery codes = %q", confirmed.RecoveryCodes)
+	}
+	expectError(t, s.do(http.MethodPost, "/api/user/2fa/enroll", nil, token), http.StatusConflict, apierror.CodeTwoFactorEnabled)
+
+	// Password logins now stop at a challenge, which isn't a session
+	challenge := s.loginChallenged(email)
+	if challenge.EnrollmentRequired {
+		t.Fatal("enrolled user asked to enroll")
+	}
+	expectError(t, s.do(http.MethodGet, "/api/user/profile", nil, challenge.ChallengeToken), http.StatusUnauthorized, apierror.CodeTokenInvalid)
+	expectError(t, s.verifyTwoFactor(gin.H{"challenge_token": token, "code": code}), http.StatusUnauthorized, apierror.CodeChallengeInvalid)
+
+	// The code used to confirm can't be replayed
+	expectError(t, s.verifyTwoFactor(gin.H{"challenge_token": challenge.ChallengeToken, "code": code}), http.StatusUnauthorized, apierror.CodeTwoFactorInvalid)
+
+	rec = s.verifyTwoFactor(gin.H{"challenge_token": challenge.ChallengeToken, "code": s.totpCode(email)})
+	expectStatus(t, rec, http.StatusOK)
+	var loggedIn models.User
+	decode(t, rec, &loggedIn)
+	expectStatus(t, s.do(http.MethodGet, "/api/user/profile", nil, loggedIn.Token), http.StatusOK)
+
+	// Recovery codes work once each, in any format
+	recovery := gin.H{"challenge_token": s.loginChallenged(email).ChallengeToken, "recovery_code": " " + confirmed.RecoveryCodes[0] + " "}
+	expectStatus(t, s.verifyTwoFactor(recovery), http.StatusOK)
+	expectError(t, s.verifyTwoFactor(recovery), http.StatusUnauthorized, apierror.CodeTwoFactorInvalid)
+	decode(t, s.do(http.MethodGet, "/api/user/2fa", nil, token), &status)
+	if !status.Enabled || status.Remaining != 9 {
+		t.Fatalf("status = %+v, want enabled with 9 codes left", status)
+	}
+
+	rec = s.do(http.MethodPost, "/api/user/2fa/recovery-codes", gin.H{"code": s.totpCode(email)}, token)
+	expectStatus(t, rec, http.StatusOK)
+	decode(t, rec, &confirmed)
+	if len(confirmed.RecoveryCodes) != 10 {
+		t.Fatalf("new recovery codes = %q", confirmed.RecoveryCodes)
+	}
+
+	expectStatus(t, s.do(http.MethodDelete, "/api/user/2fa", gin.H{"code": s.totpCode(email)}, token), http.StatusOK)
+	expectStatus(t, s.do(http.MethodPost, "/api/auth/login", gin.H{"email": email, "password": "secret123"}, ""), http.StatusOK)
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.RateLimit.Account.Burst = 0
+		cfg.Auth.Lockout.Threshold = 2
+	})
+	token := s.signUp()
+	email := s.profile(token).Email
+
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	decode(t, s.do(http.MethodPost, "/api/user/2fa/enroll", nil, token), &enrollment)
+	code, _ := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	expectStatus(t, s.do(http.MethodPost, "/api/user/2fa/confirm", gin.H{"code": code}, token), http.StatusOK)
+
+	challenge := s.loginChallenged(email).ChallengeToken
+	for i := 0; i < 2; i++ {
+		expectError(t, s.verifyTwoFactor(gin.H{"challenge_token": challenge, "code": "000000"}), http.StatusUnauthorized, apierror.CodeTwoFactorInvalid)
+	}
+	// A locked account can't be told apart from a wrong code
+	rec := s.verifyTwoFactor(gin.H{"challenge_token": challenge, "code": s.totpCode(email)})
+	expectError(t, rec, http.StatusUnauthorized, apierror.CodeTwoFactorInvalid)
+	if rec.Header().Get("Retry-After") != "" {
+		t.Fatal("locked two-factor login reveals the lock with Retry-After")
+	}
+}
+
+func TestTwoFactorConfirmLockout(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.RateLimit.Account.Burst = 0
+		cfg.Auth.Lockout.Threshold = 2
+	})
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := models.User{
+		FirstName: "Ada",
+		LastName:  "Admin",
+		Email:     "admin@example.com",
+		Password:  string(hash),
+		Phone:     "+919111111111",
+		UserID:    primitive.NewObjectID().Hex(),
+		Role:      models.RoleAdmin,
+	}
+	if err := s.users.Create(context.Background(), &admin); err != nil {
+		t.Fatal(err)
+	}
+
+	challenge := s.loginChallenged(admin.Email).ChallengeToken
+	enrollmentCode := otpPattern.FindString(s.outbox.Messages(admin.Email)[0].Body)
+	rec := s.do(http.MethodPost, "/api/auth/2fa/enroll", gin.H{"challenge_token": challenge, "enrollment_code": enrollmentCode}, "")
+	expectStatus(t, rec, http.StatusOK)
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	decode(t, rec, &enrollment)
+
+	confirm := func(code string) *httptest.ResponseRecorder {
+		return s.do(http.MethodPost, "/api/auth/2fa/confirm", gin.H{"challenge_token": challenge, "code": code}, "")
+	}
+	code, _ := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+	for i := 0; i < 2; i++ {
+		expectError(t, confirm(wrongCode(code)), http.StatusUnauthorized, apierror.CodeTwoFactorInvalid)
+	}
+	expectError(t, confirm(code), http.StatusUnauthorized, apierror.CodeTwoFactorInvalid)
+	if user, _ := s.users.FindByEmail(context.Background(), admin.Email); user.TwoFactor.Enabled || user.LockedUntil == nil {
+		t.Fatalf("after a locked confirm two-factor = %+v, locked until %v", user.TwoFactor, user.LockedUntil)
+	}
+}
+
+func TestAdminTwoFactorRequired(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin := models.User{
+		FirstName: "Ada",
+		LastName:  "Admin",
+		Email:     "admin@example.com",
+		Password:  string(hash),
+		Phone:     "+919111111111",
+		UserID:    primitive.NewObjectID().Hex(),
+		Role:      models.RoleAdmin,
+		Sessions:  []models.Session{{ID: "old-session", ExpiresAt: time.Now().Add(time.Hour)}},
+	}
+	if err := s.users.Create(context.Background(), &admin); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sessions from before two-factor are refused
+	old, _, err := s.tokens.Generate(admin.Email, admin.FirstName, admin.LastName, admin.UserID, 0, auth.MethodPassword, "old-session", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectError(t, s.do(http.MethodGet, "/api/user/profile", nil, old), http.StatusForbidden, apierror.CodeTwoFactorRequired)
+
+	challenge := s.loginChallenged(admin.Email)
+	if !challenge.EnrollmentRequired {
+		t.Fatal("admin without two-factor not asked to enroll")
+	}
+
+	// The password alone can't enroll; the code mailed to the admin is needed
+	messages := s.outbox.Messages(admin.Email)
+	if len(messages) != 1 {
+		t.Fatalf("messages = %+v, want an enrollment code", messages)
+	}
+	enrollmentCode := otpPattern.FindString(messages[0].Body)
+	enroll := func(code string) *httptest.ResponseRecorder {
+		return s.do(http.MethodPost, "/api/auth/2fa/enroll", gin.H{"challenge_token": challenge.ChallengeToken, "enrollment_code": code}, "")
+	}
+	expectError(t, s.do(http.MethodPost, "/api/auth/2fa/enroll", gin.H{"challenge_token": challenge.ChallengeToken}, ""), http.StatusBadRequest, apierror.CodeValidation)
+	expectError(t, enroll(wrongCode(enrollmentCode)), http.StatusUnauthorized, apierror.CodeTwoFactorInvalid)
+	if user, _ := s.users.FindByEmail(context.Background(), admin.Email); user.TwoFactor != nil || user.FailedLogins != 1 {
+		t.Fatalf("after a wrong code two-factor = %+v, failed logins = %d", user.TwoFactor, user.FailedLogins)
+	}
+
+	rec := enroll(enrollmentCode)
+	expectStatus(t, rec, http.StatusOK)
+	var enrollment struct {
+		Secret string `json:"secret"`
+	}
+	decode(t, rec, &enrollment)
+	code, _ := totp.Code(enrollment.Secret, totp.Step(time.Now()))
+
+	rec = s.do(http.MethodPost, "/api/auth/2fa/confirm", gin.H{"challenge_token": challenge.ChallengeToken, "code": code}, "")
+	expectStatus(t, rec, http.StatusOK)
+	var confirmed struct {
+		Token         string   `json:"token"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	decode(t, rec, &confirmed)
+	if confirmed.Token == "" || len(confirmed.RecoveryCodes) != 10 {
+		t.Fatalf("confirm = %+v, want a session and recovery codes", confirmed)
+	}
+	expectStatus(t, s.do(http.MethodGet, "/api/user/profile", nil, confirmed.Token), http.StatusOK)
+
+	// Enrolled admins can't turn it off or enroll again at login
+	expectError(t, s.do(http.MethodDelete, "/api/user/2fa", gin.H{"code": s.totpCode(admin.Email)}, confirmed.Token), http.StatusForbidden, apierror.CodeTwoFactorRequired)
+	challenge = s.loginChallenged(admin.Email)
+	if challenge.EnrollmentRequired {
+		t.Fatal("enrolled admin asked to enroll")
+	}
+	expectError(t, s.do(http.MethodPost, "/api/auth/2fa/enroll", gin.H{"challenge_token": challenge.ChallengeToken, "enrollment_code": "123456"}, ""), http.StatusConflict, apierror.CodeTwoFactorEnabled)
+}
@@ -0,0 +1,178 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"ecomm-backend/apierror"
+	"ecomm-backend/config"
+	"ecomm-backend/models"
+)
+
+func (s *testServer) profile(token string) models.User {
+	s.t.Helper()
+
+	rec := s.do(http.MethodGet, "/api/user/profile", nil, token)
+	expectStatus(s.t, rec, http.StatusOK)
+	var user models.User
+	decode(s.t, rec, &user)
+	return user
+}
+
+func (s *testServer) verifyEmail(token string) *httptest.ResponseRecorder {
+	return s.do(http.MethodGet, "/api/auth/verify-email?token="+token, nil, "")
+}
+
+func TestEmailVerification(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token, email := s.signUpUnverified()
+	addressID := s.addAddress(token)
+	checkout := gin.H{"address_id": addressID, "payment_method": "cod"}
+
+	if s.profile(token).EmailVerified {
+		t.Fatal("new account is already verified")
+	}
+	expectError(t, s.do(http.MethodPost, "/api/checkout", checkout, token), http.StatusForbidden, apierror.CodeEmailUnverified)
+	expectError(t, s.do(http.MethodPost, "/api/payment/create-order", gin.H{"order_id": "x"}, token), http.StatusForbidden, apierror.CodeEmailUnverified)
+
+	expectError(t, s.verifyEmail(""), http.StatusBadRequest, apierror.CodeVerifyTokenInvalid)
+	expectError(t, s.verifyEmail("not-a-token"), http.StatusBadRequest, apierror.CodeVerifyTokenInvalid)
+
+	link := s.mailedToken(email)
+	expectStatus(t, s.verifyEmail(link), http.StatusOK)
+	if !s.profile(token).EmailVerified {
+		t.Fatal("email not verified")
+	}
+
+	// Links are single use, and there's nothing left to resend
+	expectError(t, s.verifyEmail(link), http.StatusBadRequest, apierror.CodeVerifyTokenInvalid)
+	expectError(t, s.do(http.MethodPost, "/api/auth/verify-email/resend", nil, token), http.StatusConflict, apierror.CodeEmailVerified)
+
+	// The token from before verification keeps working
+	expectError(t, s.do(http.MethodPost, "/api/checkout", checkout, token), http.StatusBadRequest, apierror.CodeCartEmpty)
+}
+
+func TestResendVerification(t *testing.T) {
+	t.Parallel()
+
+	t.Run("throttled", func(t *testing.T) {
+		s := newTestServer(t)
+		token, _ := s.signUpUnverified()
+
+		rec := s.do(http.MethodPost, "/api/auth/verify-email/resend", nil, token)
+		expectError(t, rec, http.StatusTooManyRequests, apierror.CodeRateLimited)
+		if retry := rec.Header().Get("Retry-After"); retry == "" || retry == "0" {
+			t.Fatalf("Retry-After = %q", retry)
+		}
+	})
+
+	t.Run("replaces the link", func(t *testing.T) {
+		s := newTestServer(t, func(cfg *config.Config) {
+			cfg.Auth.EmailVerification.ResendInterval = 0
+		})
+		token, email := s.signUpUnverified()
+		first := s.mailedToken(email)
+
+		expectStatus(t, s.do(http.MethodPost, "/api/auth/verify-email/resend", nil, token), http.StatusAccepted)
+		second := s.mailedToken(email)
+		if second == first {
+			t.Fatal("resend sent the same link")
+		}
+		expectError(t, s.verifyEmail(first), http.StatusBadRequest, apierror.CodeVerifyTokenInvalid)
+		expectStatus(t, s.verifyEmail(second), http.StatusOK)
+	})
+}
+
+func TestVerificationExpiry(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	_, email := s.signUpUnverified()
+	link := s.mailedToken(email)
+
+	ctx := context.Background()
+	user, err := s.users.FindByEmail(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := *user.EmailVerification
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := s.users.SetEmailVerification(ctx, user.UserID, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	expectError(t, s.verifyEmail(link), http.StatusBadRequest, apierror.CodeVerifyTokenInvalid)
+}
+
+func TestEmailChangeNeedsVerification(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+
+	// Re-saving the same address leaves it verified
+	same := s.profile(token).Email
+	expectStatus(t, s.do(http.MethodPut, "/api/user/profile", gin.H{"email": same}, token), http.StatusOK)
+	if !s.profile(token).EmailVerified {
+		t.Fatal("unchanged email lost its verification")
+	}
+
+	email := "changed-" + same
+	expectStatus(t, s.do(http.MethodPut, "/api/user/profile", gin.H{"email": email}, token), http.StatusOK)
+	if s.profile(token).EmailVerified {
+		t.Fatal("new email is verified without a link")
+	}
+	expectStatus(t, s.verifyEmail(s.mailedToken(email)), http.StatusOK)
+	if !s.profile(token).EmailVerified {
+		t.Fatal("new email not verified")
+	}
+}
+
+func TestProfileConflicts(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t)
+	token := s.signUp()
+	before := s.profile(token)
+	other := s.profile(s.signUp())
+
+	tests := []struct {
+		name string
+		body gin.H
+		code apierror.Code
+	}{
+		{"email", gin.H{"email": other.Email}, apierror.CodeEmailTaken},
+		{"email in another case", gin.H{"email": strings.ToUpper(other.Email)}, apierror.CodeEmailTaken},
+		{"phone", gin.H{"phone": other.Phone}, apierror.CodePhoneTaken},
+		{"both", gin.H{"email": "free-" + other.Email, "phone": other.Phone}, apierror.CodePhoneTaken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectError(t, s.do(http.MethodPut, "/api/user/profile", tt.body, token), http.StatusConflict, tt.code)
+		})
+	}
+
+	if after := s.profile(token); after.Email != before.Email || after.Phone != before.Phone || !after.EmailVerified {
+		t.Fatalf("profile = %+v after conflicts, want it unchanged", after)
+	}
+}
+
+func TestVerificationRequiredFor(t *testing.T) {
+	t.Parallel()
+	s := newTestServer(t, func(cfg *config.Config) {
+		cfg.Auth.EmailVerification.RequiredFor = []string{config.ActionCart, config.ActionAddress}
+	})
+	token, _ := s.signUpUnverified()
+	lamp := s.seedProduct("Desk Lamp", 200, nil)
+
+	expectError(t, s.do(http.MethodPost, "/api/cart", gin.H{"productId": lamp.ProductID}, token), http.StatusForbidden, apierror.CodeEmailUnverified)
+	expectError(t, s.do(http.MethodPost, "/api/address", gin.H{"house_name": "1"}, token), http.StatusForbidden, apierror.CodeEmailUnverified)
+	expectStatus(t, s.do(http.MethodGet, "/api/cart", nil, token), http.StatusOK)
+
+	// Checkout isn't restricted here, so it gets as far as the empty cart
+	expectError(t, s.do(http.MethodPost, "/api/checkout", gin.H{"address_id": "x", "payment_method": "cod"}, token), http.StatusBadRequest, apierror.CodeCartEmpty)
+}
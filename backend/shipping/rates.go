@@ -0,0 +1,82 @@
+package shipping
+
+// DefaultItemWeight (grams) is used for products without a weight.
+const DefaultItemWeight = 500
+
+// Band prices a shipment up to a weight (grams) and/or cart subtotal.
+// A zero limit means the band is unbounded on that dimension.
+type Band struct {
+	MaxWeight   int     `json:"max_weight,omitempty"`
+	MaxSubtotal float64 `json:"max_subtotal,omitempty"`
+	Cost        float64 `json:"cost"`
+}
+
+// ZoneRate is what an option costs and how long it takes within one zone.
+type ZoneRate struct {
+	MinDays int    `json:"min_days"`
+	MaxDays int    `json:"max_days"`
+	Bands   []Band `json:"bands"`
+}
+
+// Option is a delivery service such as standard or express. It is only
+// offered in zones it has a rate for.
+type Option struct {
+	ID        string              `json:"id"`
+	Name      string              `json:"name"`
+	FreeAbove float64             `json:"free_above,omitempty"`
+	Zones     map[string]ZoneRate `json:"zones"`
+}
+
+// Zone groups destination PIN codes by prefix.
+type Zone struct {
+	Name        string   `json:"name"`
+	PinPrefixes []string `json:"pin_prefixes"`
+}
+
+// RateTable is the full shipping configuration. Destinations not matching an
+// explicit zone fall into "local" (same 3-digit PIN prefix as the warehouse),
+// "regional" (same state) or "national".
+type RateTable struct {
+	Zones   []Zone   `json:"zones,omitempty"`
+	Options []Option `json:"options"`
+}
+
+// DefaultRateTable returns the built-in standard and express rates.
+func DefaultRateTable() RateTable {
+	return RateTable{
+		Zones: []Zone{
+			{Name: "remote", PinPrefixes: []string{"18", "19", "737", "744", "79"}},
+		},
+		Options: []Option{
+			{
+				ID:        "standard",
+				Name:      "Standard Delivery",
+				FreeAbove: 499,
+				Zones: map[string]ZoneRate{
+					"local":    {MinDays: 1, MaxDays: 2, Bands: weightBands(40, 60, 100)},
+					"regional": {MinDays: 2, MaxDays: 4, Bands: weightBands(50, 80, 130)},
+					"national": {MinDays: 4, MaxDays: 7, Bands: weightBands(70, 110, 180)},
+					"remote":   {MinDays: 6, MaxDays: 10, Bands: weightBands(100, 150, 250)},
+				},
+			},
+			{
+				ID:   "express",
+				Name: "Express Delivery",
+				Zones: map[string]ZoneRate{
+					"local":    {MinDays: 1, MaxDays: 1, Bands: weightBands(90, 140, 200)},
+					"regional": {MinDays: 1, MaxDays: 2, Bands: weightBands(120, 180, 260)},
+					"national": {MinDays: 2, MaxDays: 3, Bands: weightBands(160, 240, 350)},
+				},
+			},
+		},
+	}
+}
+
+// weightBands builds the default up-to-500g, up-to-2kg and heavier bands.
+func weightBands(upTo500g, upTo2kg, heavier float64) []Band {
+	return []Band{
+		{MaxWeight: 500, Cost: upTo500g},
+		{MaxWeight: 2000, Cost: upTo2kg},
+		{Cost: heavier},
+	}
+}
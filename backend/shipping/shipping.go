@@ -0,0 +1,202 @@
+package shipping
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ecomm-backend/models"
+	"ecomm-backend/tax"
+)
+
+// DefaultOption is used when checkout doesn't name a delivery option.
+const DefaultOption = "standard"
+
+var (
+	ErrUnknownOption     = errors.New("unknown shipping option")
+	ErrOptionUnavailable = errors.New("shipping option is not available for this address")
+)
+
+var (
+	mu        sync.RWMutex
+	table     = DefaultRateTable()
+	originPin = DefaultOriginPin
+)
+
+// DefaultOriginPin is the warehouse PIN code used when none is configured.
+const DefaultOriginPin = "400001"
+
+// Config locates the warehouse and an optional rate table file.
+type Config struct {
+	OriginPin string `yaml:"origin_pin"`
+	RatesFile string `yaml:"rates_file"`
+}
+
+// Setup sets the warehouse PIN code and replaces the default rates with
+// cfg.RatesFile when set.
+func Setup(cfg Config) error {
+	rates := DefaultRateTable()
+	if path := cfg.RatesFile; path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read shipping rates: %w", err)
+		}
+		rates = RateTable{}
+		if err := json.Unmarshal(data, &rates); err != nil {
+			return fmt.Errorf("invalid shipping rates in %s: %w", path, err)
+		}
+		if len(rates.Options) == 0 {
+			return errors.New("shipping rates must define at least one option")
+		}
+	}
+
+	pin := cfg.OriginPin
+	if pin == "" {
+		pin = DefaultOriginPin
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	table = rates
+	originPin = pin
+	return nil
+}
+
+// ZoneFor returns the shipping zone for a destination address.
+func ZoneFor(dest *models.Address) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if dest == nil {
+		return "national"
+	}
+	pin := strings.ReplaceAll(strings.TrimSpace(dest.PinCode), " ", "")
+
+	// Explicit zones win, longest prefix first
+	zone, longest := "", 0
+	for _, z := range table.Zones {
+		for _, prefix := range z.PinPrefixes {
+			if len(prefix) > longest && strings.HasPrefix(pin, prefix) {
+				zone, longest = z.Name, len(prefix)
+			}
+		}
+	}
+	if zone != "" {
+		return zone
+	}
+
+	if len(pin) >= 3 && len(originPin) >= 3 && pin[:3] == originPin[:3] {
+		return "local"
+	}
+	if state := tax.StateForAddress(*dest); state != "" && state == tax.StateForPinCode(originPin) {
+		return "regional"
+	}
+	return "national"
+}
+
+// Options prices every delivery option available for the items and destination.
+func Options(items []models.ProductUser, dest *models.Address, now time.Time) []models.ShippingOption {
+	zone := ZoneFor(dest)
+	weight, subtotal := totals(items)
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	options := []models.ShippingOption{}
+	for _, opt := range table.Options {
+		if quote, ok := price(opt, zone, weight, subtotal, now); ok {
+			options = append(options, quote)
+		}
+	}
+	return options
+}
+
+// Select prices a single option by ID.
+func Select(optionID string, items []models.ProductUser, dest *models.Address, now time.Time) (*models.ShippingOption, error) {
+	if optionID == "" {
+		optionID = DefaultOption
+	}
+	zone := ZoneFor(dest)
+	weight, subtotal := totals(items)
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, opt := range table.Options {
+		if opt.ID != optionID {
+			continue
+		}
+		quote, ok := price(opt, zone, weight, subtotal, now)
+		if !ok {
+			return nil, ErrOptionUnavailable
+		}
+		return &quote, nil
+	}
+	return nil, ErrUnknownOption
+}
+
+func totals(items []models.ProductUser) (int, float64) {
+	weight, subtotal := 0, 0.0
+	for _, item := range items {
+		qty := item.Quantity
+		if qty == 0 {
+			qty = 1
+		}
+		itemWeight := item.Weight
+		if itemWeight == 0 {
+			itemWeight = DefaultItemWeight
+		}
+		weight += itemWeight * qty
+		subtotal += item.Price * float64(qty)
+	}
+	return weight, subtotal
+}
+
+func price(opt Option, zone string, weight int, subtotal float64, now time.Time) (models.ShippingOption, bool) {
+	rate, ok := opt.Zones[zone]
+	if !ok {
+		return models.ShippingOption{}, false
+	}
+
+	var band *Band
+	for i := range rate.Bands {
+		b := &rate.Bands[i]
+		if (b.MaxWeight == 0 || weight <= b.MaxWeight) && (b.MaxSubtotal == 0 || subtotal <= b.MaxSubtotal) {
+			band = b
+			break
+		}
+	}
+	if band == nil {
+		return models.ShippingOption{}, false
+	}
+
+	quote := models.ShippingOption{
+		ID:            opt.ID,
+		Name:          opt.Name,
+		Zone:          zone,
+		Cost:          tax.Round(band.Cost),
+		EstimatedFrom: addBusinessDays(now, rate.MinDays),
+		EstimatedBy:   addBusinessDays(now, rate.MaxDays),
+	}
+	if opt.FreeAbove > 0 && subtotal >= opt.FreeAbove {
+		quote.Cost = 0
+		quote.Free = true
+	}
+	return quote, true
+}
+
+// addBusinessDays skips Sundays, when couriers don't deliver.
+func addBusinessDays(from time.Time, days int) time.Time {
+	date := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, from.Location())
+	for days > 0 {
+		date = date.AddDate(0, 0, 1)
+		if date.Weekday() != time.Sunday {
+			days--
+		}
+	}
+	return date
+}
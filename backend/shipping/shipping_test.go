@@ -0,0 +1,216 @@
+package shipping
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ecomm-backend/models"
+)
+
+// useRates ships from a Mumbai warehouse with the default rates, or with
+// rates when given, for the length of the test. The rates are global, so
+// these tests don't run in parallel.
+func useRates(t *testing.T, rates *RateTable) {
+	t.Helper()
+	cfg := Config{OriginPin: "400001"}
+	if rates != nil {
+		data, err := json.Marshal(rates)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg.RatesFile = filepath.Join(t.TempDir(), "rates.json")
+		if err := os.WriteFile(cfg.RatesFile, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Setup(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Setup(Config{}) })
+}
+
+func TestZoneFor(t *testing.T) {
+	useRates(t, nil)
+
+	tests := []struct {
+		pin  string
+		want string
+	}{
+		{"400020", "local"},
+		{"400 020", "local"},
+		{"411001", "regional"},
+		{"560001", "national"},
+		{"190001", "remote"},
+		{"737101", "remote"},
+		{"", "national"},
+	}
+	for _, tt := range tests {
+		if got := ZoneFor(&models.Address{PinCode: tt.pin}); got != tt.want {
+			t.Errorf("ZoneFor(%q) = %q, want %q", tt.pin, got, tt.want)
+		}
+	}
+	if got := ZoneFor(nil); got != "national" {
+		t.Errorf("ZoneFor(nil) = %q, want national", got)
+	}
+}
+
+func TestZoneForLongestPrefix(t *testing.T) {
+	useRates(t, &RateTable{
+		Zones: []Zone{
+			{Name: "metro", PinPrefixes: []string{"56"}},
+			{Name: "city", PinPrefixes: []string{"5600"}},
+		},
+		Options: DefaultRateTable().Options,
+	})
+
+	for pin, want := range map[string]string{
+		"560001": "city",
+		"561001": "metro",
+		"400020": "local",
+	} {
+		if got := ZoneFor(&models.Address{PinCode: pin}); got != want {
+			t.Errorf("ZoneFor(%q) = %q, want %q", pin, got, want)
+		}
+	}
+}
+
+func TestSelect(t *testing.T) {
+	useRates(t, nil)
+
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	bengaluru := &models.Address{PinCode: "560001"}
+	item := func(price float64, qty, weight int) []models.ProductUser {
+		return []models.ProductUser{{Price: price, Quantity: qty, Weight: weight}}
+	}
+
+	tests := []struct {
+		name     string
+		option   string
+		items    []models.ProductUser
+		dest     *models.Address
+		wantID   string
+		wantCost float64
+		wantFree bool
+		wantErr  error
+	}{
+		{name: "defaults to standard", items: item(100, 1, 300), dest: bengaluru, wantID: "standard", wantCost: 70},
+		{name: "weight adds up across units", option: "standard", items: item(100, 2, 300), dest: bengaluru, wantID: "standard", wantCost: 110},
+		{name: "items without a weight use the default", option: "standard", items: item(100, 2, 0), dest: bengaluru, wantID: "standard", wantCost: 110},
+		{name: "heaviest band is unbounded", option: "standard", items: item(100, 1, 5000), dest: bengaluru, wantID: "standard", wantCost: 180},
+		{name: "free above the threshold", option: "standard", items: item(250, 2, 300), dest: bengaluru, wantID: "standard", wantFree: true},
+		{name: "express has no free threshold", option: "express", items: item(250, 2, 300), dest: bengaluru, wantID: "express", wantCost: 240},
+		{name: "express doesn't serve remote zones", option: "express", items: item(100, 1, 300), dest: &models.Address{PinCode: "744101"}, wantErr: ErrOptionUnavailable},
+		{name: "unknown option", option: "drone", items: item(100, 1, 300), dest: bengaluru, wantErr: ErrUnknownOption},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Select(tt.option, tt.items, tt.dest, now)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("got %+v, %v; want %v", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.ID != tt.wantID || got.Cost != tt.wantCost || got.Free != tt.wantFree {
+				t.Fatalf("got %+v, want %s costing %v (free %v)", got, tt.wantID, tt.wantCost, tt.wantFree)
+			}
+		})
+	}
+}
+
+func TestOptions(t *testing.T) {
+	useRates(t, nil)
+
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	items := []models.ProductUser{{Price: 100, Quantity: 1, Weight: 300}}
+
+	ids := func(options []models.ShippingOption) []string {
+		got := []string{}
+		for _, opt := range options {
+			got = append(got, opt.ID)
+		}
+		return got
+	}
+	if got := ids(Options(items, &models.Address{PinCode: "400020"}, now)); len(got) != 2 || got[0] != "standard" || got[1] != "express" {
+		t.Errorf("local options = %v, want [standard express]", got)
+	}
+	if got := ids(Options(items, &models.Address{PinCode: "190001"}, now)); len(got) != 1 || got[0] != "standard" {
+		t.Errorf("remote options = %v, want [standard]", got)
+	}
+}
+
+func TestUnserviceable(t *testing.T) {
+	// Only local deliveries up to 1kg
+	useRates(t, &RateTable{
+		Options: []Option{{
+			ID:    "standard",
+			Name:  "Standard Delivery",
+			Zones: map[string]ZoneRate{"local": {MinDays: 1, MaxDays: 2, Bands: []Band{{MaxWeight: 1000, Cost: 40}}}},
+		}},
+	})
+
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+	light := []models.ProductUser{{Price: 100, Quantity: 1, Weight: 300}}
+	heavy := []models.ProductUser{{Price: 100, Quantity: 1, Weight: 1500}}
+
+	tests := []struct {
+		name  string
+		items []models.ProductUser
+		pin   string
+		ok    bool
+	}{
+		{"local and light", light, "400020", true},
+		{"outside every zone", light, "560001", false},
+		{"too heavy for any band", heavy, "400020", false},
+	}
+	for _, tt := range tests {
+		dest := &models.Address{PinCode: tt.pin}
+		options := Options(tt.items, dest, now)
+		_, err := Select("", tt.items, dest, now)
+		if tt.ok && (len(options) != 1 || err != nil) {
+			t.Errorf("%s: got %d options, %v; want standard", tt.name, len(options), err)
+		}
+		if !tt.ok && (len(options) != 0 || !errors.Is(err, ErrOptionUnavailable)) {
+			t.Errorf("%s: got %d options, %v; want none", tt.name, len(options), err)
+		}
+	}
+}
+
+func TestDeliveryDates(t *testing.T) {
+	useRates(t, nil)
+
+	day := func(d int) time.Time { return time.Date(2026, 10, d, 0, 0, 0, 0, time.UTC) }
+	saturday := time.Date(2026, 10, 17, 17, 30, 0, 0, time.UTC)
+	wednesday := time.Date(2026, 10, 14, 9, 0, 0, 0, time.UTC)
+	items := []models.ProductUser{{Price: 100, Quantity: 1, Weight: 300}}
+
+	tests := []struct {
+		name     string
+		option   string
+		pin      string
+		now      time.Time
+		from, by time.Time
+	}{
+		{"Sunday is skipped", "standard", "400020", saturday, day(19), day(20)},
+		{"express next business day", "express", "400020", saturday, day(19), day(19)},
+		{"span over a weekend", "standard", "560001", wednesday, day(19), day(22)},
+	}
+	for _, tt := range tests {
+		got, err := Select(tt.option, items, &models.Address{PinCode: tt.pin}, tt.now)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.name, err)
+		}
+		if !got.EstimatedFrom.Equal(tt.from) || !got.EstimatedBy.Equal(tt.by) {
+			t.Errorf("%s: estimated %s to %s, want %s to %s", tt.name,
+				got.EstimatedFrom.Format(time.DateOnly), got.EstimatedBy.Format(time.DateOnly),
+				tt.from.Format(time.DateOnly), tt.by.Format(time.DateOnly))
+		}
+	}
+}
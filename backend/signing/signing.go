@@ -0,0 +1,382 @@
+// Package signing keeps the asymmetric keys that sign access tokens. Keys
+// live as PEM files in a directory shared by every instance, are rotated on
+// a schedule, and stay valid for verification for a while after they stop
+// signing, so rotation never ends a session early.
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// reloadInterval is how often a token signed with an unknown key may make
+// the ring re-read its directory, to pick up a key another instance made.
+const reloadInterval = 30 * time.Second
+
+// Config controls the signing keys. Algorithm is used for new keys; KeysDir
+// holds one PKCS#8 PEM private key per file, named <kid>.pem. When the
+// newest key is older than RotateEvery a new one is made and takes over
+// signing; the previous key is still accepted for RetainFor, which should
+// be at least the longest token lifetime. Without KeysDir a key is made in
+// memory at startup, which only suits a single development instance.
+type Config struct {
+	Algorithm   string        `yaml:"algorithm"`
+	KeysDir     string        `yaml:"keys_dir"`
+	RotateEvery time.Duration `yaml:"rotate_every"`
+	RetainFor   time.Duration `yaml:"retain_for"`
+}
+
+// Validate reports an unknown algorithm or a missing rotation period.
+func (cfg Config) Validate() error {
+	switch cfg.Algorithm {
+	case AlgorithmRS256, AlgorithmEdDSA:
+	default:
+		return fmt.Errorf("signing algorithm must be RS256 or EdDSA; got %q", cfg.Algorithm)
+	}
+	if cfg.RotateEvery <= 0 || cfg.RetainFor <= 0 {
+		return errors.New("signing key rotate_every and retain_for must be positive")
+	}
+	return nil
+}
+
+// Key is one signing key. Created comes from the PEM "Created" header, or
+// the file's modification time for keys made elsewhere.
+type Key struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	Created   time.Time
+}
+
+// Public returns the key's public half.
+func (k *Key) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+// KeyRing holds the signing keys, newest last.
+type KeyRing struct {
+	cfg Config
+	now func() time.Time
+
+	mu         sync.RWMutex
+	keys       []*Key
+	lastReload time.Time
+}
+
+// Open loads the keys in cfg.KeysDir, creating the directory if needed, and
+// makes a first key if there is none or the newest is due for rotation.
+func Open(cfg Config) (*KeyRing, error) {
+	r := &KeyRing{cfg: cfg, now: time.Now}
+	if cfg.KeysDir != "" {
+		if err := os.MkdirAll(cfg.KeysDir, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create keys directory: %w", err)
+		}
+	}
+	if err := r.Rotate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Rotate re-reads the keys directory, makes a new signing key when the
+// newest is older than RotateEvery, and deletes keys past their retention.
+func (r *KeyRing) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.reload(); err != nil {
+		return err
+	}
+	now := r.now()
+	newest := r.newest()
+	if newest == nil || now.Sub(newest.Created) >= r.cfg.RotateEvery {
+		key, err := r.generate(now)
+		if err != nil {
+			return err
+		}
+		r.keys = append(r.keys, key)
+		slog.Info("signing key created", "kid", key.ID, "algorithm", key.Algorithm)
+	}
+
+	// A key retires when the next one is made
+	kept := r.keys[:0]
+	for i, key := range r.keys {
+		if i+1 < len(r.keys) && now.Sub(r.keys[i+1].Created) > r.cfg.RetainFor {
+			if r.cfg.KeysDir != "" {
+				if err := os.Remove(r.path(key.ID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("failed to remove retired key %s: %w", key.ID, err)
+				}
+			}
+			slog.Info("signing key retired", "kid", key.ID)
+			continue
+		}
+		kept = append(kept, key)
+	}
+	r.keys = kept
+	return nil
+}
+
+// Maintain calls Rotate every interval until ctx is done, logging failures.
+func (r *KeyRing) Maintain(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Rotate(); err != nil {
+				slog.Error("failed to rotate signing keys", "error", err)
+			}
+		}
+	}
+}
+
+// Signing returns the key new tokens are signed with.
+func (r *KeyRing) Signing() *Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.newest()
+}
+
+// Verifying returns the key with ID kid if tokens signed with it are still
+// accepted. An unknown kid may be a key another instance just made, so the
+// directory is re-read, at most once per reloadInterval.
+func (r *KeyRing) Verifying(kid string) (*Key, bool) {
+	if key, ok := r.find(kid); ok {
+		return key, true
+	}
+	if r.cfg.KeysDir == "" {
+		return nil, false
+	}
+
+	r.mu.Lock()
+	if r.now().Sub(r.lastReload) >= reloadInterval {
+		if err := r.reload(); err != nil {
+			slog.Error("failed to reload signing keys", "error", err)
+		}
+	}
+	r.mu.Unlock()
+	return r.find(kid)
+}
+
+func (r *KeyRing) find(kid string) (*Key, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	now := r.now()
+	for i, key := range r.keys {
+		if key.ID != kid {
+			continue
+		}
+		if i+1 < len(r.keys) && now.Sub(r.keys[i+1].Created) > r.cfg.RetainFor {
+			return nil, false
+		}
+		return key, true
+	}
+	return nil, false
+}
+
+func (r *KeyRing) newest() *Key {
+	if len(r.keys) == 0 {
+		return nil
+	}
+	return r.keys[len(r.keys)-1]
+}
+
+// reload replaces the keys with those in the directory. Callers hold mu.
+func (r *KeyRing) reload() error {
+	r.lastReload = r.now()
+	if r.cfg.KeysDir == "" {
+		return nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(r.cfg.KeysDir, "*.pem"))
+	if err != nil {
+		return err
+	}
+	keys := make([]*Key, 0, len(paths))
+	for _, path := range paths {
+		key, err := readKey(path)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Created.Equal(keys[j].Created) {
+			return keys[i].ID < keys[j].ID
+		}
+		return keys[i].Created.Before(keys[j].Created)
+	})
+	r.keys = keys
+	return nil
+}
+
+func (r *KeyRing) path(kid string) string {
+	return filepath.Join(r.cfg.KeysDir, kid+".pem")
+}
+
+// generate makes a key with the configured algorithm and, with a keys
+// directory, saves it there.
+func (r *KeyRing) generate(now time.Time) (*Key, error) {
+	var private crypto.Signer
+	var err error
+	switch r.cfg.Algorithm {
+	case AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	key := &Key{
+		ID:        now.UTC().Format("20060102") + "-" + hex.EncodeToString(suffix),
+		Algorithm: r.cfg.Algorithm,
+		Private:   private,
+		Created:   now,
+	}
+	if r.cfg.KeysDir != "" {
+		if err := writeKey(r.path(key.ID), key); err != nil {
+			return nil, err
+		}
+	}
+	return key, nil
+}
+
+// writeKey saves key through a temporary file, so other instances never
+// read half a key.
+func writeKey(path string, key *Key) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return fmt.Errorf("failed to encode signing key: %w", err)
+	}
+	block := &pem.Block{
+		Type:    "PRIVATE KEY",
+		Headers: map[string]string{"Created": key.Created.UTC().Format(time.RFC3339)},
+		Bytes:   der,
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, pem.EncodeToMemory(block), 0o600); err != nil {
+		return fmt.Errorf("failed to write signing key: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write signing key: %w", err)
+	}
+	return nil
+}
+
+func readKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("signing key %s: %w", path, err)
+	}
+
+	key := &Key{ID: strings.TrimSuffix(filepath.Base(path), ".pem")}
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm, key.Private = AlgorithmRS256, private
+	case ed25519.PrivateKey:
+		key.Algorithm, key.Private = AlgorithmEdDSA, private
+	default:
+		return nil, fmt.Errorf("signing key %s must be RSA or Ed25519", path)
+	}
+
+	if created, err := time.Parse(time.RFC3339, block.Headers["Created"]); err == nil {
+		key.Created = created
+	} else {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		key.Created = info.ModTime()
+	}
+	return key, nil
+}
+
+// JWK is a public key in JSON Web Key form (RFC 7517).
+type JWK struct {
+	KeyType   string `json:"kty"`
+	ID        string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKSet is the body of /.well-known/jwks.json.
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public halves of every key still accepted, so other
+// services can verify tokens without holding a secret.
+func (r *KeyRing) JWKS() JWKSet {
+	r.mu.RLock()
+	ids := make([]string, 0, len(r.keys))
+	for _, key := range r.keys {
+		ids = append(ids, key.ID)
+	}
+	r.mu.RUnlock()
+
+	set := JWKSet{Keys: []JWK{}}
+	for _, id := range ids {
+		key, ok := r.find(id)
+		if !ok {
+			continue
+		}
+		jwk := JWK{ID: key.ID, Use: "sig", Algorithm: key.Algorithm}
+		switch public := key.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
@@ -0,0 +1,169 @@
+package signing
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// clock is a settable time source for a KeyRing.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func newRing(t *testing.T, dir string, c *clock) *KeyRing {
+	t.Helper()
+	r := &KeyRing{
+		cfg: Config{Algorithm: AlgorithmEdDSA, KeysDir: dir, RotateEvery: 30 * 24 * time.Hour, RetainFor: 8 * 24 * time.Hour},
+		now: c.now,
+	}
+	if err := r.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	c := &clock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	r := newRing(t, dir, c)
+	first := r.Signing()
+
+	// Not due yet
+	c.t = c.t.Add(29 * 24 * time.Hour)
+	if err := r.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Signing().ID != first.ID {
+		t.Fatal("key rotated before it was due")
+	}
+
+	// The new key signs and the old one is still accepted
+	c.t = c.t.Add(24 * time.Hour)
+	if err := r.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	second := r.Signing()
+	if second.ID == first.ID {
+		t.Fatal("key not rotated")
+	}
+	if _, ok := r.Verifying(first.ID); !ok {
+		t.Fatal("previous key refused right after rotation")
+	}
+	if got := len(r.JWKS().Keys); got != 2 {
+		t.Fatalf("jwks has %d keys, want 2", got)
+	}
+
+	// Past retention the old key is refused and deleted
+	c.t = c.t.Add(8*24*time.Hour + time.Second)
+	if _, ok := r.Verifying(first.ID); ok {
+		t.Fatal("retired key still accepted")
+	}
+	if err := r.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, first.ID+".pem")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("retired key file not removed: %v", err)
+	}
+	if got := len(r.JWKS().Keys); got != 1 {
+		t.Fatalf("jwks has %d keys, want 1", got)
+	}
+}
+
+func TestKeysPersist(t *testing.T) {
+	dir := t.TempDir()
+	c := &clock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	signing := newRing(t, dir, c).Signing()
+
+	// A restart, or another instance, signs with the same key
+	again := newRing(t, dir, c).Signing()
+	if again.ID != signing.ID || !again.Created.Equal(signing.Created) {
+		t.Fatalf("reopened key = %s created %v, want %s created %v", again.ID, again.Created, signing.ID, signing.Created)
+	}
+	info, err := os.Stat(filepath.Join(dir, signing.ID+".pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestVerifyingReloadsForUnknownKey(t *testing.T) {
+	dir := t.TempDir()
+	c := &clock{t: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)}
+	a := newRing(t, dir, c)
+	b := newRing(t, dir, c)
+
+	// b rotates; a learns about the new key from the directory
+	c.t = c.t.Add(31 * 24 * time.Hour)
+	if err := b.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	kid := b.Signing().ID
+	if _, ok := a.Verifying(kid); !ok {
+		t.Fatal("key made by another instance not found")
+	}
+
+	// Unknown keys re-read the directory at most once per reloadInterval
+	next, err := b.generate(c.t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := a.Verifying(next.ID); ok {
+		t.Fatal("directory re-read again within the reload interval")
+	}
+	c.t = c.t.Add(reloadInterval)
+	if _, ok := a.Verifying(next.ID); !ok {
+		t.Fatal("directory not re-read after the reload interval")
+	}
+}
+
+func TestJWKSPublishesRSAKeys(t *testing.T) {
+	r, err := Open(Config{Algorithm: AlgorithmRS256, RotateEvery: time.Hour, RetainFor: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := r.JWKS()
+	if len(set.Keys) != 1 {
+		t.Fatalf("jwks has %d keys, want 1", len(set.Keys))
+	}
+	jwk := set.Keys[0]
+	if jwk.KeyType != "RSA" || jwk.Algorithm != AlgorithmRS256 || jwk.Use != "sig" || jwk.ID != r.Signing().ID {
+		t.Fatalf("jwk = %+v", jwk)
+	}
+
+	n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := r.Signing().Public().(*rsa.PublicKey)
+	if new(big.Int).SetBytes(n).Cmp(public.N) != 0 || int(new(big.Int).SetBytes(e).Int64()) != public.E {
+		t.Fatal("published key does not match the signing key")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	good := Config{Algorithm: AlgorithmEdDSA, RotateEvery: time.Hour, RetainFor: time.Hour}
+	if err := good.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, bad := range []Config{
+		{Algorithm: "HS256", RotateEvery: time.Hour, RetainFor: time.Hour},
+		{Algorithm: AlgorithmRS256, RetainFor: time.Hour},
+		{Algorithm: AlgorithmRS256, RotateEvery: time.Hour},
+	} {
+		if err := bad.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded, want an error", bad)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package tax
+
+import (
+	"strings"
+
+	"ecomm-backend/models"
+)
+
+// DefaultGSTClass is applied to products without a tax class.
+const DefaultGSTClass = "gst_18"
+
+// DefaultGSTRates maps product tax classes to the combined GST percentage.
+var DefaultGSTRates = map[string]float64{
+	"exempt": 0,
+	"gst_0":  0,
+	"gst_5":  5,
+	"gst_12": 12,
+	"gst_18": 18,
+	"gst_28": 28,
+}
+
+// GST implements India's Goods and Services Tax: CGST+SGST for deliveries
+// within the seller's state and IGST for inter-state deliveries.
+type GST struct {
+	OriginState string
+	Rates       map[string]float64
+}
+
+// NewGST returns a GST jurisdiction for a seller registered in originState.
+// originState may be a state code ("KA") or name ("Karnataka") and defaults to MH.
+func NewGST(originState string) *GST {
+	origin := NormalizeState(originState)
+	if origin == "" {
+		origin = "MH"
+	}
+	return &GST{OriginState: origin, Rates: DefaultGSTRates}
+}
+
+func (g *GST) Name() string {
+	return "IN-GST"
+}
+
+func (g *GST) Covers(dest *models.Address) bool {
+	if dest == nil {
+		return true
+	}
+	switch strings.ToUpper(strings.TrimSpace(dest.Country)) {
+	case "", "IN", "IND", "INDIA":
+		return true
+	}
+	return false
+}
+
+func (g *GST) Regions(dest *models.Address) (string, string) {
+	return g.OriginState, g.destinationState(dest)
+}
+
+func (g *GST) LineTax(class string, amount float64, dest *models.Address) []models.TaxComponent {
+	rate := g.rate(class)
+	if rate == 0 {
+		return nil
+	}
+
+	destState := g.destinationState(dest)
+	if destState != "" && destState != g.OriginState {
+		return []models.TaxComponent{
+			{Name: "IGST", Rate: rate, Amount: amount * rate / 100},
+		}
+	}
+
+	half := rate / 2
+	return []models.TaxComponent{
+		{Name: "CGST", Rate: half, Amount: amount * half / 100},
+		{Name: "SGST", Rate: half, Amount: amount * half / 100},
+	}
+}
+
+func (g *GST) rate(class string) float64 {
+	if class == "" {
+		class = DefaultGSTClass
+	}
+	if rate, ok := g.Rates[class]; ok {
+		return rate
+	}
+	return g.Rates[DefaultGSTClass]
+}
+
+// destinationState resolves the delivery state, treating an unknown address
+// as intra-state.
+func (g *GST) destinationState(dest *models.Address) string {
+	if dest == nil {
+		return g.OriginState
+	}
+	if state := StateForAddress(*dest); state != "" {
+		return state
+	}
+	return g.OriginState
+}
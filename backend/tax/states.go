@@ -0,0 +1,118 @@
+package tax
+
+import (
+	"strings"
+
+	"ecomm-backend/models"
+)
+
+// stateNames maps Indian state and union territory codes to their names.
+var stateNames = map[string]string{
+	"AN": "Andaman and Nicobar Islands",
+	"AP": "Andhra Pradesh",
+	"AR": "Arunachal Pradesh",
+	"AS": "Assam",
+	"BR": "Bihar",
+	"CH": "Chandigarh",
+	"CG": "Chhattisgarh",
+	"DN": "Dadra and Nagar Haveli and Daman and Diu",
+	"DL": "Delhi",
+	"GA": "Goa",
+	"GJ": "Gujarat",
+	"HR": "Haryana",
+	"HP": "Himachal Pradesh",
+	"JK": "Jammu and Kashmir",
+	"JH": "Jharkhand",
+	"KA": "Karnataka",
+	"KL": "Kerala",
+	"LA": "Ladakh",
+	"LD": "Lakshadweep",
+	"MP": "Madhya Pradesh",
+	"MH": "Maharashtra",
+	"MN": "Manipur",
+	"ML": "Meghalaya",
+	"MZ": "Mizoram",
+	"NL": "Nagaland",
+	"OD": "Odisha",
+	"PY": "Puducherry",
+	"PB": "Punjab",
+	"RJ": "Rajasthan",
+	"SK": "Sikkim",
+	"TN": "Tamil Nadu",
+	"TS": "Telangana",
+	"TR": "Tripura",
+	"UP": "Uttar Pradesh",
+	"UK": "Uttarakhand",
+	"WB": "West Bengal",
+}
+
+// pinPrefixes maps PIN code prefixes to states. Lookups try the three-digit
+// prefix first so that districts carved out of a postal circle resolve
+// correctly; this is an approximation and Address.State always wins.
+var pinPrefixes = map[string]string{
+	"11": "DL",
+	"12": "HR", "13": "HR",
+	"14": "PB", "15": "PB", "16": "PB", "160": "CH",
+	"17": "HP",
+	"18": "JK", "19": "JK", "194": "LA",
+	"20": "UP", "21": "UP", "22": "UP", "23": "UP", "24": "UP", "25": "UP", "26": "UP", "27": "UP", "28": "UP",
+	"246": "UK", "247": "UK", "248": "UK", "249": "UK", "262": "UK", "263": "UK",
+	"30": "RJ", "31": "RJ", "32": "RJ", "33": "RJ", "34": "RJ",
+	"36": "GJ", "37": "GJ", "38": "GJ", "39": "GJ", "396": "DN",
+	"40": "MH", "41": "MH", "42": "MH", "43": "MH", "44": "MH", "403": "GA",
+	"45": "MP", "46": "MP", "47": "MP", "48": "MP",
+	"49": "CG",
+	"50": "TS",
+	"51": "AP", "52": "AP", "53": "AP",
+	"56": "KA", "57": "KA", "58": "KA", "59": "KA",
+	"60": "TN", "61": "TN", "62": "TN", "63": "TN", "64": "TN", "605": "PY",
+	"67": "KL", "68": "KL", "69": "KL",
+	"70": "WB", "71": "WB", "72": "WB", "73": "WB", "74": "WB", "737": "SK", "744": "AN",
+	"75": "OD", "76": "OD", "77": "OD",
+	"78":  "AS",
+	"790": "AR", "791": "AR", "792": "AR", "793": "ML", "794": "ML", "795": "MN",
+	"796": "MZ", "797": "NL", "798": "NL", "799": "TR",
+	"80": "BR", "84": "BR", "85": "BR",
+	"81": "JH", "82": "JH", "83": "JH", "810": "BR", "811": "BR", "812": "BR", "813": "BR",
+	"821": "BR", "823": "BR", "824": "BR",
+}
+
+// NormalizeState converts a state code or name into its two-letter code.
+// It returns "" for unknown input.
+func NormalizeState(state string) string {
+	state = strings.TrimSpace(state)
+	if state == "" {
+		return ""
+	}
+
+	code := strings.ToUpper(state)
+	if _, ok := stateNames[code]; ok {
+		return code
+	}
+	for code, name := range stateNames {
+		if strings.EqualFold(name, state) {
+			return code
+		}
+	}
+	return ""
+}
+
+// StateForPinCode derives a state code from a six-digit Indian PIN code.
+func StateForPinCode(pin string) string {
+	pin = strings.ReplaceAll(strings.TrimSpace(pin), " ", "")
+	if len(pin) != 6 {
+		return ""
+	}
+	if state, ok := pinPrefixes[pin[:3]]; ok {
+		return state
+	}
+	return pinPrefixes[pin[:2]]
+}
+
+// StateForAddress prefers the address's explicit state and falls back to its PIN code.
+func StateForAddress(addr models.Address) string {
+	if state := NormalizeState(addr.State); state != "" {
+		return state
+	}
+	return StateForPinCode(addr.PinCode)
+}
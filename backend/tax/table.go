@@ -0,0 +1,86 @@
+package tax
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"ecomm-backend/models"
+)
+
+// RateTable is a flat, single-component rule table (e.g. VAT or sales tax)
+// for a country or a set of states/provinces within it.
+//
+// Example TAX_RULES_FILE entry:
+//
+//	{"name": "AE-VAT", "country": "AE", "component": "VAT",
+//	 "default_rate": 5, "rates": {"exempt": 0}}
+type RateTable struct {
+	TableName   string             `json:"name"`
+	Country     string             `json:"country"`
+	States      []string           `json:"states,omitempty"`
+	Component   string             `json:"component"`
+	DefaultRate float64            `json:"default_rate"`
+	Rates       map[string]float64 `json:"rates,omitempty"`
+}
+
+func (t *RateTable) Name() string {
+	return t.TableName
+}
+
+func (t *RateTable) Covers(dest *models.Address) bool {
+	if dest == nil || !strings.EqualFold(strings.TrimSpace(dest.Country), t.Country) {
+		return false
+	}
+	if len(t.States) == 0 {
+		return true
+	}
+	for _, state := range t.States {
+		if strings.EqualFold(strings.TrimSpace(dest.State), state) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *RateTable) Regions(dest *models.Address) (string, string) {
+	if dest == nil {
+		return "", ""
+	}
+	return "", strings.ToUpper(strings.TrimSpace(dest.State))
+}
+
+func (t *RateTable) LineTax(class string, amount float64, dest *models.Address) []models.TaxComponent {
+	rate, ok := t.Rates[class]
+	if !ok {
+		rate = t.DefaultRate
+	}
+	if rate == 0 {
+		return nil
+	}
+	return []models.TaxComponent{
+		{Name: t.Component, Rate: rate, Amount: amount * rate / 100},
+	}
+}
+
+// LoadRules reads a JSON array of rate tables from path and registers them.
+func LoadRules(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var tables []*RateTable
+	if err := json.Unmarshal(data, &tables); err != nil {
+		return fmt.Errorf("invalid tax rules in %s: %w", path, err)
+	}
+
+	for _, t := range tables {
+		if t.TableName == "" || t.Country == "" || t.Component == "" {
+			return fmt.Errorf("tax rule table requires name, country and component")
+		}
+		Register(t)
+	}
+	return nil
+}
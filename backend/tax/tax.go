@@ -0,0 +1,138 @@
+package tax
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"ecomm-backend/models"
+)
+
+// Jurisdiction computes the tax owed on cart lines delivered to addresses it covers.
+type Jurisdiction interface {
+	Name() string
+	Covers(dest *models.Address) bool
+	LineTax(class string, amount float64, dest *models.Address) []models.TaxComponent
+}
+
+// regional is implemented by jurisdictions that can report the origin and
+// destination regions a breakdown was computed for.
+type regional interface {
+	Regions(dest *models.Address) (origin, destination string)
+}
+
+var (
+	mu            sync.RWMutex
+	jurisdictions []Jurisdiction
+)
+
+// Config selects the seller's GST state and an optional rule table file.
+type Config struct {
+	OriginState string `yaml:"origin_state"`
+	RulesFile   string `yaml:"rules_file"`
+}
+
+// Setup registers India GST for the seller's state and any extra rule tables
+// listed in cfg.RulesFile.
+func Setup(cfg Config) error {
+	Reset()
+	Register(NewGST(cfg.OriginState))
+
+	if cfg.RulesFile != "" {
+		if err := LoadRules(cfg.RulesFile); err != nil {
+			return fmt.Errorf("failed to load tax rules: %w", err)
+		}
+	}
+	return nil
+}
+
+// Register adds a jurisdiction. Jurisdictions registered later take
+// precedence, so region-specific tables can override a country-wide rule.
+func Register(j Jurisdiction) {
+	mu.Lock()
+	defer mu.Unlock()
+	jurisdictions = append([]Jurisdiction{j}, jurisdictions...)
+}
+
+// Reset removes all registered jurisdictions.
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	jurisdictions = nil
+}
+
+func lookup(dest *models.Address) Jurisdiction {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, j := range jurisdictions {
+		if j.Covers(dest) {
+			return j
+		}
+	}
+	return nil
+}
+
+// Calculate returns the per-line and aggregated tax for items delivered to
+// dest. A nil dest is treated as a delivery within the seller's own region.
+func Calculate(items []models.ProductUser, dest *models.Address) *models.TaxBreakdown {
+	breakdown := &models.TaxBreakdown{
+		Lines:      []models.TaxLine{},
+		Components: []models.TaxComponent{},
+	}
+
+	j := lookup(dest)
+	if j != nil {
+		breakdown.Jurisdiction = j.Name()
+		if r, ok := j.(regional); ok {
+			breakdown.Origin, breakdown.Destination = r.Regions(dest)
+		}
+	}
+
+	totals := map[string]int{}
+	for _, item := range items {
+		qty := item.Quantity
+		if qty == 0 {
+			qty = 1
+		}
+
+		line := models.TaxLine{
+			ProductID:     item.ProductID,
+			TaxClass:      item.TaxClass,
+			TaxableAmount: Round(item.Price * float64(qty)),
+			Components:    []models.TaxComponent{},
+		}
+
+		if j != nil {
+			for _, comp := range j.LineTax(item.TaxClass, line.TaxableAmount, dest) {
+				comp.Amount = Round(comp.Amount)
+				line.Components = append(line.Components, comp)
+				line.TotalTax += comp.Amount
+
+				// Aggregate by name and rate so mixed-rate carts stay readable
+				key := fmt.Sprintf("%s@%g", comp.Name, comp.Rate)
+				if idx, ok := totals[key]; ok {
+					breakdown.Components[idx].Amount += comp.Amount
+				} else {
+					totals[key] = len(breakdown.Components)
+					breakdown.Components = append(breakdown.Components, comp)
+				}
+			}
+		}
+
+		line.TotalTax = Round(line.TotalTax)
+		breakdown.TotalTax += line.TotalTax
+		breakdown.Lines = append(breakdown.Lines, line)
+	}
+
+	for i := range breakdown.Components {
+		breakdown.Components[i].Amount = Round(breakdown.Components[i].Amount)
+	}
+	breakdown.TotalTax = Round(breakdown.TotalTax)
+
+	return breakdown
+}
+
+// Round rounds an amount to two decimal places (paise/cents).
+func Round(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
@@ -0,0 +1,215 @@
+package tax
+
+import (
+	"slices"
+	"testing"
+
+	"ecomm-backend/models"
+)
+
+// useGST registers GST for a seller in Maharashtra for the length of the
+// test. The registry is global, so these tests don't run in parallel.
+func useGST(t *testing.T) {
+	t.Helper()
+	Reset()
+	Register(NewGST("MH"))
+	t.Cleanup(Reset)
+}
+
+func TestGSTSplit(t *testing.T) {
+	useGST(t)
+
+	mumbai := &models.Address{PinCode: "400020"}
+	bengaluru := &models.Address{PinCode: "560001"}
+
+	tests := []struct {
+		name string
+		item models.ProductUser
+		dest *models.Address
+		want []models.TaxComponent
+	}{
+		{
+			name: "intra-state splits into CGST and SGST",
+			item: models.ProductUser{Price: 1000, Quantity: 1, TaxClass: "gst_18"},
+			dest: mumbai,
+			want: []models.TaxComponent{{Name: "CGST", Rate: 9, Amount: 90}, {Name: "SGST", Rate: 9, Amount: 90}},
+		},
+		{
+			name: "inter-state is IGST",
+			item: models.ProductUser{Price: 1000, Quantity: 1, TaxClass: "gst_18"},
+			dest: bengaluru,
+			want: []models.TaxComponent{{Name: "IGST", Rate: 18, Amount: 180}},
+		},
+		{
+			name: "explicit state beats the PIN code",
+			item: models.ProductUser{Price: 1000, Quantity: 1, TaxClass: "gst_18"},
+			dest: &models.Address{PinCode: "400020", State: "Karnataka"},
+			want: []models.TaxComponent{{Name: "IGST", Rate: 18, Amount: 180}},
+		},
+		{
+			name: "no address is intra-state",
+			item: models.ProductUser{Price: 1000, Quantity: 1, TaxClass: "gst_18"},
+			want: []models.TaxComponent{{Name: "CGST", Rate: 9, Amount: 90}, {Name: "SGST", Rate: 9, Amount: 90}},
+		},
+		{
+			name: "5% band on several units",
+			item: models.ProductUser{Price: 200, Quantity: 3, TaxClass: "gst_5"},
+			dest: mumbai,
+			want: []models.TaxComponent{{Name: "CGST", Rate: 2.5, Amount: 15}, {Name: "SGST", Rate: 2.5, Amount: 15}},
+		},
+		{
+			name: "12% band",
+			item: models.ProductUser{Price: 500, Quantity: 1, TaxClass: "gst_12"},
+			dest: bengaluru,
+			want: []models.TaxComponent{{Name: "IGST", Rate: 12, Amount: 60}},
+		},
+		{
+			name: "28% band",
+			item: models.ProductUser{Price: 100, Quantity: 1, TaxClass: "gst_28"},
+			dest: bengaluru,
+			want: []models.TaxComponent{{Name: "IGST", Rate: 28, Amount: 28}},
+		},
+		{
+			name: "exempt",
+			item: models.ProductUser{Price: 100, Quantity: 1, TaxClass: "exempt"},
+			dest: mumbai,
+			want: []models.TaxComponent{},
+		},
+		{
+			name: "no class uses the default",
+			item: models.ProductUser{Price: 100, Quantity: 1},
+			dest: bengaluru,
+			want: []models.TaxComponent{{Name: "IGST", Rate: 18, Amount: 18}},
+		},
+		{
+			name: "unknown class uses the default",
+			item: models.ProductUser{Price: 100, Quantity: 1, TaxClass: "luxury"},
+			dest: bengaluru,
+			want: []models.TaxComponent{{Name: "IGST", Rate: 18, Amount: 18}},
+		},
+		{
+			name: "zero quantity counts as one",
+			item: models.ProductUser{Price: 100, TaxClass: "gst_18"},
+			dest: bengaluru,
+			want: []models.TaxComponent{{Name: "IGST", Rate: 18, Amount: 18}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Calculate([]models.ProductUser{tt.item}, tt.dest)
+			if got.Jurisdiction != "IN-GST" {
+				t.Fatalf("jurisdiction = %q, want IN-GST", got.Jurisdiction)
+			}
+			if !slices.Equal(got.Components, tt.want) || !slices.Equal(got.Lines[0].Components, tt.want) {
+				t.Fatalf("components = %+v, line components = %+v, want %+v", got.Components, got.Lines[0].Components, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegions(t *testing.T) {
+	useGST(t)
+
+	got := Calculate(nil, &models.Address{PinCode: "560001"})
+	if got.Origin != "MH" || got.Destination != "KA" {
+		t.Fatalf("regions = %s -> %s, want MH -> KA", got.Origin, got.Destination)
+	}
+}
+
+func TestRounding(t *testing.T) {
+	useGST(t)
+
+	// Each line is rounded to paise before the lines are summed, and
+	// components are summed per name and rate
+	items := []models.ProductUser{
+		{ProductID: "a", Price: 99.99, Quantity: 1, TaxClass: "gst_18"},
+		{ProductID: "b", Price: 33.33, Quantity: 1, TaxClass: "gst_18"},
+		{ProductID: "c", Price: 10.10, Quantity: 1, TaxClass: "gst_5"},
+	}
+	got := Calculate(items, &models.Address{PinCode: "400020"})
+
+	wantLines := []float64{18, 6, 0.5}
+	for i, line := range got.Lines {
+		if line.TotalTax != wantLines[i] {
+			t.Errorf("line %s tax = %v, want %v", line.ProductID, line.TotalTax, wantLines[i])
+		}
+	}
+	want := []models.TaxComponent{
+		{Name: "CGST", Rate: 9, Amount: 12},
+		{Name: "SGST", Rate: 9, Amount: 12},
+		{Name: "CGST", Rate: 2.5, Amount: 0.25},
+		{Name: "SGST", Rate: 2.5, Amount: 0.25},
+	}
+	if !slices.Equal(got.Components, want) {
+		t.Errorf("components = %+v, want %+v", got.Components, want)
+	}
+	if got.TotalTax != 24.5 {
+		t.Errorf("total tax = %v, want 24.5", got.TotalTax)
+	}
+}
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		in, want float64
+	}{
+		{10.125, 10.13},
+		{10.124, 10.12},
+		{8.9991, 9},
+		{0, 0},
+	}
+	for _, tt := range tests {
+		if got := Round(tt.in); got != tt.want {
+			t.Errorf("Round(%v) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRateTables(t *testing.T) {
+	useGST(t)
+	Register(&RateTable{TableName: "AE-VAT", Country: "AE", Component: "VAT", DefaultRate: 5, Rates: map[string]float64{"exempt": 0}})
+
+	item := []models.ProductUser{{Price: 200, Quantity: 1, TaxClass: "gst_18"}}
+
+	// A table covers its country; India stays on GST
+	vat := Calculate(item, &models.Address{Country: "AE", State: "Dubai"})
+	if vat.Jurisdiction != "AE-VAT" || !slices.Equal(vat.Components, []models.TaxComponent{{Name: "VAT", Rate: 5, Amount: 10}}) {
+		t.Errorf("AE breakdown = %+v", vat)
+	}
+	if gst := Calculate(item, &models.Address{Country: "India", PinCode: "400020"}); gst.Jurisdiction != "IN-GST" {
+		t.Errorf("India jurisdiction = %q, want IN-GST", gst.Jurisdiction)
+	}
+
+	// Nothing covers the address: no tax
+	none := Calculate(item, &models.Address{Country: "US"})
+	if none.Jurisdiction != "" || none.TotalTax != 0 || len(none.Components) != 0 {
+		t.Errorf("US breakdown = %+v, want no tax", none)
+	}
+}
+
+func TestNormalizeState(t *testing.T) {
+	tests := map[string]string{
+		"ka":          "KA",
+		"Karnataka":   "KA",
+		" tamil nadu": "TN",
+		"Atlantis":    "",
+		"":            "",
+	}
+	for in, want := range tests {
+		if got := NormalizeState(in); got != want {
+			t.Errorf("NormalizeState(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	pins := map[string]string{
+		"400020":  "MH",
+		"403001":  "GA", // three-digit prefixes win
+		"560 001": "KA",
+		"12345":   "",
+	}
+	for pin, want := range pins {
+		if got := StateForPinCode(pin); got != want {
+			t.Errorf("StateForPinCode(%q) = %q, want %q", pin, got, want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The SHA-1 test vectors from RFC 6238 appendix B, truncated to 6 digits.
+func TestCode(t *testing.T) {
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(secret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	code, err := Code(secret, Step(now))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if step, ok := Validate(secret, code, now.Add(Period)); !ok || step != Step(now) {
+		t.Fatalf("code rejected one step later: %d %v", step, ok)
+	}
+	if _, ok := Validate(secret, code, now.Add(3*Period)); ok {
+		t.Fatal("code accepted three steps later")
+	}
+	if _, ok := Validate(secret, code[:5], now); ok {
+		t.Fatal("short code accepted")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Shop", "a@example.com", "ABC")
+	if !strings.HasPrefix(uri, "otpauth://totp/Shop:a@example.com?") || !strings.Contains(uri, "secret=ABC") {
+		t.Fatalf("uri = %s", uri)
+	}
+}
@@ -0,0 +1,114 @@
+package txn
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"ecomm-backend/config"
+)
+
+var tracer = otel.Tracer("ecomm-backend/txn")
+
+// compensateTimeout bounds undoing a failed saga. Compensations don't stop
+// when the request is cancelled, or a client disconnecting mid-checkout
+// would leave stock reserved.
+const compensateTimeout = 30 * time.Second
+
+// Step is one unit of work in a multi-document operation. Compensate undoes
+// whatever Run completed, even partially, and is only used when transactions
+// are unavailable.
+type Step struct {
+	Name       string
+	Run        func(ctx context.Context) error
+	Compensate func(ctx context.Context) error
+}
+
+// Run executes steps atomically. On replica sets and sharded clusters the
+// steps run inside a MongoDB transaction (retried on transient errors). On a
+// standalone server they run as a saga: if a step fails, its compensation and
+// those of the steps before it run in reverse order.
+func Run(ctx context.Context, steps ...Step) error {
+	mode := "saga"
+	if config.SupportsTransactions && config.DB != nil {
+		mode = "transaction"
+	}
+	ctx, span := tracer.Start(ctx, "txn.Run", trace.WithAttributes(attribute.String("txn.mode", mode)))
+	defer span.End()
+
+	var err error
+	if mode == "transaction" {
+		err = runTransaction(ctx, steps)
+	} else {
+		err = runSaga(ctx, steps)
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "txn failed")
+	}
+	return err
+}
+
+// runStep runs fn in a span named after the step. The span context keeps
+// the session of a transaction, so the step's commands still join it.
+func runStep(ctx context.Context, name string, fn func(context.Context) error) error {
+	ctx, span := tracer.Start(ctx, "txn."+name)
+	defer span.End()
+
+	err := fn(ctx)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "step failed")
+	}
+	return err
+}
+
+func runTransaction(ctx context.Context, steps []Step) error {
+	session, err := config.DB.Client().StartSession()
+	if err != nil {
+		return fmt.Errorf("failed to start session: %w", err)
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		for _, step := range steps {
+			if err := runStep(sc, step.Name, step.Run); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	return err
+}
+
+func runSaga(ctx context.Context, steps []Step) error {
+	for i, step := range steps {
+		if err := runStep(ctx, step.Name, step.Run); err != nil {
+			compensate(ctx, steps[:i+1])
+			return err
+		}
+	}
+	return nil
+}
+
+// compensate undoes steps in reverse order, on a context that outlives ctx.
+func compensate(ctx context.Context, steps []Step) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), compensateTimeout)
+	defer cancel()
+
+	for j := len(steps) - 1; j >= 0; j-- {
+		if steps[j].Compensate == nil {
+			continue
+		}
+		if err := runStep(ctx, steps[j].Name+".compensate", steps[j].Compensate); err != nil {
+			slog.ErrorContext(ctx, "failed to compensate step", "step", steps[j].Name, "error", err)
+		}
+	}
+}
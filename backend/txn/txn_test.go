@@ -0,0 +1,67 @@
+package txn
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestSagaCompensatesInReverse(t *testing.T) {
+	var ran []string
+	step := func(name string, err error) Step {
+		return Step{
+			Name: name,
+			Run: func(ctx context.Context) error {
+				ran = append(ran, name)
+				return err
+			},
+			Compensate: func(ctx context.Context) error {
+				ran = append(ran, "undo "+name)
+				return nil
+			},
+		}
+	}
+
+	failed := errors.New("failed")
+	err := Run(context.Background(), step("a", nil), step("b", nil), step("c", failed), step("d", nil))
+	if !errors.Is(err, failed) {
+		t.Fatalf("got %v, want the failing step's error", err)
+	}
+	want := []string{"a", "b", "c", "undo c", "undo b", "undo a"}
+	if !slices.Equal(ran, want) {
+		t.Fatalf("ran %v, want %v", ran, want)
+	}
+}
+
+func TestSagaCompensatesAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var compensateErr error
+	var hasDeadline bool
+	reserve := Step{
+		Name: "reserve",
+		Run:  func(ctx context.Context) error { return nil },
+		Compensate: func(ctx context.Context) error {
+			compensateErr = ctx.Err()
+			_, hasDeadline = ctx.Deadline()
+			return nil
+		},
+	}
+	// The client goes away while the order is being placed
+	place := Step{
+		Name: "place",
+		Run: func(ctx context.Context) error {
+			cancel()
+			return ctx.Err()
+		},
+	}
+
+	if err := Run(ctx, reserve, place); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if compensateErr != nil || !hasDeadline {
+		t.Fatalf("compensation context err = %v, deadline = %v; want live and bounded", compensateErr, hasDeadline)
+	}
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+)
+
+// NewSecretToken returns a random URL-safe token for links sent to users,
+// and the hash to store in its place. Only the user ever sees the token.
+func NewSecretToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashSecretToken(token), nil
+}
+
+// HashSecretToken returns the stored form of a token from NewSecretToken.
+// The tokens carry 256 bits of randomness, so a fast hash is enough.
+func HashSecretToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewOTP returns a random numeric one-time code of the given length.
+func NewOTP(length int) (string, error) {
+	digits := make([]byte, length)
+	for i := range digits {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+		digits[i] = byte('0' + n.Int64())
+	}
+	return string(digits), nil
+}
+
+// HashOTP returns the stored form of a one-time code. A short code is easy
+// to brute force from a plain hash, so it is keyed with a server secret and
+// bound to the user it was issued to.
+func HashOTP(key, userID, code string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(userID + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
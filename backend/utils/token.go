@@ -1,66 +1,184 @@
 package utils
 
 import (
-	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
+
+	"ecomm-backend/signing"
+)
+
+var (
+	ErrTokenExpired = errors.New("token is expired")
+	ErrTokenInvalid = errors.New("invalid token")
 )
 
-var secretKey = getSecretKey()
+// Token lifetimes. A session lasts as long as its refresh token.
+const (
+	AccessTokenTTL  = 24 * time.Hour
+	RefreshTokenTTL = 7 * 24 * time.Hour
+)
 
-func getSecretKey() string {
-	key := os.Getenv("SECRET_LOVE")
-	if key == "" {
-		return "your-secret-key-here"
+const (
+	// clockSkew is how far the clocks of the instances issuing and checking
+	// a token may disagree.
+	clockSkew = 30 * time.Second
+)
+
+// Token types, carried in the typ claim. A token is only accepted where its
+// type is expected, so a refresh token or login challenge never works as an
+// access token.
+const (
+	TypeAccess    = "access"
+	TypeRefresh   = "refresh"
+	TypeChallenge = "2fa_challenge"
+)
+
+// TokenManager signs JWTs with the newest key in its key ring, naming the
+// key in the kid header, and validates them against any key still accepted.
+// Every token names this service as issuer and the API as audience.
+type TokenManager struct {
+	keys     *signing.KeyRing
+	issuer   string
+	audience string
+}
+
+func NewTokenManager(keys *signing.KeyRing, issuer, audience string) *TokenManager {
+	return &TokenManager{keys: keys, issuer: issuer, audience: audience}
+}
+
+// sign signs claims with the current signing key.
+func (m *TokenManager) sign(claims jwt.Claims) (string, error) {
+	key := m.keys.Signing()
+	method := jwt.GetSigningMethod(key.Algorithm)
+	if method == nil {
+		return "", errors.New("unsupported signing algorithm " + key.Algorithm)
 	}
-	return key
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
 }
 
-type Claims struct {
-	Email     string `json:"Email"`
-	FirstName string `json:"First_Name"`
-	LastName  string `json:"Last_Name"`
-	UID       string `json:"Uid"`
-	jwt.RegisteredClaims
+// verificationKey finds the public key a token names. The token must use
+// the algorithm of that key, so a token can't pick a weaker one.
+func (m *TokenManager) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := m.keys.Verifying(kid)
+	if !ok {
+		return nil, ErrTokenInvalid
+	}
+	if token.Method.Alg() != key.Algorithm {
+		return nil, ErrTokenInvalid
+	}
+	return key.Public(), nil
+}
+
+// NewTokenID returns a random token ID, the jti of a token.
+func NewTokenID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
 }
 
-type RefreshClaims struct {
+// registered returns the standard claims for a new token for the user.
+func (m *TokenManager) registered(uid string, ttl time.Duration) (jwt.RegisteredClaims, error) {
+	id, err := NewTokenID()
+	if err != nil {
+		return jwt.RegisteredClaims{}, err
+	}
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Issuer:    m.issuer,
+		Subject:   uid,
+		Audience:  jwt.ClaimStrings{m.audience},
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+		ID:        id,
+	}, nil
+}
+
+// parse verifies a token's signature and standard claims into claims and
+// checks that it has the wanted type and names a user.
+func (m *TokenManager) parse(signedToken string, claims *Claims, typ string) error {
+	_, err := jwt.ParseWithClaims(signedToken, claims, m.verificationKey,
+		jwt.WithIssuer(m.issuer),
+		jwt.WithAudience(m.audience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+	)
+	if errors.Is(err, jwt.ErrTokenExpired) {
+		return ErrTokenExpired
+	}
+	if err != nil || claims.Type != typ || claims.Subject == "" || claims.ID == "" {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+// Claims are the claims of every token this service issues. The user is
+// the subject; only access tokens carry the profile fields.
+type Claims struct {
+	Email     string `json:"Email,omitempty"`
+	FirstName string `json:"First_Name,omitempty"`
+	LastName  string `json:"Last_Name,omitempty"`
+	// Version must match the user's token version for the token to be
+	// accepted; see models.User.TokenVersion.
+	Version int `json:"ver,omitempty"`
+	// Type is one of TypeAccess, TypeRefresh and TypeChallenge.
+	Type string `json:"typ"`
+	// AuthMethod records how the session began; see the auth package.
+	AuthMethod string `json:"auth_method,omitempty"`
+	// SessionID names the session the token belongs to; see models.Session.
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func TokenGenerator(email, firstname, lastname, uid string) (string, string, error) {
-	// Access token - 24 hours
+// Generate returns a new access token and refresh token for the user's
+// session sessionID, begun with method, tied to the user's current token
+// version. The refresh token's ID is refreshID, which the session records
+// so that only its latest refresh token is accepted.
+func (m *TokenManager) Generate(email, firstname, lastname, uid string, version int, method, sessionID, refreshID string) (string, string, error) {
+	accessRegistered, err := m.registered(uid, AccessTokenTTL)
+	if err != nil {
+		return "", "", err
+	}
 	accessClaims := &Claims{
-		Email:     email,
-		FirstName: firstname,
-		LastName:  lastname,
-		UID:       uid,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-		},
+		Email:            email,
+		FirstName:        firstname,
+		LastName:         lastname,
+		Version:          version,
+		Type:             TypeAccess,
+		AuthMethod:       method,
+		SessionID:        sessionID,
+		RegisteredClaims: accessRegistered,
 	}
 
-	// Refresh token - 7 days
-	refreshClaims := &RefreshClaims{
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
-		},
+	refreshRegistered, err := m.registered(uid, RefreshTokenTTL)
+	if err != nil {
+		return "", "", err
+	}
+	refreshRegistered.ID = refreshID
+	refreshClaims := &Claims{
+		Version:          version,
+		Type:             TypeRefresh,
+		AuthMethod:       method,
+		SessionID:        sessionID,
+		RegisteredClaims: refreshRegistered,
 	}
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims)
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims)
-
-	accessTokenString, err := accessToken.SignedString([]byte(secretKey))
+	accessTokenString, err := m.sign(accessClaims)
 	if err != nil {
 		return "", "", err
 	}
 
-	refreshTokenString, err := refreshToken.SignedString([]byte(secretKey))
+	refreshTokenString, err := m.sign(refreshClaims)
 	if err != nil {
 		return "", "", err
 	}
@@ -68,50 +186,42 @@ func TokenGenerator(email, firstname, lastname, uid string) (string, string, err
 	return accessTokenString, refreshTokenString, nil
 }
 
-func ValidateToken(signedToken string) (map[string]interface{}, error) {
-	token, err := jwt.ParseWithClaims(signedToken, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secretKey), nil
-	})
-
-	if err != nil {
+// Validate parses an access token and returns its claims. The user ID is
+// the subject.
+func (m *TokenManager) Validate(signedToken string) (*Claims, error) {
+	claims := &Claims{}
+	if err := m.parse(signedToken, claims, TypeAccess); err != nil {
 		return nil, err
 	}
+	return claims, nil
+}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		// Check expiration
-		if claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(time.Now()) {
-			return nil, errors.New("token is expired")
-		}
-
-		return map[string]interface{}{
-			"email":      claims.Email,
-			"first_name": claims.FirstName,
-			"last_name":  claims.LastName,
-			"uid":        claims.UID,
-		}, nil
+// GenerateChallenge returns a login challenge token for the user, valid for
+// ttl. It proves the password step only.
+func (m *TokenManager) GenerateChallenge(uid string, version int, ttl time.Duration) (string, error) {
+	registered, err := m.registered(uid, ttl)
+	if err != nil {
+		return "", err
 	}
-
-	return nil, errors.New("invalid token")
+	return m.sign(&Claims{Version: version, Type: TypeChallenge, RegisteredClaims: registered})
 }
 
-func UpdateAllTokens(signedToken, signedRefreshToken, userID string, userCollection *mongo.Collection) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	update := bson.M{
-		"$set": bson.M{
-			"token":        signedToken,
-			"refresh_token": signedRefreshToken,
-			"updatedAt":     time.Now(),
-		},
+// ValidateChallenge parses a login challenge token and returns the user ID
+// and token version it was issued for.
+func (m *TokenManager) ValidateChallenge(signedToken string) (string, int, error) {
+	claims := &Claims{}
+	if err := m.parse(signedToken, claims, TypeChallenge); err != nil {
+		return "", 0, err
 	}
-
-	_, err := userCollection.UpdateOne(
-		ctx,
-		bson.M{"user_id": userID},
-		update,
-	)
-
-	return err
+	return claims.Subject, claims.Version, nil
 }
 
+// ValidateRefresh parses a refresh token and returns its claims. The caller
+// still has to check the token version and session against the user.
+func (m *TokenManager) ValidateRefresh(signedToken string) (*Claims, error) {
+	claims := &Claims{}
+	if err := m.parse(signedToken, claims, TypeRefresh); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
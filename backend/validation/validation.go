@@ -0,0 +1,131 @@
+package validation
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+
+	"ecomm-backend/apierror"
+)
+
+var (
+	e164Pattern      = regexp.MustCompile(`^\+[1-9][0-9]{7,14}$`)
+	indianPinPattern = regexp.MustCompile(`^[1-9][0-9]{5}$`)
+	postalPattern    = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9 -]{1,9}$`)
+)
+
+// The custom tags are registered on gin's validator as soon as the package is
+// imported, so any request struct can use them in its binding tags:
+//
+//	phone   - E.164 phone number, e.g. +919876543210
+//	pincode - six-digit Indian PIN code, or a generic postal code when the
+//	          struct's Country field names another country
+func init() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	// Report fields by their JSON/query names rather than Go names
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		for _, tag := range []string{"json", "form"} {
+			name := strings.SplitN(f.Tag.Get(tag), ",", 2)[0]
+			if name != "" && name != "-" {
+				return name
+			}
+		}
+		return f.Name
+	})
+
+	v.RegisterValidation("phone", func(fl validator.FieldLevel) bool {
+		return e164Pattern.MatchString(fl.Field().String())
+	})
+	v.RegisterValidation("pincode", validatePinCode)
+}
+
+func validatePinCode(fl validator.FieldLevel) bool {
+	pin := strings.TrimSpace(fl.Field().String())
+
+	country := ""
+	if parent := fl.Parent(); parent.Kind() == reflect.Struct {
+		if field := parent.FieldByName("Country"); field.IsValid() && field.Kind() == reflect.String {
+			country = strings.ToUpper(strings.TrimSpace(field.String()))
+		}
+	}
+
+	switch country {
+	case "", "IN", "IND", "INDIA":
+		return indianPinPattern.MatchString(pin)
+	}
+	return postalPattern.MatchString(pin)
+}
+
+// Error converts a binding error into a validation error with one detail per
+// offending field.
+func Error(err error) *apierror.Error {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		details := make([]apierror.FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			details = append(details, apierror.FieldError{Field: fe.Field(), Message: message(fe)})
+		}
+
+		msg := "Request validation failed"
+		if len(details) == 1 {
+			msg = details[0].Field + " " + details[0].Message
+		}
+		return apierror.Validation(msg, details...)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return apierror.Validation("Request validation failed", apierror.FieldError{
+			Field:   typeErr.Field,
+			Message: "must be a " + typeErr.Type.String(),
+		})
+	}
+
+	if errors.Is(err, io.EOF) {
+		return apierror.Validation("Request body is required")
+	}
+	return apierror.Validation("Request body is not valid JSON").Wrap(err)
+}
+
+func message(fe validator.FieldError) string {
+	isString := fe.Kind() == reflect.String
+
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "phone":
+		return "must be an E.164 phone number such as +919876543210"
+	case "pincode":
+		return "must be a valid PIN code"
+	case "oneof":
+		return "must be one of: " + strings.ReplaceAll(fe.Param(), " ", ", ")
+	case "min", "gte":
+		if isString {
+			return fmt.Sprintf("must be at least %s characters", fe.Param())
+		}
+		return "must be at least " + fe.Param()
+	case "max", "lte":
+		if isString {
+			return fmt.Sprintf("must be at most %s characters", fe.Param())
+		}
+		return "must be at most " + fe.Param()
+	case "gt":
+		return "must be greater than " + fe.Param()
+	case "len":
+		return fmt.Sprintf("must be exactly %s characters", fe.Param())
+	}
+	return "is invalid"
+}
@@ -2,7 +2,7 @@ import { useCart } from '../context/CartContext'
 import { useAuth } from '../context/AuthContext'
 import { placeOrder } from '../services/orderAPI'
 import { getAddresses } from '../services/addressAPI'
-import { createPaymentOrder, verifyPayment, initiateRazorpayPayment } from '../services/paymentAPI'
+import { verifyPayment, initiateRazorpayPayment } from '../services/paymentAPI'
 import { useState, useEffect } from 'react'
 import { useNavigate } from 'react-router-dom'
 
@@ -58,39 +58,13 @@ export default function Checkout() {
 
     setPlacing(true)
     try {
-      // Step 1: Create Razorpay order
-      console.log('Creating payment order...')
-      
-      // Prepare clean data for backend
-      const orderData = {
-        amount: Number(total),
-        items: items.map(item => {
-          const cleanItem = {
-            product_id: String(item.product_id || item.Product_ID || item._id || ''),
-            product_name: String(item.product_name || item.Product_Name || 'Product'),
-            price: Number(item.price || 0),
-            quantity: Number(item.quantity || 1)
-          }
-          console.log('Cleaned item:', cleanItem)
-          return cleanItem
-        }),
-        address: {
-          house_name: String(selectedAddress.house_name || selectedAddress.House || ''),
-          street_name: String(selectedAddress.street_name || selectedAddress.Street || ''),
-          city_name: String(selectedAddress.city_name || selectedAddress.City || ''),
-          pin_code: String(selectedAddress.pin_code || selectedAddress.Pincode || '')
-        }
-      }
-      
-      console.log('=== CHECKOUT DEBUG ===')
-      console.log('Total:', total)
-      console.log('Items count:', items.length)
-      console.log('Raw items:', items)
-      console.log('Cleaned order data:', orderData)
-      console.log('Order data JSON:', JSON.stringify(orderData))
-      console.log('=====================')
-      
-      const paymentOrderData = await createPaymentOrder(orderData)
+      // Step 1: Place the order; digital orders return a Razorpay order to pay
+      console.log('Placing order...')
+      const order = await placeOrder({
+        addressId: selectedAddress._id,
+        paymentMethod: 'digital',
+      })
+      const paymentOrderData = order.payment
 
       console.log('Payment order created:', paymentOrderData)
 
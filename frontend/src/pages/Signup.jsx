@@ -35,7 +35,7 @@ export default function Signup() {
         </div>
         <input className="w-full border rounded p-2" placeholder="Email" value={form.email} onChange={(e)=>setForm({...form, email: e.target.value})} />
         <input className="w-full border rounded p-2" placeholder="Password" type="password" value={form.password} onChange={(e)=>setForm({...form, password: e.target.value})} />
-        <input className="w-full border rounded p-2" placeholder="Phone" value={form.phone} onChange={(e)=>setForm({...form, phone: e.target.value})} />
+        <input className="w-full border rounded p-2" placeholder="Phone (e.g. +919876543210)" value={form.phone} onChange={(e)=>setForm({...form, phone: e.target.value})} />
         {error && <p className="text-red-600 text-sm">{error}</p>}
         <button disabled={loading} className="bg-black text-white px-4 py-2 rounded disabled:opacity-50">{loading ? 'Creating...' : 'Create account'}</button>
       </form>
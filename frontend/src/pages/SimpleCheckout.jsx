@@ -1,6 +1,7 @@
-import { useState } from 'react'
+import { useState, useEffect } from 'react'
 import { useCart } from '../context/CartContext'
 import { placeOrder } from '../services/orderAPI'
+import { getAddresses } from '../services/addressAPI'
 import ReceiptModal from '../components/ReceiptModal'
 
 export default function SimpleCheckout() {
@@ -10,6 +11,12 @@ export default function SimpleCheckout() {
   const [error, setError] = useState('')
   const [receipt, setReceipt] = useState(null)
   const [showReceipt, setShowReceipt] = useState(false)
+  const [addresses, setAddresses] = useState([])
+
+  // Orders ship to the first saved address and are paid on delivery
+  useEffect(() => {
+    getAddresses().then(setAddresses).catch(() => setAddresses([]))
+  }, [])
 
   const total = items.reduce((sum, it) => sum + (it.price || 0) * (it.quantity || 1), 0)
 
@@ -37,9 +44,19 @@ export default function SimpleCheckout() {
       return
     }
 
+    if (addresses.length === 0) {
+      setError('Please add a delivery address before checking out')
+      setLoading(false)
+      return
+    }
+
     try {
       // Call checkout API with cartItems
-      const receiptData = await placeOrder(items)
+      const receiptData = await placeOrder({
+        addressId: addresses[0]._id,
+        paymentMethod: 'cod',
+        cartItems: items,
+      })
       
       // Show receipt modal
       setReceipt(receiptData)
@@ -1,8 +1,14 @@
 import api from '../lib/api'
 
-export const placeOrder = async (cartItems) => {
-  // Assignment requirement: POST /api/checkout with {cartItems} → {total, timestamp}
-  const { data } = await api.post('/checkout', { cartItems })
+export const placeOrder = async ({ addressId, paymentMethod = 'cod', shippingOption, cartItems } = {}) => {
+  // POST /api/checkout with a saved address and payment method → receipt
+  // Digital orders come back as pending_payment with a `payment` order to complete
+  const { data } = await api.post('/checkout', {
+    address_id: addressId,
+    payment_method: paymentMethod,
+    shipping_option: shippingOption,
+    cartItems,
+  })
   return data
 }
 